@@ -1,10 +1,12 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"time"
 
+	"sqlclient-export-import/internal/auth"
 	"sqlclient-export-import/internal/config"
 	"sqlclient-export-import/internal/handlers"
 
@@ -29,7 +31,9 @@ func main() {
 	createDirectories(cfg)
 
 	// Initialize handlers with config
-	handlers.Initialize(cfg)
+	if err := handlers.Initialize(cfg); err != nil {
+		log.Fatalf("Failed to initialize handlers: %v", err)
+	}
 
 	// Set up the template engine
 	engine := html.New(cfg.TemplateDir, ".html")
@@ -39,6 +43,13 @@ func main() {
 	engine.AddFunc("currentYear", func() string {
 		return time.Now().Format("2006")
 	})
+	engine.AddFunc("humanBytes", humanBytes)
+	engine.AddFunc("formatTime", func(t time.Time) string {
+		return t.Format("2006-01-02 15:04:05")
+	})
+	engine.AddFunc("profiles", handlers.ProfileOptions)
+	engine.AddFunc("storages", handlers.StorageOptions)
+	engine.AddFunc("restorable", handlers.RestoreOptions)
 
 	// Create a new Fiber app
 	app := fiber.New(fiber.Config{
@@ -50,6 +61,8 @@ func main() {
 		IdleTimeout:           10 * time.Minute,       // Increase idle timeout
 		DisableStartupMessage: false,                  // Show startup message
 		StreamRequestBody:     true,                   // Enable streaming request body for large files
+		Immutable:             true,                   // Form values are used by background jobs after the request ends
+		PassLocalsToViews:     true,                   // The layout shows the logged in user
 		ErrorHandler: func(c *fiber.Ctx, err error) error {
 			// Handle 404 errors
 			if err != nil {
@@ -105,21 +118,103 @@ func main() {
 }
 
 func setupRoutes(app *fiber.App) {
+	// Every route other than the login page needs a session whose role
+	// allows it
+	viewer := handlers.Require(auth.RoleViewer)
+	operator := handlers.Require(auth.RoleOperator)
+	admin := handlers.Require(auth.RoleAdmin)
+
+	// Login routes
+	app.Get("/login", handlers.LoginPageHandler)
+	app.Post("/login", handlers.LoginHandler)
+	app.Post("/logout", handlers.LogoutHandler)
+
 	// Home route
-	app.Get("/", handlers.HomeHandler)
+	app.Get("/", viewer, handlers.HomeHandler)
 
 	// Database routes
 	dbGroup := app.Group("/db")
-	dbGroup.Get("/export", handlers.ExportPageHandler)
-	dbGroup.Post("/export", handlers.ExportDatabaseHandler)
-	dbGroup.Get("/download", handlers.DownloadExportHandler)
-	dbGroup.Get("/import", handlers.ImportPageHandler)
-	dbGroup.Post("/import", handlers.ImportDatabaseHandler)
+	dbGroup.Get("/export", operator, handlers.ExportPageHandler)
+	dbGroup.Post("/export", operator, handlers.ExportDatabaseHandler)
+	dbGroup.Post("/export/tables", operator, handlers.ExportTablesHandler)
+	dbGroup.Get("/download", operator, handlers.DownloadExportHandler)
+	dbGroup.Get("/import", operator, handlers.ImportPageHandler)
+	dbGroup.Post("/import", operator, handlers.ImportDatabaseHandler)
+	dbGroup.Get("/import/csv", operator, handlers.CSVImportPageHandler)
+	dbGroup.Post("/import/csv/preview", operator, handlers.CSVPreviewHandler)
+	dbGroup.Post("/import/csv", operator, handlers.CSVImportHandler)
+
+	// Background job routes
+	app.Get("/jobs", viewer, handlers.JobsPageHandler)
+	app.Get("/jobs/:id", viewer, handlers.JobHandler)
+	app.Get("/jobs/:id/events", viewer, handlers.JobEventsHandler)
+	app.Post("/jobs/:id/cancel", operator, handlers.CancelJobHandler)
+
+	// Export catalog routes
+	app.Get("/exports", viewer, handlers.ExportsPageHandler)
+	app.Get("/exports/:id", viewer, handlers.ExportEntryHandler)
+	app.Get("/exports/:id/download", operator, handlers.DownloadEntryHandler)
+	app.Post("/exports/:id/verify", operator, handlers.VerifyEntryHandler)
+	app.Post("/exports/:id/delete", admin, handlers.DeleteEntryHandler)
+
+	// Connection profile routes
+	app.Get("/profiles", admin, handlers.ProfilesPageHandler)
+	app.Get("/profiles/new", admin, handlers.NewProfileHandler)
+	app.Post("/profiles", admin, handlers.SaveProfileHandler)
+	app.Get("/profiles/:id", admin, handlers.ProfileHandler)
+	app.Post("/profiles/:id", admin, handlers.SaveProfileHandler)
+	app.Post("/profiles/:id/delete", admin, handlers.DeleteProfileHandler)
+
+	// Backup schedule routes
+	app.Get("/schedules", viewer, handlers.SchedulesPageHandler)
+	app.Get("/schedules/new", admin, handlers.NewScheduleHandler)
+	app.Post("/schedules", admin, handlers.SaveScheduleHandler)
+	app.Get("/schedules/:id", admin, handlers.ScheduleHandler)
+	app.Post("/schedules/:id", admin, handlers.SaveScheduleHandler)
+	app.Post("/schedules/:id/run", operator, handlers.RunScheduleHandler)
+	app.Post("/schedules/:id/delete", admin, handlers.DeleteScheduleHandler)
 
 	// Database management routes
-	dbGroup.Get("/manage", handlers.ManagePageHandler)
-	dbGroup.Post("/manage/list", handlers.ListDatabasesHandler)
-	dbGroup.Post("/manage/operation", handlers.DatabaseOperationHandler)
+	dbGroup.Get("/manage", viewer, handlers.ManagePageHandler)
+	dbGroup.Post("/manage/list", viewer, handlers.ListDatabasesHandler)
+	dbGroup.Post("/manage/operation", admin, handlers.DatabaseOperationHandler)
+
+	// User routes
+	app.Get("/users", admin, handlers.UsersPageHandler)
+	app.Get("/users/new", admin, handlers.NewUserHandler)
+	app.Post("/users", admin, handlers.SaveUserHandler)
+	app.Get("/users/:id", admin, handlers.UserHandler)
+	app.Post("/users/:id", admin, handlers.SaveUserHandler)
+	app.Post("/users/:id/delete", admin, handlers.DeleteUserHandler)
+
+	// Audit log
+	app.Get("/audit", admin, handlers.AuditPageHandler)
+
+	// API token routes, for the tokens of the logged in user
+	app.Get("/tokens", viewer, handlers.TokensPageHandler)
+	app.Post("/tokens", viewer, handlers.CreateTokenHandler)
+	app.Post("/tokens/:id/delete", viewer, handlers.DeleteTokenHandler)
+
+	// JSON API, authenticated with API tokens. Errors are sent as JSON error
+	// objects.
+	apiViewer := handlers.RequireToken(auth.RoleViewer)
+	apiOperator := handlers.RequireToken(auth.RoleOperator)
+	apiAdmin := handlers.RequireToken(auth.RoleAdmin)
+
+	api := app.Group("/api/v1", handlers.APIErrors)
+	api.Post("/exports", apiOperator, handlers.APIStartExportHandler)
+	api.Get("/exports", apiViewer, handlers.APIExportsHandler)
+	api.Get("/exports/:id", apiViewer, handlers.APIExportHandler)
+	api.Get("/exports/:id/download", apiOperator, handlers.APIDownloadExportHandler)
+	api.Post("/exports/:id/verify", apiOperator, handlers.APIVerifyExportHandler)
+	api.Post("/exports/:id/restore", apiOperator, handlers.APIRestoreExportHandler)
+	api.Post("/imports", apiOperator, handlers.APIImportHandler)
+	api.Get("/jobs/:id", apiViewer, handlers.APIJobHandler)
+	api.Post("/jobs/:id/cancel", apiOperator, handlers.APICancelJobHandler)
+	api.Post("/databases/list", apiViewer, handlers.APIListDatabasesHandler)
+	api.Post("/databases", apiAdmin, handlers.APICreateDatabaseHandler)
+	api.Post("/databases/rename", apiAdmin, handlers.APIRenameDatabaseHandler)
+	api.Post("/databases/drop", apiAdmin, handlers.APIDropDatabaseHandler)
 }
 
 func createDirectories(cfg *config.Config) {
@@ -132,4 +227,28 @@ func createDirectories(cfg *config.Config) {
 	if err := os.MkdirAll(cfg.UploadDirectory, 0755); err != nil {
 		log.Fatalf("Failed to create upload directory: %v", err)
 	}
+
+	// Create SQLite data directory
+	if err := os.MkdirAll(cfg.SQLiteDirectory, 0755); err != nil {
+		log.Fatalf("Failed to create SQLite directory: %v", err)
+	}
+
+	// Create application data directory
+	if err := os.MkdirAll(cfg.DataDirectory, 0755); err != nil {
+		log.Fatalf("Failed to create data directory: %v", err)
+	}
+}
+
+// humanBytes formats a byte count for display, e.g. 1536 becomes "1.5 KB"
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
 }
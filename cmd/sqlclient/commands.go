@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"sqlclient-export-import/internal/handlers"
+	"sqlclient-export-import/internal/manifest"
+	"sqlclient-export-import/internal/models"
+	"sqlclient-export-import/internal/storage"
+)
+
+// exportCommand exports a database into the export directory, where it is
+// recorded in the export catalog like the exports of the server
+func exportCommand(ctx context.Context, args []string) (*output, error) {
+	fs := newFlagSet("export", "Export a database into the export directory and print the path of the file.")
+	conn := addConnectionFlags(fs, "")
+	options := addExportFlags(fs)
+	var recipients stringList
+	fs.Var(&recipients, "recipient", "age (age1...) or SSH public key to encrypt the export to (repeatable)")
+	passphraseFile := fs.String("passphrase-file", "", "file holding a passphrase to encrypt the export with")
+	storageName := fs.String("storage", "", "storage to write the export to: local, s3 or sftp (default: STORAGE)")
+	if err := parse(fs, args); err != nil {
+		return nil, err
+	}
+
+	form, database, err := conn.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	exportForm, err := options.form(form, database)
+	if err != nil {
+		return nil, err
+	}
+	exportForm.Storage = *storageName
+	exportForm.Recipients = strings.Join(recipients, "\n")
+	if exportForm.Passphrase, err = readSecret(*passphraseFile); err != nil {
+		return nil, err
+	}
+	job, err := handlers.Export(exportForm, actor())
+	if err != nil {
+		return nil, err
+	}
+
+	out := wait(ctx, job)
+	out.Database = database
+	out.File = out.Job.Result["location"]
+	return out, nil
+}
+
+// importCommand imports an SQL file, which may be compressed and
+// encrypted, into a database
+func importCommand(ctx context.Context, args []string) (*output, error) {
+	fs := newFlagSet("import", "Import an SQL file, plain or compressed with gzip or zstd, into a database.\nEncrypted files are decrypted with -identity-file or -passphrase-file.")
+	conn := addConnectionFlags(fs, "")
+	file := fs.String("file", "", "SQL file to import")
+	identityFile := fs.String("identity-file", "", "file holding the age identities or SSH private key that decrypt the file")
+	passphraseFile := fs.String("passphrase-file", "", "file holding the passphrase that decrypts the file")
+	if err := parse(fs, args); err != nil {
+		return nil, err
+	}
+	if *file == "" {
+		return nil, usageError("-file is required")
+	}
+
+	form, database, err := conn.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	importForm := models.ImportForm{ConnectionForm: form, Database: database}
+	if importForm.Identity, err = readSecret(*identityFile); err != nil {
+		return nil, err
+	}
+	if importForm.Passphrase, err = readSecret(*passphraseFile); err != nil {
+		return nil, err
+	}
+	job, err := handlers.Import(ctx, importForm, *file, actor())
+	if err != nil {
+		return nil, err
+	}
+
+	out := wait(ctx, job)
+	out.Database = database
+	return out, nil
+}
+
+// restoreCommand imports a catalogued SQL export straight from its
+// storage into a database
+func restoreCommand(ctx context.Context, args []string) (*output, error) {
+	fs := newFlagSet("restore", "Import an SQL export of the export catalog into a database, straight from its storage.\nThe target may be another server or database than the export's source.")
+	conn := addConnectionFlags(fs, "")
+	export := fs.Int64("export", 0, "ID of the export in the export catalog")
+	identityFile := fs.String("identity-file", "", "file holding the age identities or SSH private key that decrypt the export")
+	passphraseFile := fs.String("passphrase-file", "", "file holding the passphrase that decrypts the export")
+	if err := parse(fs, args); err != nil {
+		return nil, err
+	}
+	if *export == 0 {
+		return nil, usageError("-export is required")
+	}
+
+	form, database, err := conn.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	importForm := models.ImportForm{ConnectionForm: form, Database: database, Export: *export}
+	if importForm.Identity, err = readSecret(*identityFile); err != nil {
+		return nil, err
+	}
+	if importForm.Passphrase, err = readSecret(*passphraseFile); err != nil {
+		return nil, err
+	}
+	job, err := handlers.Restore(ctx, importForm, actor())
+	if err != nil {
+		return nil, err
+	}
+
+	out := wait(ctx, job)
+	out.Database = database
+	return out, nil
+}
+
+// listCommand lists the user databases on a server
+func listCommand(ctx context.Context, args []string) (*output, error) {
+	fs := newFlagSet("list", "List the databases of a server.")
+	conn := addConnectionFlags(fs, "")
+	if err := parse(fs, args); err != nil {
+		return nil, err
+	}
+
+	form, _, err := conn.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	databases, err := handlers.ListDatabases(ctx, form, actor())
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(databases))
+	for i, database := range databases {
+		names[i] = database.Name
+	}
+	return &output{Databases: names}, nil
+}
+
+// createCommand creates a database
+func createCommand(ctx context.Context, args []string) (*output, error) {
+	fs := newFlagSet("create", "Create the database named by -database.")
+	conn := addConnectionFlags(fs, "")
+	if err := parse(fs, args); err != nil {
+		return nil, err
+	}
+
+	form, database, err := conn.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dbOp := models.DatabaseOperation{ConnectionForm: form, NewDatabase: database, Operation: "create"}
+	if err := handlers.CreateDatabase(ctx, dbOp, actor()); err != nil {
+		return nil, err
+	}
+	return &output{Database: database}, nil
+}
+
+// renameCommand renames a database
+func renameCommand(ctx context.Context, args []string) (*output, error) {
+	fs := newFlagSet("rename", "Rename the database named by -database to -new-database.")
+	conn := addConnectionFlags(fs, "")
+	newDatabase := fs.String("new-database", "", "new name of the database")
+	if err := parse(fs, args); err != nil {
+		return nil, err
+	}
+
+	form, database, err := conn.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dbOp := models.DatabaseOperation{ConnectionForm: form, Database: database, NewDatabase: *newDatabase, Operation: "rename"}
+	job, err := handlers.RenameDatabase(ctx, dbOp, actor())
+	if err != nil {
+		return nil, err
+	}
+
+	out := wait(ctx, job)
+	out.Database = *newDatabase
+	return out, nil
+}
+
+// dropCommand drops a database. It has to be confirmed with -yes.
+func dropCommand(ctx context.Context, args []string) (*output, error) {
+	fs := newFlagSet("drop", "Drop the database named by -database.")
+	conn := addConnectionFlags(fs, "")
+	yes := fs.Bool("yes", false, "confirm that the database and all its data are deleted")
+	if err := parse(fs, args); err != nil {
+		return nil, err
+	}
+	if !*yes {
+		return nil, usageError("dropping a database deletes all its data; confirm with -yes")
+	}
+
+	form, database, err := conn.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dbOp := models.DatabaseOperation{ConnectionForm: form, Database: database, Operation: "drop"}
+	if err := handlers.DropDatabase(ctx, dbOp, actor()); err != nil {
+		return nil, err
+	}
+	return &output{Database: database}, nil
+}
+
+// copyCommand copies a database into another one. Without a target server
+// the copy stays on the source server.
+func copyCommand(ctx context.Context, args []string) (*output, error) {
+	fs := newFlagSet("copy", "Copy a database into another one by streaming an SQL export into an import.\nWithout -to-dsn, -to-profile, -to-type or -to-host the copy stays on the source server.")
+	from := addConnectionFlags(fs, "from-")
+	to := addConnectionFlags(fs, "to-")
+	options := addExportFlags(fs)
+	create := fs.Bool("create", false, "create the target database first")
+	if err := parse(fs, args); err != nil {
+		return nil, err
+	}
+
+	fromConn, fromDatabase, err := from.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	toConn, toDatabase, err := to.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if to.empty() {
+		toConn = fromConn
+	}
+	exportForm, err := options.form(fromConn, fromDatabase)
+	if err != nil {
+		return nil, err
+	}
+
+	if *create {
+		dbOp := models.DatabaseOperation{ConnectionForm: toConn, NewDatabase: toDatabase, Operation: "create"}
+		if err := handlers.CreateDatabase(ctx, dbOp, actor()); err != nil {
+			return nil, err
+		}
+	}
+	job, err := handlers.Copy(ctx, exportForm, models.ImportForm{ConnectionForm: toConn, Database: toDatabase}, actor())
+	if err != nil {
+		return nil, err
+	}
+
+	out := wait(ctx, job)
+	out.Database = toDatabase
+	return out, nil
+}
+
+// verifyCommand re-hashes an export file and compares it with its manifest
+func verifyCommand(ctx context.Context, args []string) (*output, error) {
+	fs := newFlagSet("verify", "Check an export file against the checksum and size in its manifest.\nA bare file name is looked up in the export directory, or in the storage named by -storage.")
+	file := fs.String("file", "", "export file to verify")
+	storageName := fs.String("storage", "", "storage holding the file: local, s3 or sftp")
+	if err := parse(fs, args); err != nil {
+		return nil, err
+	}
+	if *file == "" {
+		return nil, usageError("-file is required")
+	}
+
+	var store storage.Storage
+	name := filepath.Base(*file)
+	if *storageName != "" {
+		var err error
+		if store, err = storage.Get(*storageName); err != nil {
+			return nil, usageError(err.Error())
+		}
+	} else if _, err := os.Stat(*file); errors.Is(err, os.ErrNotExist) && name == *file {
+		store, _ = storage.Get(storage.Local)
+	} else {
+		store = storage.NewLocal(filepath.Dir(*file))
+	}
+	verification, err := manifest.Verify(ctx, store, name)
+	if err != nil {
+		return nil, err
+	}
+	return &output{File: store.Location(name), Verification: verification}, nil
+}
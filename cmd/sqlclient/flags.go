@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"sqlclient-export-import/internal/handlers"
+	"sqlclient-export-import/internal/models"
+)
+
+// stringList is a flag that may be given more than once
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// newFlagSet creates the flags of a command, described by summary in its
+// help
+func newFlagSet(name, summary string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: sqlclient %s [flags]\n\n%s\n\nFlags:\n", name, summary)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse parses the arguments of a command, which takes no positional ones
+func parse(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return usageError(err.Error())
+	}
+	if fs.NArg() > 0 {
+		return usageError("unexpected argument " + fs.Arg(0))
+	}
+	return nil
+}
+
+// connectionFlags name a database server and a database on it, as the
+// fields of the connection forms, a connection profile or a DSN. The flags
+// of the forms override those of the DSN.
+type connectionFlags struct {
+	prefix string // "from-" and "to-" for the two sides of a copy
+
+	dsn      string
+	profile  string
+	dbType   string
+	host     string
+	port     string
+	username string
+	password string
+	database string
+}
+
+// addConnectionFlags adds the connection flags to fs, their names starting
+// with prefix
+func addConnectionFlags(fs *flag.FlagSet, prefix string) *connectionFlags {
+	f := &connectionFlags{prefix: prefix}
+	fs.StringVar(&f.dsn, prefix+"dsn", "", "connection URL such as postgres://user@host:5432/db, mysql://user@host/db or sqlite:name.db")
+	fs.StringVar(&f.profile, prefix+"profile", "", "name or ID of a saved connection profile")
+	fs.StringVar(&f.dbType, prefix+"type", "", "database type: mysql, mariadb, postgres or sqlite")
+	fs.StringVar(&f.host, prefix+"host", "", "database server host")
+	fs.StringVar(&f.port, prefix+"port", "", "database server port (default: the engine's)")
+	fs.StringVar(&f.username, prefix+"username", "", "database user")
+	fs.StringVar(&f.password, prefix+"password", "", "database password; prefer "+f.passwordEnv()+", which other users cannot see")
+	fs.StringVar(&f.database, prefix+"database", "", "database name")
+	return f
+}
+
+// passwordEnv is the environment variable read when no password is given
+func (f *connectionFlags) passwordEnv() string {
+	return "SQLCLIENT_" + strings.ToUpper(strings.ReplaceAll(f.prefix, "-", "_")) + "PASSWORD"
+}
+
+// empty reports whether none of the flags naming a server are set
+func (f *connectionFlags) empty() bool {
+	return f.dsn == "" && f.profile == "" && f.dbType == "" && f.host == ""
+}
+
+// resolve returns the connection and the database the flags name
+func (f *connectionFlags) resolve(ctx context.Context) (models.ConnectionForm, string, error) {
+	var conn models.ConnectionForm
+	var database string
+	if f.dsn != "" {
+		if f.profile != "" {
+			return conn, "", usageError("-" + f.prefix + "dsn and -" + f.prefix + "profile cannot be combined")
+		}
+		var err error
+		if conn, database, err = parseDSN(f.dsn); err != nil {
+			return conn, "", err
+		}
+	}
+	if f.profile != "" {
+		id, err := handlers.ProfileID(ctx, f.profile)
+		if err != nil {
+			return conn, "", err
+		}
+		conn.Profile = id
+	}
+
+	override(&conn.Type, f.dbType)
+	override(&conn.Host, f.host)
+	override(&conn.Port, f.port)
+	override(&conn.Username, f.username)
+	override(&conn.Password, f.password)
+	override(&database, f.database)
+	if conn.Password == "" {
+		conn.Password = os.Getenv(f.passwordEnv())
+	}
+	return conn, database, nil
+}
+
+// override sets *field to value unless value is empty
+func override(field *string, value string) {
+	if value != "" {
+		*field = value
+	}
+}
+
+// dsnTypes maps the schemes of connection URLs to database types
+var dsnTypes = map[string]string{
+	"mysql":      "mysql",
+	"mariadb":    "mariadb",
+	"postgres":   "postgres",
+	"postgresql": "postgres",
+	"sqlite":     "sqlite",
+	"sqlite3":    "sqlite",
+}
+
+// parseDSN parses a connection URL into a connection and the database in
+// its path. SQLite URLs name a file of the SQLite directory, as in
+// sqlite:shop.db or sqlite:///shop.db.
+func parseDSN(dsn string) (models.ConnectionForm, string, error) {
+	var conn models.ConnectionForm
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return conn, "", usageError("invalid DSN: " + err.Error())
+	}
+	dbType, ok := dsnTypes[strings.ToLower(u.Scheme)]
+	if !ok {
+		return conn, "", usageError("unsupported DSN scheme " + u.Scheme + "; use mysql, mariadb, postgres or sqlite")
+	}
+	conn.Type = dbType
+
+	if dbType == "sqlite" {
+		database := u.Opaque
+		if database == "" {
+			database = strings.TrimPrefix(u.Path, "/")
+		}
+		return conn, database, nil
+	}
+	conn.Host = u.Hostname()
+	conn.Port = u.Port()
+	if u.User != nil {
+		conn.Username = u.User.Username()
+		conn.Password, _ = u.User.Password()
+	}
+	return conn, strings.TrimPrefix(u.Path, "/"), nil
+}
+
+// readSecret reads a passphrase or key from the file at path, so that it
+// never appears in the arguments other users can see. A trailing newline
+// is dropped.
+func readSecret(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", usageError(err.Error())
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// exportFlags are the options of the export form
+type exportFlags struct {
+	engine      string
+	compression string
+	format      string
+	archive     string
+	header      bool
+	delimiter   string
+	null        string
+	dateFormat  string
+	mode        string
+	skip        stringList
+	tables      stringList
+	include     string
+	exclude     string
+	where       stringList
+}
+
+// addExportFlags adds the export options to fs
+func addExportFlags(fs *flag.FlagSet) *exportFlags {
+	f := &exportFlags{}
+	fs.StringVar(&f.engine, "engine", "", `export engine: "external" (mysqldump, pg_dump, sqlite3) or "native"`)
+	fs.StringVar(&f.compression, "compression", "", "compression: none, gzip or zstd")
+	fs.StringVar(&f.format, "format", "", "format: sql, or csv, ndjson or json for one file per table")
+	fs.StringVar(&f.archive, "archive", "", "archive of the per-table files: zip or tar")
+	fs.BoolVar(&f.header, "header", false, "write a CSV header row with the column names")
+	fs.StringVar(&f.delimiter, "delimiter", "", `CSV field delimiter, "tab" for a tab`)
+	fs.StringVar(&f.null, "null", "", "CSV text written for NULL values")
+	fs.StringVar(&f.dateFormat, "date-format", "", "date format: rfc3339, datetime or unix")
+	fs.StringVar(&f.mode, "mode", "", `"full", "schema" for the DDL only or "data" for the rows only`)
+	fs.Var(&f.skip, "skip", "objects to leave out: routines, triggers, events, views or sequences (repeatable)")
+	fs.Var(&f.tables, "table", "table to export (repeatable; default: every table)")
+	fs.StringVar(&f.include, "include", "", "more table names or glob patterns, comma separated")
+	fs.StringVar(&f.exclude, "exclude", "", "table names or glob patterns to leave out, comma separated")
+	fs.Var(&f.where, "where", `row filter as "table:condition", a SQL condition without WHERE (repeatable)`)
+	return f
+}
+
+// form returns the export form of the options for database on conn
+func (f *exportFlags) form(conn models.ConnectionForm, database string) (models.ExportForm, error) {
+	exportForm := models.ExportForm{
+		ConnectionForm: conn,
+		Database:       database,
+		Engine:         f.engine,
+		Compression:    f.compression,
+		Format:         f.format,
+		Archive:        f.archive,
+		Header:         f.header,
+		Delimiter:      f.delimiter,
+		Null:           f.null,
+		DateFormat:     f.dateFormat,
+		Mode:           f.mode,
+		Skip:           f.skip,
+		Tables:         f.tables,
+		Include:        f.include,
+		Exclude:        f.exclude,
+	}
+	for _, where := range f.where {
+		table, condition, ok := strings.Cut(where, ":")
+		if !ok || strings.TrimSpace(table) == "" {
+			return exportForm, usageError(`row filters are given as "table:condition"`)
+		}
+		exportForm.WhereTables = append(exportForm.WhereTables, strings.TrimSpace(table))
+		exportForm.WhereConditions = append(exportForm.WhereConditions, condition)
+	}
+	return exportForm, nil
+}
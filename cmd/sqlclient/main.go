@@ -0,0 +1,223 @@
+// Command sqlclient exports, imports and manages databases without the web
+// server. It runs the same operations with the same options, connection
+// profiles, export catalog and audit log as the server, and prints its
+// result as JSON.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"os/user"
+	"syscall"
+
+	"sqlclient-export-import/internal/audit"
+	"sqlclient-export-import/internal/config"
+	"sqlclient-export-import/internal/handlers"
+	"sqlclient-export-import/internal/jobs"
+	"sqlclient-export-import/internal/manifest"
+
+	"github.com/joho/godotenv"
+)
+
+// Exit codes
+const (
+	exitOK          = 0
+	exitFailed      = 1   // the operation failed
+	exitUsage       = 2   // invalid flags or options
+	exitInterrupted = 130 // cancelled by SIGINT or SIGTERM
+)
+
+const usage = `Usage: sqlclient [global flags] <command> [flags]
+
+Commands:
+  export   Export a database into the export directory
+  import   Import an SQL file into a database
+  restore  Import an export of the export catalog into a database
+  list     List the databases of a server
+  create   Create a database
+  rename   Rename a database
+  drop     Drop a database
+  copy     Copy a database into another one, on the same or another server
+  verify   Check an export file against its manifest
+
+Run "sqlclient <command> -h" for the flags of a command.
+
+Global flags:
+`
+
+// command runs a subcommand with its arguments
+type command func(ctx context.Context, args []string) (*output, error)
+
+var commands = map[string]command{
+	"export":  exportCommand,
+	"import":  importCommand,
+	"restore": restoreCommand,
+	"list":    listCommand,
+	"create":  createCommand,
+	"rename":  renameCommand,
+	"drop":    dropCommand,
+	"copy":    copyCommand,
+	"verify":  verifyCommand,
+}
+
+// output is the JSON printed on stdout once a command finishes
+type output struct {
+	Command   string         `json:"command"`
+	OK        bool           `json:"ok"`
+	Database  string         `json:"database,omitempty"`
+	Databases []string       `json:"databases,omitempty"`
+	File      string         `json:"file,omitempty"` // path of an export file
+	Job       *jobs.Snapshot `json:"job,omitempty"`
+
+	Verification *manifest.Verification `json:"verification,omitempty"`
+
+	Error *outputError `json:"error,omitempty"`
+}
+
+// outputError describes why a command failed, with the codes of the JSON
+// API where they apply
+type outputError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// usageError is a mistake in the flags of a command
+type usageError string
+
+func (e usageError) Error() string { return string(e) }
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	global := flag.NewFlagSet("sqlclient", flag.ContinueOnError)
+	global.Usage = func() {
+		fmt.Fprint(global.Output(), usage)
+		global.PrintDefaults()
+	}
+	dataDir := global.String("data-dir", "", "application data directory (overrides DATA_DIR)")
+	exportDir := global.String("export-dir", "", "export directory (overrides EXPORT_DIR)")
+	sqliteDir := global.String("sqlite-dir", "", "SQLite database directory (overrides SQLITE_DIR)")
+	verbose := global.Bool("v", false, "log what the operations do to stderr")
+	if err := global.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if global.NArg() == 0 {
+		global.Usage()
+		return exitUsage
+	}
+	name := global.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		return finish(name, nil, usageError("unknown command "+name))
+	}
+
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	// Use the configuration of the server, so that both share the catalog,
+	// the profiles and the audit log
+	godotenv.Load()
+	cfg := config.New()
+	if *dataDir != "" {
+		cfg.DataDirectory = *dataDir
+	}
+	if *exportDir != "" {
+		cfg.ExportDirectory = *exportDir
+	}
+	if *sqliteDir != "" {
+		cfg.SQLiteDirectory = *sqliteDir
+	}
+	for _, dir := range []string{cfg.ExportDirectory, cfg.UploadDirectory, cfg.SQLiteDirectory, cfg.DataDirectory} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return finish(name, nil, err)
+		}
+	}
+	if err := handlers.Open(cfg); err != nil {
+		return finish(name, nil, err)
+	}
+
+	// Interrupting the client cancels the running operation
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	out, err := cmd(ctx, global.Args()[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	return finish(name, out, err)
+}
+
+// finish prints the outcome of a command as JSON and returns the exit code
+func finish(name string, out *output, err error) int {
+	if out == nil {
+		out = &output{}
+	}
+	out.Command = name
+
+	status := exitOK
+	var usageErr usageError
+	switch {
+	case errors.As(err, &usageErr) || handlers.InvalidRequest(err):
+		out.Error = &outputError{Code: "invalid_request", Message: err.Error()}
+		status = exitUsage
+	case errors.Is(err, context.Canceled):
+		out.Error = &outputError{Code: "cancelled", Message: "The operation was cancelled"}
+		status = exitInterrupted
+	case err != nil:
+		out.Error = &outputError{Code: "operation_failed", Message: err.Error()}
+		status = exitFailed
+	case out.Job != nil && out.Job.Status == jobs.StatusCancelled:
+		out.Error = &outputError{Code: "cancelled", Message: "The job was cancelled"}
+		status = exitInterrupted
+	case out.Job != nil && out.Job.Status == jobs.StatusFailed:
+		out.Error = &outputError{Code: "operation_failed", Message: out.Job.Error}
+		status = exitFailed
+	case out.Verification != nil && !out.Verification.OK:
+		out.Error = &outputError{Code: "checksum_mismatch", Message: out.Verification.Problem}
+		status = exitFailed
+	}
+	out.OK = out.Error == nil
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(out); err != nil {
+		return exitFailed
+	}
+	return status
+}
+
+// wait waits until job finishes, cancelling it when ctx is done, and
+// returns its final state
+func wait(ctx context.Context, job *jobs.Job) *output {
+	select {
+	case <-job.Done():
+	case <-ctx.Done():
+		job.Cancel()
+		<-job.Done()
+	}
+	snapshot := job.Snapshot()
+	return &output{Job: &snapshot}
+}
+
+// actor names the user running the client in the audit log and the export
+// catalog
+func actor() audit.Actor {
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	return audit.Actor{Name: "cli:" + name}
+}
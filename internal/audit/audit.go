@@ -0,0 +1,255 @@
+// Package audit keeps an append-only record of the database operations run
+// through the server, with who ran them and how they ended, in a SQLite
+// database.
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// Actions recorded in the log
+const (
+	ActionExport   = "export"
+	ActionImport   = "import"
+	ActionDownload = "download"
+	ActionList     = "list"
+	ActionCreate   = "create"
+	ActionRename   = "rename"
+	ActionDrop     = "drop"
+)
+
+// Actions lists every action, for filtering
+var Actions = []string{ActionExport, ActionImport, ActionDownload, ActionList, ActionCreate, ActionRename, ActionDrop}
+
+// Outcomes of an operation
+const (
+	OutcomeSucceeded = "succeeded"
+	OutcomeFailed    = "failed"
+	OutcomeCancelled = "cancelled"
+)
+
+// Outcomes lists every outcome, for filtering
+var Outcomes = []string{OutcomeSucceeded, OutcomeFailed, OutcomeCancelled}
+
+// redacted replaces the values of parameters that hold secrets
+const redacted = "[redacted]"
+
+// The triggers reject any change to recorded entries
+const schema = `CREATE TABLE IF NOT EXISTS audit (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	time        INTEGER NOT NULL,
+	actor       TEXT NOT NULL,
+	client_ip   TEXT NOT NULL,
+	action      TEXT NOT NULL,
+	type        TEXT NOT NULL,
+	host        TEXT NOT NULL,
+	port        TEXT NOT NULL,
+	database    TEXT NOT NULL,
+	params      TEXT NOT NULL,
+	outcome     TEXT NOT NULL,
+	error       TEXT NOT NULL,
+	duration_ms INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS audit_time ON audit (time);
+CREATE TRIGGER IF NOT EXISTS audit_no_update BEFORE UPDATE ON audit
+BEGIN
+	SELECT RAISE(ABORT, 'the audit log is append-only');
+END;
+CREATE TRIGGER IF NOT EXISTS audit_no_delete BEFORE DELETE ON audit
+BEGIN
+	SELECT RAISE(ABORT, 'the audit log is append-only');
+END;`
+
+// columns lists the columns of the audit table in the order scan reads
+// them
+const columns = "id, time, actor, client_ip, action, type, host, port, database, params, outcome, error, duration_ms"
+
+// Actor identifies who started an operation: a user and the client IP
+// they came from, or a schedule
+type Actor struct {
+	Name string `json:"actor"`
+	IP   string `json:"clientIp,omitempty"`
+}
+
+// Entry records one operation
+type Entry struct {
+	ID   int64     `json:"id"`
+	Time time.Time `json:"time"` // when the operation started
+	Actor
+	Action   string            `json:"action"`
+	Type     string            `json:"type,omitempty"`
+	Host     string            `json:"host,omitempty"`
+	Port     string            `json:"port,omitempty"`
+	Database string            `json:"database,omitempty"`
+	Params   map[string]string `json:"params,omitempty"`
+	Outcome  string            `json:"outcome"`
+	Error    string            `json:"error,omitempty"`
+	Duration time.Duration     `json:"durationMs"`
+}
+
+// MarshalJSON writes the duration in milliseconds
+func (e Entry) MarshalJSON() ([]byte, error) {
+	type entry Entry
+	return json.Marshal(struct {
+		entry
+		Duration int64 `json:"durationMs"`
+	}{entry(e), e.Duration.Milliseconds()})
+}
+
+// Filter narrows a listing. Empty fields match every entry.
+type Filter struct {
+	Actor    string
+	Action   string
+	Database string
+	Outcome  string
+
+	// Query matches entries whose actor, client IP, host, database,
+	// parameters or error contain it
+	Query string
+
+	// Since and Until bound the time of the entries
+	Since time.Time
+	Until time.Time
+
+	// Limit caps the number of entries, newest first. Zero means no limit.
+	Limit int
+}
+
+// Log is the audit log
+type Log struct {
+	db *sql.DB
+}
+
+// Open opens the audit log stored in the SQLite file at path, creating it
+// if needed
+func Open(path string) (*Log, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Log{db: db}, nil
+}
+
+// Close closes the log
+func (l *Log) Close() error {
+	return l.db.Close()
+}
+
+// Record appends e to the log, setting its ID. Parameters whose name
+// suggests a secret are redacted first.
+func (l *Log) Record(ctx context.Context, e *Entry) error {
+	e.Params = Redact(e.Params)
+	params, err := json.Marshal(e.Params)
+	if err != nil {
+		return err
+	}
+	result, err := l.db.ExecContext(ctx, `INSERT INTO audit (time, actor, client_ip, action, type, host, port, database,
+			params, outcome, error, duration_ms)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.Time.UnixMilli(), e.Name, e.IP, e.Action, e.Type, e.Host, e.Port, e.Database,
+		string(params), e.Outcome, e.Error, e.Duration.Milliseconds())
+	if err != nil {
+		return err
+	}
+	e.ID, err = result.LastInsertId()
+	return err
+}
+
+// List returns the entries matching f, newest first
+func (l *Log) List(ctx context.Context, f Filter) ([]Entry, error) {
+	var where []string
+	var args []any
+	for _, condition := range []struct{ column, value string }{
+		{"actor", f.Actor},
+		{"action", f.Action},
+		{"database", f.Database},
+		{"outcome", f.Outcome},
+	} {
+		if condition.value != "" {
+			where = append(where, condition.column+" = ?")
+			args = append(args, condition.value)
+		}
+	}
+	if f.Query != "" {
+		var matches []string
+		for _, column := range []string{"actor", "client_ip", "host", "database", "params", "error"} {
+			matches = append(matches, column+` LIKE ? ESCAPE '\'`)
+			args = append(args, "%"+likeReplacer.Replace(f.Query)+"%")
+		}
+		where = append(where, "("+strings.Join(matches, " OR ")+")")
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "time >= ?")
+		args = append(args, f.Since.UnixMilli())
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "time < ?")
+		args = append(args, f.Until.UnixMilli())
+	}
+
+	query := "SELECT " + columns + " FROM audit"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY time DESC, id DESC"
+	if f.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, f.Limit)
+	}
+
+	rows, err := l.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scan(rows)
+}
+
+// likeReplacer escapes the wildcards of a LIKE pattern
+var likeReplacer = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// Redact returns params with the values of parameters whose name mentions
+// a password, passphrase, secret, token or identity replaced
+func Redact(params map[string]string) map[string]string {
+	for name := range params {
+		lower := strings.ToLower(name)
+		if strings.Contains(lower, "password") || strings.Contains(lower, "passphrase") || strings.Contains(lower, "secret") ||
+			strings.Contains(lower, "token") || strings.Contains(lower, "identity") {
+			params[name] = redacted
+		}
+	}
+	return params
+}
+
+// scan reads every row of rows into entries and closes rows
+func scan(rows *sql.Rows) ([]Entry, error) {
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var e Entry
+		var params string
+		var when, duration int64
+		if err := rows.Scan(&e.ID, &when, &e.Name, &e.IP, &e.Action, &e.Type, &e.Host, &e.Port, &e.Database,
+			&params, &e.Outcome, &e.Error, &duration); err != nil {
+			return nil, err
+		}
+		e.Time = time.UnixMilli(when)
+		e.Duration = time.Duration(duration) * time.Millisecond
+		if err := json.Unmarshal([]byte(params), &e.Params); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
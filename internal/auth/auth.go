@@ -0,0 +1,325 @@
+// Package auth stores the local users of the web UI, with bcrypt hashes of
+// their passwords, their login sessions and their API tokens in a SQLite
+// database.
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	_ "modernc.org/sqlite"
+)
+
+// Role grants a user access to a set of routes. Each role includes the
+// permissions of the ones below it.
+type Role string
+
+// Roles, from the least to the most privileged
+const (
+	RoleViewer   Role = "viewer"   // lists databases, jobs, exports and schedules
+	RoleOperator Role = "operator" // also exports, imports and downloads
+	RoleAdmin    Role = "admin"    // also creates, renames and drops databases and manages profiles, schedules and users
+)
+
+// Roles lists every role, from the least to the most privileged
+var Roles = []Role{RoleViewer, RoleOperator, RoleAdmin}
+
+// rank orders the roles; unknown roles rank zero
+func (r Role) rank() int {
+	for i, role := range Roles {
+		if r == role {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Valid reports whether r is one of Roles
+func (r Role) Valid() bool {
+	return r.rank() > 0
+}
+
+// Allows reports whether a user with role r may do what required grants
+func (r Role) Allows(required Role) bool {
+	return r.Valid() && r.rank() >= required.rank()
+}
+
+var (
+	// ErrNotFound is returned for users, sessions and tokens that do not
+	// exist
+	ErrNotFound = errors.New("user not found")
+
+	// ErrDuplicateName is returned when a user is saved under the name of
+	// another one
+	ErrDuplicateName = errors.New("a user with this name already exists")
+
+	// ErrInvalidCredentials is returned for logins with an unknown username
+	// or a wrong password
+	ErrInvalidCredentials = errors.New("invalid username or password")
+
+	// ErrLastAdmin is returned when the last admin would be removed or
+	// demoted, which would leave nobody to manage the users
+	ErrLastAdmin = errors.New("the last admin cannot be removed or demoted")
+)
+
+const schema = `CREATE TABLE IF NOT EXISTS users (
+	id            INTEGER PRIMARY KEY AUTOINCREMENT,
+	username      TEXT NOT NULL UNIQUE,
+	password_hash TEXT NOT NULL,
+	role          TEXT NOT NULL,
+	created_at    INTEGER NOT NULL,
+	updated_at    INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS sessions (
+	token_hash TEXT PRIMARY KEY,
+	user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	created_at INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS sessions_user ON sessions (user_id);
+CREATE TABLE IF NOT EXISTS api_tokens (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id      INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	name         TEXT NOT NULL,
+	token_hash   TEXT NOT NULL UNIQUE,
+	created_at   INTEGER NOT NULL,
+	last_used_at INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS api_tokens_user ON api_tokens (user_id);`
+
+// columns lists the columns of the users table in the order scan reads them
+const columns = "id, username, password_hash, role, created_at, updated_at"
+
+// User is a local user of the web UI
+type User struct {
+	ID           int64  `json:"id"`
+	Username     string `json:"username"`
+	Role         Role   `json:"role"`
+	PasswordHash string `json:"-"`
+
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Store persists users and sessions in a SQLite database
+type Store struct {
+	db         *sql.DB
+	sessionTTL time.Duration
+}
+
+// Open opens the users stored in the SQLite file at path, creating it if
+// needed. Sessions expire sessionTTL after the login.
+func Open(path string, sessionTTL time.Duration) (*Store, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db, sessionTTL: sessionTTL}, nil
+}
+
+// Close closes the store
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Count returns the number of users
+func (s *Store) Count(ctx context.Context) (int, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users").Scan(&n)
+	return n, err
+}
+
+// List returns every user, by name
+func (s *Store) List(ctx context.Context) ([]User, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+columns+" FROM users ORDER BY username")
+	if err != nil {
+		return nil, err
+	}
+	return scan(rows)
+}
+
+// Get returns the user with the given ID
+func (s *Store) Get(ctx context.Context, id int64) (*User, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+columns+" FROM users WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	users, err := scan(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, ErrNotFound
+	}
+	return &users[0], nil
+}
+
+// Save inserts a new user, setting its ID, or updates an existing one. A
+// non-empty password replaces the stored hash and ends the user's
+// sessions; new users need one.
+func (s *Store) Save(ctx context.Context, u *User, password string) error {
+	if !u.Role.Valid() {
+		return errors.New("unknown role: " + string(u.Role))
+	}
+	if password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		u.PasswordHash = string(hash)
+	}
+	if u.PasswordHash == "" {
+		return errors.New("a password is required")
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	u.UpdatedAt = now
+	if u.ID == 0 {
+		u.CreatedAt = now
+		result, err := tx.ExecContext(ctx, `INSERT INTO users (username, password_hash, role, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?)`,
+			u.Username, u.PasswordHash, string(u.Role), u.CreatedAt.UnixMilli(), u.UpdatedAt.UnixMilli())
+		if err != nil {
+			return uniqueName(err)
+		}
+		if u.ID, err = result.LastInsertId(); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	if u.Role != RoleAdmin {
+		if err := keepAdmin(ctx, tx, u.ID); err != nil {
+			return err
+		}
+	}
+	result, err := tx.ExecContext(ctx, "UPDATE users SET username = ?, password_hash = ?, role = ?, updated_at = ? WHERE id = ?",
+		u.Username, u.PasswordHash, string(u.Role), u.UpdatedAt.UnixMilli(), u.ID)
+	if err != nil {
+		return uniqueName(err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	if password != "" {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM sessions WHERE user_id = ?", u.ID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Delete removes the user with the given ID along with their sessions
+func (s *Store) Delete(ctx context.Context, id int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := keepAdmin(ctx, tx, id); err != nil {
+		return err
+	}
+	result, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return tx.Commit()
+}
+
+// Authenticate returns the user with the given username and password
+func (s *Store) Authenticate(ctx context.Context, username, password string) (*User, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+columns+" FROM users WHERE username = ?", username)
+	if err != nil {
+		return nil, err
+	}
+	users, err := scan(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		// Spend the time of a comparison so that unknown usernames cannot
+		// be told apart by the response time
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(users[0].PasswordHash), []byte(password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return &users[0], nil
+}
+
+// dummyHash is compared against the passwords of unknown users
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// keepAdmin returns ErrLastAdmin when the user with the given ID is the
+// only admin
+func keepAdmin(ctx context.Context, tx *sql.Tx, id int64) error {
+	var others int
+	err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE role = ? AND id != ?", string(RoleAdmin), id).Scan(&others)
+	if err != nil {
+		return err
+	}
+	if others > 0 {
+		return nil
+	}
+	var role string
+	err = tx.QueryRowContext(ctx, "SELECT role FROM users WHERE id = ?", id).Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if Role(role) == RoleAdmin {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+// uniqueName reports a violation of the unique usernames as
+// ErrDuplicateName
+func uniqueName(err error) error {
+	if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+		return ErrDuplicateName
+	}
+	return err
+}
+
+// scan reads every row of rows into users and closes rows
+func scan(rows *sql.Rows) ([]User, error) {
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var u User
+		var role string
+		var created, updated int64
+		if err := rows.Scan(&u.ID, &u.Username, &u.PasswordHash, &role, &created, &updated); err != nil {
+			return nil, err
+		}
+		u.Role = Role(role)
+		u.CreatedAt = time.UnixMilli(created)
+		u.UpdatedAt = time.UnixMilli(updated)
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// CreateSession logs in the user with the given ID, returning the session
+// token for the cookie and when it expires. Only a hash of the token is
+// stored.
+func (s *Store) CreateSession(ctx context.Context, userID int64) (token string, expires time.Time, err error) {
+	var random [32]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		return "", time.Time{}, err
+	}
+	token = hex.EncodeToString(random[:])
+
+	now := time.Now()
+	expires = now.Add(s.sessionTTL)
+	// Forget the sessions that expired in the meantime
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM sessions WHERE expires_at <= ?", now.UnixMilli()); err != nil {
+		return "", time.Time{}, err
+	}
+	_, err = s.db.ExecContext(ctx, "INSERT INTO sessions (token_hash, user_id, created_at, expires_at) VALUES (?, ?, ?, ?)",
+		hashToken(token), userID, now.UnixMilli(), expires.UnixMilli())
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expires, nil
+}
+
+// Session returns the user logged in with token, or ErrNotFound when the
+// session does not exist or has expired
+func (s *Store) Session(ctx context.Context, token string) (*User, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+prefixed("u.", columns)+` FROM sessions s JOIN users u ON u.id = s.user_id
+		WHERE s.token_hash = ? AND s.expires_at > ?`, hashToken(token), time.Now().UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	users, err := scan(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, ErrNotFound
+	}
+	return &users[0], nil
+}
+
+// DeleteSession logs out the session of token
+func (s *Store) DeleteSession(ctx context.Context, token string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM sessions WHERE token_hash = ?", hashToken(token))
+	return err
+}
+
+// hashToken returns the hex SHA-256 of a session token or API token secret
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// prefixed qualifies every column of a comma separated list with prefix
+func prefixed(prefix, list string) string {
+	columns := strings.Split(list, ", ")
+	for i, column := range columns {
+		columns[i] = prefix + column
+	}
+	return strings.Join(columns, ", ")
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// tokenPrefix starts every API token, so that leaked tokens are easy to
+// recognise
+const tokenPrefix = "sqlc_"
+
+// Token is an API token of a user. Requests made with it have the
+// permissions of the user's role.
+type Token struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"userId"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"` // nil until the token is used
+}
+
+// CreateToken creates an API token for the user with the given ID,
+// returning the secret to show once and the stored token. Only a hash of
+// the secret is stored.
+func (s *Store) CreateToken(ctx context.Context, userID int64, name string) (string, *Token, error) {
+	var random [32]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		return "", nil, err
+	}
+	secret := tokenPrefix + hex.EncodeToString(random[:])
+
+	t := &Token{UserID: userID, Name: name, CreatedAt: time.Now()}
+	result, err := s.db.ExecContext(ctx, "INSERT INTO api_tokens (user_id, name, token_hash, created_at) VALUES (?, ?, ?, ?)",
+		userID, name, hashToken(secret), t.CreatedAt.UnixMilli())
+	if err != nil {
+		return "", nil, err
+	}
+	if t.ID, err = result.LastInsertId(); err != nil {
+		return "", nil, err
+	}
+	return secret, t, nil
+}
+
+// Tokens returns the API tokens of the user with the given ID, newest first
+func (s *Store) Tokens(ctx context.Context, userID int64) ([]Token, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, user_id, name, created_at, last_used_at FROM api_tokens
+		WHERE user_id = ? ORDER BY id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []Token
+	for rows.Next() {
+		var t Token
+		var created, used int64
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Name, &created, &used); err != nil {
+			return nil, err
+		}
+		t.CreatedAt = time.UnixMilli(created)
+		if used != 0 {
+			lastUsed := time.UnixMilli(used)
+			t.LastUsedAt = &lastUsed
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// DeleteToken revokes the API token with the given ID of the user with
+// the given ID
+func (s *Store) DeleteToken(ctx context.Context, userID, id int64) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM api_tokens WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// TokenUser returns the user of an API token secret, or ErrNotFound when
+// the token does not exist, and records that the token was used
+func (s *Store) TokenUser(ctx context.Context, secret string) (*User, error) {
+	hash := hashToken(secret)
+	rows, err := s.db.QueryContext(ctx, `SELECT `+prefixed("u.", columns)+` FROM api_tokens t JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = ?`, hash)
+	if err != nil {
+		return nil, err
+	}
+	users, err := scan(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, ErrNotFound
+	}
+
+	if _, err := s.db.ExecContext(ctx, "UPDATE api_tokens SET last_used_at = ? WHERE token_hash = ?", time.Now().UnixMilli(), hash); err != nil {
+		return nil, err
+	}
+	return &users[0], nil
+}
@@ -0,0 +1,284 @@
+// Package catalog keeps a persistent record of every export, successful or
+// not, in a SQLite database.
+package catalog
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// Export statuses, matching the final statuses of the export's job
+const (
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+// ErrNotFound is returned for entries that do not exist
+var ErrNotFound = errors.New("export not found")
+
+const schema = `CREATE TABLE IF NOT EXISTS exports (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	job_id       TEXT NOT NULL,
+	storage      TEXT NOT NULL DEFAULT '',
+	file         TEXT NOT NULL,
+	type         TEXT NOT NULL,
+	host         TEXT NOT NULL,
+	port         TEXT NOT NULL,
+	database     TEXT NOT NULL,
+	format       TEXT NOT NULL,
+	options      TEXT NOT NULL,
+	size         INTEGER NOT NULL,
+	sha256       TEXT NOT NULL,
+	started_at   INTEGER NOT NULL,
+	finished_at  INTEGER NOT NULL,
+	tool_version TEXT NOT NULL,
+	status       TEXT NOT NULL,
+	error        TEXT NOT NULL,
+	triggered_by TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS exports_database ON exports (database);`
+
+// migrations add the columns of later versions to existing catalogs
+var migrations = []struct{ column, definition string }{
+	{"storage", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// columns lists the columns of the exports table in the order scan reads them
+const columns = `id, job_id, storage, file, type, host, port, database, format, options, size, sha256,
+	started_at, finished_at, tool_version, status, error, triggered_by`
+
+// Entry describes one export
+type Entry struct {
+	ID          int64             `json:"id"`
+	JobID       string            `json:"jobId"`
+	Storage     string            `json:"storage,omitempty"` // where the file is kept, empty for the export directory
+	File        string            `json:"file,omitempty"`    // name in the storage, empty when the export failed or the file was deleted
+	Type        string            `json:"type"`
+	Host        string            `json:"host,omitempty"`
+	Port        string            `json:"port,omitempty"`
+	Database    string            `json:"database"`
+	Format      string            `json:"format"`
+	Options     map[string]string `json:"options,omitempty"`
+	Size        int64             `json:"size"`
+	SHA256      string            `json:"sha256,omitempty"`
+	StartedAt   time.Time         `json:"startedAt"`
+	FinishedAt  time.Time         `json:"finishedAt"`
+	ToolVersion string            `json:"toolVersion,omitempty"`
+	Status      string            `json:"status"`
+	Error       string            `json:"error,omitempty"`
+	TriggeredBy string            `json:"triggeredBy,omitempty"`
+}
+
+// Duration returns how long the export ran
+func (e Entry) Duration() time.Duration {
+	return e.FinishedAt.Sub(e.StartedAt).Round(time.Millisecond)
+}
+
+// Filter narrows a listing. Empty fields match every entry.
+type Filter struct {
+	Type        string
+	Database    string
+	Status      string
+	TriggeredBy string
+
+	// Limit caps the number of entries, newest first. Zero means no limit.
+	Limit int
+}
+
+// Catalog is the export catalog
+type Catalog struct {
+	db *sql.DB
+}
+
+// Open opens the catalog stored in the SQLite file at path, creating it if
+// needed
+func Open(path string) (*Catalog, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// A single connection serialises the writes of concurrent jobs
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Catalog{db: db}, nil
+}
+
+// migrate adds the columns missing from a catalog created by an earlier
+// version
+func migrate(db *sql.DB) error {
+	rows, err := db.Query("SELECT name FROM pragma_table_info('exports')")
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if !existing[m.column] {
+			if _, err := db.Exec("ALTER TABLE exports ADD COLUMN " + m.column + " " + m.definition); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close closes the catalog
+func (c *Catalog) Close() error {
+	return c.db.Close()
+}
+
+// Add records e, setting its ID
+func (c *Catalog) Add(ctx context.Context, e *Entry) error {
+	options, err := json.Marshal(e.Options)
+	if err != nil {
+		return err
+	}
+	result, err := c.db.ExecContext(ctx, `INSERT INTO exports (job_id, storage, file, type, host, port, database, format,
+			options, size, sha256, started_at, finished_at, tool_version, status, error, triggered_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.JobID, e.Storage, e.File, e.Type, e.Host, e.Port, e.Database, e.Format, string(options), e.Size, e.SHA256,
+		e.StartedAt.UnixMilli(), e.FinishedAt.UnixMilli(), e.ToolVersion, e.Status, e.Error, e.TriggeredBy)
+	if err != nil {
+		return err
+	}
+	e.ID, err = result.LastInsertId()
+	return err
+}
+
+// Get returns the entry with the given ID
+func (c *Catalog) Get(ctx context.Context, id int64) (*Entry, error) {
+	rows, err := c.db.QueryContext(ctx, "SELECT "+columns+" FROM exports WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := scan(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, ErrNotFound
+	}
+	return &entries[0], nil
+}
+
+// List returns the entries matching f, newest first
+func (c *Catalog) List(ctx context.Context, f Filter) ([]Entry, error) {
+	var where []string
+	var args []any
+	for _, condition := range []struct{ column, value string }{
+		{"type", f.Type},
+		{"database", f.Database},
+		{"status", f.Status},
+		{"triggered_by", f.TriggeredBy},
+	} {
+		if condition.value != "" {
+			where = append(where, condition.column+" = ?")
+			args = append(args, condition.value)
+		}
+	}
+
+	query := "SELECT " + columns + " FROM exports"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY id DESC"
+	if f.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, f.Limit)
+	}
+
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scan(rows)
+}
+
+// Databases returns the distinct database names in the catalog, for
+// filtering
+func (c *Catalog) Databases(ctx context.Context) ([]string, error) {
+	rows, err := c.db.QueryContext(ctx, "SELECT DISTINCT database FROM exports ORDER BY database")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// Delete removes the entry with the given ID. The export file is left to
+// the caller.
+func (c *Catalog) Delete(ctx context.Context, id int64) error {
+	result, err := c.db.ExecContext(ctx, "DELETE FROM exports WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+// ClearFile records that the file of the entry with the given ID has been
+// deleted, keeping the rest of its history
+func (c *Catalog) ClearFile(ctx context.Context, id int64) error {
+	_, err := c.db.ExecContext(ctx, "UPDATE exports SET file = '' WHERE id = ?", id)
+	return err
+}
+
+// scan reads every row of rows into entries and closes rows
+func scan(rows *sql.Rows) ([]Entry, error) {
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var e Entry
+		var options string
+		var started, finished int64
+		if err := rows.Scan(&e.ID, &e.JobID, &e.Storage, &e.File, &e.Type, &e.Host, &e.Port, &e.Database, &e.Format,
+			&options, &e.Size, &e.SHA256, &started, &finished, &e.ToolVersion, &e.Status, &e.Error, &e.TriggeredBy); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(options), &e.Options); err != nil {
+			return nil, err
+		}
+		e.StartedAt = time.UnixMilli(started)
+		e.FinishedAt = time.UnixMilli(finished)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,105 @@
+// Package compression wraps export streams in gzip or zstd and detects
+// compressed import streams by their magic bytes.
+package compression
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Supported compression formats
+const (
+	None = "none"
+	Gzip = "gzip"
+	Zstd = "zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Extension returns the file extension appended to exports compressed with format
+func Extension(format string) string {
+	switch format {
+	case Gzip:
+		return ".gz"
+	case Zstd:
+		return ".zst"
+	default:
+		return ""
+	}
+}
+
+// Validate returns an error if format is not a supported compression format
+func Validate(format string) error {
+	switch format {
+	case "", None, Gzip, Zstd:
+		return nil
+	default:
+		return fmt.Errorf("unsupported compression format: %s", format)
+	}
+}
+
+// NewWriter returns a writer that compresses into w using format. Closing
+// it flushes the compressor but does not close w.
+func NewWriter(w io.Writer, format string) (io.WriteCloser, error) {
+	switch format {
+	case "", None:
+		return nopWriteCloser{w}, nil
+	case Gzip:
+		return gzip.NewWriter(w), nil
+	case Zstd:
+		return zstd.NewWriter(w)
+	default:
+		return nil, fmt.Errorf("unsupported compression format: %s", format)
+	}
+}
+
+// NewReader returns a reader that transparently decompresses r when it
+// starts with a gzip or zstd header, and passes it through otherwise
+func NewReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch Detect(header) {
+	case Gzip:
+		return gzip.NewReader(br)
+	case Zstd:
+		decoder, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	default:
+		return io.NopCloser(br), nil
+	}
+}
+
+// Detect returns the compression format of a stream starting with header
+func Detect(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return Gzip
+	case bytes.HasPrefix(header, zstdMagic):
+		return Zstd
+	default:
+		return None
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
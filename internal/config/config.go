@@ -3,6 +3,8 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // Config holds the application configuration
@@ -11,21 +13,111 @@ type Config struct {
 	MaxUploadSize   int64
 	ExportDirectory string
 	UploadDirectory string
+	SQLiteDirectory string
+	DataDirectory   string // application state such as the export catalog
 	TemplateDir     string
 	StaticDir       string
 	Environment     string
+	JobWorkers      int
+
+	// MasterKey encrypts the passwords of connection profiles. When empty a
+	// key is generated into the data directory.
+	MasterKey string
+
+	// ExportRecipients are the age or SSH public keys, comma or newline
+	// separated, that exports are encrypted to when the form names no
+	// recipients or passphrase. Empty leaves those exports in plaintext.
+	ExportRecipients string
+
+	// SessionTTL is how long a login lasts
+	SessionTTL time.Duration
+
+	// AdminUsername and AdminPassword name the admin user created when there
+	// are no users yet. A password is generated when none is configured.
+	AdminUsername string
+	AdminPassword string
+
+	// Retention of exports; zero values disable a rule
+	RetentionMaxAge   time.Duration
+	RetentionMaxBytes int64
+	RetentionKeepLast int
+	RetentionDaily    int
+	RetentionWeekly   int
+	RetentionMonthly  int
+	RetentionInterval time.Duration // how often the sweeper runs
+
+	// UploadMaxAge is how long uploads left by failed or abandoned imports
+	// are kept. Zero keeps them forever.
+	UploadMaxAge time.Duration
+
+	// Storage names where exports are written when the form does not
+	// choose: "local" for the export directory, "s3" or "sftp"
+	Storage string
+
+	// S3-compatible bucket; the storage is enabled when S3Bucket is set
+	S3Endpoint  string
+	S3Region    string
+	S3Bucket    string
+	S3Prefix    string
+	S3AccessKey string
+	S3SecretKey string
+	S3UseSSL    bool
+
+	// SFTP server; the storage is enabled when SFTPHost is set. The host
+	// key is checked against SFTPKnownHosts.
+	SFTPHost       string
+	SFTPPort       string
+	SFTPUsername   string
+	SFTPPassword   string
+	SFTPKeyFile    string
+	SFTPDirectory  string
+	SFTPKnownHosts string
 }
 
 // New creates a new Config instance with values from environment variables
 func New() *Config {
 	return &Config{
-		Port:            getEnv("PORT", "3000"),
-		MaxUploadSize:   getEnvAsInt64("MAX_UPLOAD_SIZE", 1024*1024*1024), // 1GB default
-		ExportDirectory: getEnv("EXPORT_DIR", "./exports"),
-		UploadDirectory: getEnv("UPLOAD_DIR", "./uploads"),
-		TemplateDir:     getEnv("TEMPLATE_DIR", "./internal/templates"),
-		StaticDir:       getEnv("STATIC_DIR", "./static"),
-		Environment:     getEnv("ENVIRONMENT", "development"),
+		Port:             getEnv("PORT", "3000"),
+		MaxUploadSize:    getEnvAsInt64("MAX_UPLOAD_SIZE", 1024*1024*1024), // 1GB default
+		ExportDirectory:  getEnv("EXPORT_DIR", "./exports"),
+		UploadDirectory:  getEnv("UPLOAD_DIR", "./uploads"),
+		SQLiteDirectory:  getEnv("SQLITE_DIR", "./data/sqlite"),
+		DataDirectory:    getEnv("DATA_DIR", "./data"),
+		TemplateDir:      getEnv("TEMPLATE_DIR", "./internal/templates"),
+		StaticDir:        getEnv("STATIC_DIR", "./static"),
+		Environment:      getEnv("ENVIRONMENT", "development"),
+		JobWorkers:       getEnvAsInt("JOB_WORKERS", 2),
+		MasterKey:        getEnv("MASTER_KEY", ""),
+		SessionTTL:       getEnvAsDuration("SESSION_TTL", 12*time.Hour),
+		ExportRecipients: getEnv("EXPORT_RECIPIENTS", ""),
+		AdminUsername:    getEnv("ADMIN_USERNAME", "admin"),
+		AdminPassword:    getEnv("ADMIN_PASSWORD", ""),
+
+		RetentionMaxAge:   getEnvAsDuration("RETENTION_MAX_AGE", 0),
+		RetentionMaxBytes: getEnvAsInt64("RETENTION_MAX_BYTES", 0),
+		RetentionKeepLast: getEnvAsInt("RETENTION_KEEP_LAST", 0),
+		RetentionDaily:    getEnvAsInt("RETENTION_DAILY", 0),
+		RetentionWeekly:   getEnvAsInt("RETENTION_WEEKLY", 0),
+		RetentionMonthly:  getEnvAsInt("RETENTION_MONTHLY", 0),
+		RetentionInterval: getEnvAsDuration("RETENTION_INTERVAL", time.Hour),
+		UploadMaxAge:      getEnvAsDuration("UPLOAD_MAX_AGE", 24*time.Hour),
+
+		Storage:     getEnv("STORAGE", "local"),
+		S3Endpoint:  getEnv("S3_ENDPOINT", "s3.amazonaws.com"),
+		S3Region:    getEnv("S3_REGION", ""),
+		S3Bucket:    getEnv("S3_BUCKET", ""),
+		S3Prefix:    getEnv("S3_PREFIX", ""),
+		S3AccessKey: getEnv("S3_ACCESS_KEY", ""),
+		S3SecretKey: getEnv("S3_SECRET_KEY", ""),
+		S3UseSSL:    getEnvAsBool("S3_USE_SSL", true),
+
+		SFTPHost:       getEnv("SFTP_HOST", ""),
+		SFTPPort:       getEnv("SFTP_PORT", "22"),
+		SFTPUsername:   getEnv("SFTP_USERNAME", ""),
+		SFTPPassword:   getEnv("SFTP_PASSWORD", ""),
+		SFTPKeyFile:    getEnv("SFTP_KEY_FILE", ""),
+		SFTPDirectory:  getEnv("SFTP_DIRECTORY", ""),
+		SFTPKnownHosts: getEnv("SFTP_KNOWN_HOSTS", ""),
 	}
 }
 
@@ -67,6 +159,23 @@ func getEnvAsInt64(key string, defaultValue int64) int64 {
 	return defaultValue
 }
 
+// Helper function to get an environment variable as a duration or a default
+// value. Besides Go durations such as "36h", a number of days such as "30d"
+// is accepted.
+func getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
+	if value, exists := os.LookupEnv(key); exists {
+		if days, ok := strings.CutSuffix(value, "d"); ok {
+			if n, err := strconv.Atoi(days); err == nil {
+				return time.Duration(n) * 24 * time.Hour
+			}
+		}
+		if duration, err := time.ParseDuration(value); err == nil {
+			return duration
+		}
+	}
+	return defaultValue
+}
+
 // Helper function to get an environment variable as a boolean or a default value
 func getEnvAsBool(key string, defaultValue bool) bool {
 	if value, exists := os.LookupEnv(key); exists {
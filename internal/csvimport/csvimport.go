@@ -0,0 +1,299 @@
+// Package csvimport previews CSV files, infers the types of their columns
+// and streams their records into a table, rejecting the records that do not
+// fit the table's columns.
+package csvimport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"sqlclient-export-import/internal/drivers"
+)
+
+// inferRows is how many records are sampled to infer column types
+const inferRows = 1000
+
+// maxRejections caps how many rejected records are reported individually
+const maxRejections = 1000
+
+// utf8BOM is written at the start of CSV files by some spreadsheet programs
+var utf8BOM = []byte{0xef, 0xbb, 0xbf}
+
+// Options describe the layout of a CSV file
+type Options struct {
+	// Delimiter separates the fields of a record
+	Delimiter rune
+
+	// Header means the first record holds the column names
+	Header bool
+
+	// Null is the field text that stands for NULL. With the default empty
+	// string, empty fields are loaded as NULL.
+	Null string
+}
+
+// Preview is the start of a CSV file along with the columns inferred from it
+type Preview struct {
+	// Columns are named after the header, or column_1, column_2 and so on,
+	// and typed by the records sampled
+	Columns []drivers.Column
+
+	// Rows are the first records of the file
+	Rows [][]string
+}
+
+// Mapping loads the field at Source of every record into Column
+type Mapping struct {
+	Source int
+	Column drivers.Column
+}
+
+// Rejection describes a record that was not loaded as read. Line is 0
+// when the server reported a row without saying which.
+type Rejection struct {
+	Line   int    `json:"line"`
+	Reason string `json:"reason"`
+}
+
+// Result summarises a load
+type Result struct {
+	Loaded   int64 `json:"loaded"`
+	Rejected int64 `json:"rejected"`
+}
+
+// ReadPreview reads the first rows records of r and infers the type of
+// every column from a larger sample
+func ReadPreview(r io.Reader, opts Options, rows int) (*Preview, error) {
+	reader := newReader(r, opts)
+
+	var header []string
+	if opts.Header {
+		record, err := reader.Read()
+		if err != nil {
+			if err == io.EOF {
+				return nil, errors.New("the CSV file is empty")
+			}
+			return nil, err
+		}
+		header = append([]string(nil), record...)
+	}
+
+	var sample [][]string
+	for len(sample) < inferRows {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		sample = append(sample, append([]string(nil), record...))
+	}
+
+	width := len(header)
+	for _, record := range sample {
+		width = max(width, len(record))
+	}
+	if width == 0 {
+		return nil, errors.New("the CSV file is empty")
+	}
+
+	preview := &Preview{Columns: make([]drivers.Column, width)}
+	values := make([]string, 0, len(sample))
+	for i := range preview.Columns {
+		values = values[:0]
+		for _, record := range sample {
+			if i < len(record) {
+				values = append(values, record[i])
+			}
+		}
+		preview.Columns[i] = drivers.Column{
+			Name: columnName(header, i),
+			Type: Infer(values, opts.Null),
+		}
+	}
+	dedupeNames(preview.Columns)
+
+	preview.Rows = sample[:min(rows, len(sample))]
+	return preview, nil
+}
+
+// Infer returns the most specific column type, the first of
+// drivers.ColumnTypes, that every value other than null text converts to
+func Infer(values []string, null string) drivers.ColumnType {
+	var samples []string
+	for _, value := range values {
+		if value != null {
+			samples = append(samples, value)
+		}
+	}
+	if len(samples) == 0 {
+		return drivers.TypeText
+	}
+	for _, t := range drivers.ColumnTypes {
+		if fitsAll(samples, t) {
+			return t
+		}
+	}
+	return drivers.TypeText
+}
+
+// fitsAll reports whether every value converts to t
+func fitsAll(values []string, t drivers.ColumnType) bool {
+	for _, value := range values {
+		if _, ok := Normalize(value, t); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Load reads every record of r, converts the mapped fields to their
+// column's type and passes the records that convert cleanly to load.
+// Records that do not, and those load reports the server skipped or
+// altered, are reported to reject, which may be nil, with their line
+// number; after the first maxRejections only the count is kept.
+func Load(ctx context.Context, r io.Reader, opts Options, mappings []Mapping, width int, load func(next func() ([]any, error), reject func(row int64, reason string)) (int64, error), reject func(Rejection)) (Result, error) {
+	reader := newReader(r, opts)
+	if opts.Header {
+		if _, err := reader.Read(); err != nil && err != io.EOF {
+			return Result{}, err
+		}
+	}
+
+	var result Result
+	var lines lineIndex
+	rejectRecord := func(line int, reason string) {
+		result.Rejected++
+		if reject != nil && result.Rejected <= maxRejections {
+			reject(Rejection{Line: line, Reason: reason})
+		}
+	}
+
+	row := make([]any, len(mappings))
+	next := func() ([]any, error) {
+		for {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			record, err := reader.Read()
+			if err != nil {
+				var parseErr *csv.ParseError
+				if errors.As(err, &parseErr) {
+					rejectRecord(parseErr.StartLine, parseErr.Err.Error())
+					continue
+				}
+				return nil, err
+			}
+			line, _ := reader.FieldPos(0)
+
+			if len(record) != width {
+				rejectRecord(line, fmt.Sprintf("expected %d fields, found %d", width, len(record)))
+				continue
+			}
+			if reason := convertRecord(record, mappings, opts.Null, row); reason != "" {
+				rejectRecord(line, reason)
+				continue
+			}
+			lines.add(line)
+			return row, nil
+		}
+	}
+
+	loaded, err := load(next, func(row int64, reason string) {
+		rejectRecord(lines.line(row), reason)
+	})
+	result.Loaded = loaded
+	return result, err
+}
+
+// lineIndex maps the rows passed to a load back to their line numbers.
+// Lines mostly follow each other, so only the rows where they jump are kept.
+type lineIndex struct {
+	rows  []int64 // rows, counting from 1, whose line does not follow the previous one
+	lines []int   // line numbers of those rows
+	count int64
+	last  int
+}
+
+func (x *lineIndex) add(line int) {
+	x.count++
+	if len(x.rows) == 0 || line != x.last+1 {
+		x.rows = append(x.rows, x.count)
+		x.lines = append(x.lines, line)
+	}
+	x.last = line
+}
+
+// line returns the line number of row, or 0 if it is not known
+func (x *lineIndex) line(row int64) int {
+	if row < 1 || row > x.count {
+		return 0
+	}
+	i := sort.Search(len(x.rows), func(i int) bool { return x.rows[i] > row }) - 1
+	return x.lines[i] + int(row-x.rows[i])
+}
+
+// convertRecord fills row with the mapped fields of record, returning why
+// the record was rejected if a field does not fit its column
+func convertRecord(record []string, mappings []Mapping, null string, row []any) string {
+	for i, mapping := range mappings {
+		value := record[mapping.Source]
+		if value == null {
+			row[i] = nil
+			continue
+		}
+		normalized, ok := Normalize(value, mapping.Column.Type)
+		if !ok {
+			return fmt.Sprintf("column %s: %q is not a valid %s", mapping.Column.Name, value, mapping.Column.Type)
+		}
+		row[i] = normalized
+	}
+	return ""
+}
+
+// newReader returns a CSV reader for r that skips a leading byte order mark
+// and leaves checking the number of fields to the caller
+func newReader(r io.Reader, opts Options) *csv.Reader {
+	br := bufio.NewReader(r)
+	if header, err := br.Peek(len(utf8BOM)); err == nil && bytes.Equal(header, utf8BOM) {
+		br.Discard(len(utf8BOM))
+	}
+
+	reader := csv.NewReader(br)
+	reader.Comma = opts.Delimiter
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	return reader
+}
+
+// columnName returns the header name of column i, or a generated one
+func columnName(header []string, i int) string {
+	if i < len(header) {
+		if name := strings.TrimSpace(header[i]); name != "" {
+			return name
+		}
+	}
+	return "column_" + strconv.Itoa(i+1)
+}
+
+// dedupeNames appends a number to repeated column names
+func dedupeNames(columns []drivers.Column) {
+	seen := make(map[string]int, len(columns))
+	for i := range columns {
+		key := strings.ToLower(columns[i].Name)
+		seen[key]++
+		if n := seen[key]; n > 1 {
+			columns[i].Name += "_" + strconv.Itoa(n)
+		}
+	}
+}
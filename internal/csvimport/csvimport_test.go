@@ -0,0 +1,52 @@
+package csvimport
+
+import (
+	"testing"
+
+	"sqlclient-export-import/internal/drivers"
+)
+
+func TestInfer(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		want   drivers.ColumnType
+	}{
+		{"integers", []string{"1", "2", "-3"}, drivers.TypeInteger},
+		{"floats", []string{"1", "2.5"}, drivers.TypeFloat},
+		{"float spellings", []string{"-.5", "3.", "+1e-3", "2E10"}, drivers.TypeFloat},
+		{"hex float", []string{"1.5", "0x1p-2"}, drivers.TypeText},
+		{"underscores", []string{"1.5", "1_000.5"}, drivers.TypeText},
+		{"infinity", []string{"1.5", "Inf"}, drivers.TypeText},
+		{"not a number", []string{"1.5", "NaN"}, drivers.TypeText},
+		{"out of range", []string{"1.5", "1e999"}, drivers.TypeText},
+		{"booleans", []string{"yes", "no", "1"}, drivers.TypeBoolean},
+		{"integer before boolean", []string{"2", "yes"}, drivers.TypeText},
+		{"boolean before integer", []string{"yes", "2"}, drivers.TypeText},
+		{"dates", []string{"2024-01-01", "2024-12-31"}, drivers.TypeDate},
+		{"date before timestamp", []string{"2024-01-01", "2024-01-01 10:00"}, drivers.TypeText},
+		{"timestamps", []string{"2024-01-01 10:00", "2024-01-01T10:00:00Z"}, drivers.TypeTimestamp},
+		{"nulls skipped", []string{"", "7", ""}, drivers.TypeInteger},
+		{"only nulls", []string{"", ""}, drivers.TypeText},
+		{"no values", nil, drivers.TypeText},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Infer(tt.values, ""); got != tt.want {
+				t.Errorf("Infer(%q) = %s, want %s", tt.values, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLineIndex(t *testing.T) {
+	var x lineIndex
+	for _, line := range []int{2, 3, 4, 7, 8, 12} {
+		x.add(line)
+	}
+	for row, want := range map[int64]int{0: 0, 1: 2, 3: 4, 4: 7, 5: 8, 6: 12, 7: 0} {
+		if got := x.line(row); got != want {
+			t.Errorf("line(%d) = %d, want %d", row, got, want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package csvimport
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"sqlclient-export-import/internal/drivers"
+)
+
+// timestampLayouts are the accepted spellings of timestamps
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04",
+}
+
+// decimalFloat matches the plain decimal numbers, with an optional
+// exponent, that every server reads. ParseFloat alone would also accept hex
+// floats, underscores, Inf and NaN.
+var decimalFloat = regexp.MustCompile(`^[+-]?([0-9]+\.?[0-9]*|\.[0-9]+)([eE][+-]?[0-9]+)?$`)
+
+// Normalize converts a CSV field to the canonical text of column type t
+// expected by drivers.TableLoader, reporting whether it is valid for t.
+// Timestamps with a zone are converted to UTC.
+func Normalize(value string, t drivers.ColumnType) (string, bool) {
+	switch t {
+	case drivers.TypeInteger:
+		value = strings.TrimSpace(value)
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return "", false
+		}
+		return value, true
+	case drivers.TypeFloat:
+		value = strings.TrimSpace(value)
+		if !decimalFloat.MatchString(value) {
+			return "", false
+		}
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return "", false
+		}
+		return value, true
+	case drivers.TypeBoolean:
+		switch strings.ToLower(strings.TrimSpace(value)) {
+		case "true", "t", "yes", "y", "on", "1":
+			return "1", true
+		case "false", "f", "no", "n", "off", "0":
+			return "0", true
+		}
+		return "", false
+	case drivers.TypeDate:
+		d, err := time.Parse("2006-01-02", strings.TrimSpace(value))
+		if err != nil {
+			return "", false
+		}
+		return d.Format("2006-01-02"), true
+	case drivers.TypeTimestamp:
+		value = strings.TrimSpace(value)
+		for _, layout := range timestampLayouts {
+			if ts, err := time.Parse(layout, value); err == nil {
+				return ts.UTC().Format("2006-01-02 15:04:05.999999"), true
+			}
+		}
+		return "", false
+	default:
+		return value, true
+	}
+}
@@ -0,0 +1,89 @@
+package dataexport
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"io"
+	"os"
+	"time"
+)
+
+// archive bundles the per-table files into a single stream
+type archive interface {
+	// add stores everything fill writes as a file called name
+	add(name string, fill func(w io.Writer) error) error
+
+	// close finishes the archive without closing the underlying writer
+	close() error
+}
+
+func newArchive(w io.Writer, format, tempDir string) archive {
+	if format == Tar {
+		return &tarArchive{w: tar.NewWriter(w), tempDir: tempDir}
+	}
+	return &zipArchive{w: zip.NewWriter(w)}
+}
+
+type zipArchive struct {
+	w *zip.Writer
+}
+
+func (a *zipArchive) add(name string, fill func(w io.Writer) error) error {
+	w, err := a.w.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	return fill(w)
+}
+
+func (a *zipArchive) close() error {
+	return a.w.Close()
+}
+
+// tarArchive stages every file on disk first, since a tar header carries
+// the size of the file that follows it
+type tarArchive struct {
+	w       *tar.Writer
+	tempDir string
+}
+
+func (a *tarArchive) add(name string, fill func(w io.Writer) error) error {
+	f, err := os.CreateTemp(a.tempDir, ".dataexport-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if err := fill(f); err != nil {
+		return err
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	err = a.w.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     size,
+		ModTime:  time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(a.w, f)
+	return err
+}
+
+func (a *tarArchive) close() error {
+	return a.w.Close()
+}
@@ -0,0 +1,187 @@
+// Package dataexport writes the rows of every table as a CSV, NDJSON or JSON
+// file, bundling the files into a zip or tar archive.
+package dataexport
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"sqlclient-export-import/internal/drivers"
+)
+
+// Output formats. SQL is the regular dump and is not handled by this package.
+const (
+	SQL    = "sql"
+	CSV    = "csv"
+	NDJSON = "ndjson"
+	JSON   = "json"
+)
+
+// Archive formats
+const (
+	Zip = "zip"
+	Tar = "tar"
+)
+
+// Date formats
+const (
+	DateRFC3339  = "rfc3339"
+	DateDateTime = "datetime"
+	DateUnix     = "unix"
+)
+
+// Options tune a data export
+type Options struct {
+	// Format is CSV, NDJSON or JSON
+	Format string
+
+	// Archive is Zip or Tar
+	Archive string
+
+	// Header writes the column names as the first row of CSV files
+	Header bool
+
+	// Delimiter separates the fields of CSV files
+	Delimiter rune
+
+	// Null is written for NULL values in CSV files
+	Null string
+
+	// DateFormat is one of the Date constants
+	DateFormat string
+
+	// Binary reports whether a database type name holds raw bytes, which are
+	// written as base64. drivers.IsBinaryType is used when it is nil.
+	Binary func(dbType string) bool
+
+	// TempDir holds the files tar archives are staged in, since tar needs
+	// the size of every file up front. The system default is used when empty.
+	TempDir string
+}
+
+// IsDataFormat reports whether format is one of the formats this package
+// writes rather than a SQL dump
+func IsDataFormat(format string) bool {
+	return format == CSV || format == NDJSON || format == JSON
+}
+
+// Validate returns an error if any option is not supported
+func (o Options) Validate() error {
+	if !IsDataFormat(o.Format) {
+		return fmt.Errorf("unsupported export format: %s", o.Format)
+	}
+	if o.Archive != Zip && o.Archive != Tar {
+		return fmt.Errorf("unsupported archive format: %s", o.Archive)
+	}
+	switch o.DateFormat {
+	case DateRFC3339, DateDateTime, DateUnix:
+	default:
+		return fmt.Errorf("unsupported date format: %s", o.DateFormat)
+	}
+	if o.Delimiter == '"' || o.Delimiter == '\r' || o.Delimiter == '\n' || !utf8.ValidRune(o.Delimiter) {
+		return fmt.Errorf("invalid CSV delimiter: %q", o.Delimiter)
+	}
+	return nil
+}
+
+// isBinary reports whether the database type name dbType holds raw bytes
+func (o Options) isBinary(dbType string) bool {
+	if o.Binary != nil {
+		return o.Binary(dbType)
+	}
+	return drivers.IsBinaryType(dbType)
+}
+
+// Extension returns the file extension of archives written with o, such as
+// ".csv.zip"
+func (o Options) Extension() string {
+	return "." + o.Format + "." + o.Archive
+}
+
+// ParseDelimiter reads a CSV delimiter as entered on the export form, where
+// "tab" stands for a tab character. An empty string means a comma.
+func ParseDelimiter(s string) (rune, error) {
+	switch s {
+	case "":
+		return ',', nil
+	case "tab", `\t`:
+		return '\t', nil
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	if r == utf8.RuneError || size != len(s) {
+		return 0, fmt.Errorf("the CSV delimiter must be a single character")
+	}
+	return r, nil
+}
+
+// Export reads every table in tables from db and writes them to w as an
+// archive holding one file per table. progress, when not nil, is called
+// with the name of each table before it is read.
+func Export(ctx context.Context, db *sql.DB, tables []drivers.Table, w io.Writer, opts Options, progress func(table string)) error {
+	archive := newArchive(w, opts.Archive, opts.TempDir)
+	for _, table := range tables {
+		if progress != nil {
+			progress(table.Name)
+		}
+		err := archive.add(fileName(table.Name, opts.Format), func(w io.Writer) error {
+			return writeTable(ctx, db, table, w, opts)
+		})
+		if err != nil {
+			return fmt.Errorf("table %s: %w", table.Name, err)
+		}
+	}
+	return archive.close()
+}
+
+// writeTable encodes every row of table into w
+func writeTable(ctx context.Context, db *sql.DB, table drivers.Table, w io.Writer, opts Options) error {
+	rows, err := db.QueryContext(ctx, table.Select)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+	columns := make([]string, len(columnTypes))
+	for i, ct := range columnTypes {
+		columns[i] = ct.Name()
+	}
+
+	enc := newEncoder(w, opts)
+	if err := enc.begin(columns); err != nil {
+		return err
+	}
+
+	values := make([]any, len(columnTypes))
+	pointers := make([]any, len(columnTypes))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return err
+		}
+		for i, value := range values {
+			values[i] = convert(value, columnTypes[i].DatabaseTypeName(), opts)
+		}
+		if err := enc.row(values); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return enc.end()
+}
+
+// fileName returns the archive entry name of a table's file
+func fileName(table, format string) string {
+	return strings.NewReplacer("/", "_", `\`, "_").Replace(table) + "." + format
+}
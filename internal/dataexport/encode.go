@@ -0,0 +1,176 @@
+package dataexport
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// numericTypes are the database type names whose values are written as JSON
+// numbers even when the driver scans them as text
+var numericTypes = map[string]bool{
+	"TINYINT": true, "SMALLINT": true, "MEDIUMINT": true, "INT": true, "INTEGER": true, "BIGINT": true,
+	"INT2": true, "INT4": true, "INT8": true, "DECIMAL": true, "NUMERIC": true,
+	"FLOAT": true, "DOUBLE": true, "REAL": true, "FLOAT4": true, "FLOAT8": true,
+}
+
+// encoder writes the rows of one table
+type encoder interface {
+	begin(columns []string) error
+	row(values []any) error
+	end() error
+}
+
+func newEncoder(w io.Writer, opts Options) encoder {
+	switch opts.Format {
+	case CSV:
+		cw := csv.NewWriter(w)
+		cw.Comma = opts.Delimiter
+		return &csvEncoder{w: cw, header: opts.Header, null: opts.Null}
+	default:
+		return &jsonEncoder{w: bufio.NewWriter(w), array: opts.Format == JSON}
+	}
+}
+
+// convert turns a scanned value into nil, a string, a number or a bool.
+// Binary values become base64 and dates follow the date format of opts.
+func convert(value any, dbType string, opts Options) any {
+	switch v := value.(type) {
+	case []byte:
+		if opts.isBinary(dbType) {
+			return base64.StdEncoding.EncodeToString(v)
+		}
+		if numericTypes[strings.TrimPrefix(strings.ToUpper(dbType), "UNSIGNED ")] && json.Valid(v) {
+			return json.Number(v)
+		}
+		return string(v)
+	case float64:
+		// JSON has no representation for these
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return strconv.FormatFloat(v, 'g', -1, 64)
+		}
+		return v
+	case time.Time:
+		switch opts.DateFormat {
+		case DateUnix:
+			return v.Unix()
+		case DateDateTime:
+			return v.Format("2006-01-02 15:04:05")
+		default:
+			return v.Format(time.RFC3339Nano)
+		}
+	default:
+		return v
+	}
+}
+
+type csvEncoder struct {
+	w      *csv.Writer
+	header bool
+	null   string
+	record []string
+}
+
+func (e *csvEncoder) begin(columns []string) error {
+	e.record = make([]string, len(columns))
+	if e.header {
+		return e.w.Write(columns)
+	}
+	return nil
+}
+
+func (e *csvEncoder) row(values []any) error {
+	for i, value := range values {
+		switch v := value.(type) {
+		case nil:
+			e.record[i] = e.null
+		case string:
+			e.record[i] = v
+		case json.Number:
+			e.record[i] = string(v)
+		case int64:
+			e.record[i] = strconv.FormatInt(v, 10)
+		case float64:
+			e.record[i] = strconv.FormatFloat(v, 'g', -1, 64)
+		case bool:
+			e.record[i] = strconv.FormatBool(v)
+		default:
+			b, _ := json.Marshal(v)
+			e.record[i] = string(b)
+		}
+	}
+	return e.w.Write(e.record)
+}
+
+func (e *csvEncoder) end() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// jsonEncoder writes one object per row, either one per line (NDJSON) or
+// as the elements of a single array. Keys keep the column order.
+type jsonEncoder struct {
+	w     *bufio.Writer
+	array bool
+	keys  [][]byte
+	rows  int
+}
+
+func (e *jsonEncoder) begin(columns []string) error {
+	e.keys = make([][]byte, len(columns))
+	for i, column := range columns {
+		key, err := json.Marshal(column)
+		if err != nil {
+			return err
+		}
+		e.keys[i] = append(key, ':')
+	}
+	if e.array {
+		e.w.WriteByte('[')
+	}
+	return nil
+}
+
+func (e *jsonEncoder) row(values []any) error {
+	if e.array {
+		if e.rows > 0 {
+			e.w.WriteByte(',')
+		}
+		e.w.WriteByte('\n')
+	}
+	e.rows++
+
+	e.w.WriteByte('{')
+	for i, value := range values {
+		if i > 0 {
+			e.w.WriteByte(',')
+		}
+		b, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		e.w.Write(e.keys[i])
+		e.w.Write(b)
+	}
+	e.w.WriteByte('}')
+	if !e.array {
+		e.w.WriteByte('\n')
+	}
+	return nil
+}
+
+func (e *jsonEncoder) end() error {
+	if e.array {
+		if e.rows > 0 {
+			e.w.WriteByte('\n')
+		}
+		e.w.WriteString("]\n")
+	}
+	return e.w.Flush()
+}
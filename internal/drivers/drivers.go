@@ -0,0 +1,164 @@
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Connection holds the server coordinates shared by every driver operation
+type Connection struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+}
+
+// ExportOptions tune a single export
+type ExportOptions struct {
+	// Stderr, when set, receives the client's diagnostic output as it is
+	// produced
+	Stderr io.Writer
+
+	// Tables narrows the export to some tables and rows. Views and other
+	// objects are exported regardless.
+	Tables TableFilter
+
+	// Mode is ModeFull, ModeSchema or ModeData. Empty means ModeFull.
+	Mode string
+
+	// Skip leaves optional kinds of objects out of the dump
+	Skip Objects
+
+	// TempDir holds intermediate files some exports need. The system
+	// default is used when empty.
+	TempDir string
+}
+
+// Export modes
+const (
+	ModeFull   = "full"   // schema and data
+	ModeSchema = "schema" // DDL only, to diff environments
+	ModeData   = "data"   // rows only, to refresh an existing schema
+)
+
+// Objects flags optional kinds of schema objects. Kinds an engine does not
+// have, or an exporter cannot dump, are ignored.
+type Objects struct {
+	Routines  bool // stored procedures and functions
+	Triggers  bool
+	Events    bool // scheduled events, MySQL only
+	Views     bool
+	Sequences bool
+}
+
+// Schema reports whether the export includes the DDL
+func (o ExportOptions) Schema() bool {
+	return o.Mode != ModeData
+}
+
+// Data reports whether the export includes the table rows
+func (o ExportOptions) Data() bool {
+	return o.Mode != ModeSchema
+}
+
+// ImportOptions tune a single import
+type ImportOptions struct {
+	// Stderr, when set, receives the client's diagnostic output as it is
+	// produced
+	Stderr io.Writer
+}
+
+// Driver is implemented by every supported database engine. Adding an engine
+// means writing a package that satisfies this interface and registering it.
+// Every operation stops, killing any client it started, once ctx is done.
+type Driver interface {
+	// RequiresServer reports whether the engine connects to a server, in
+	// which case a host and username are required
+	RequiresServer() bool
+
+	// DefaultPort returns the port used when the form leaves it empty
+	DefaultPort() string
+
+	// IsSystemDatabase reports whether name is an internal database that
+	// should be hidden from database listings
+	IsSystemDatabase(name string) bool
+
+	// ValidateName reports, as an *InvalidNameError, why name cannot be
+	// used as a database name on this engine. Every other operation checks
+	// its names with it before running anything.
+	ValidateName(name string) error
+
+	// Export writes a SQL dump of database to w, limited to the tables and
+	// rows opts.Tables selects
+	Export(ctx context.Context, conn Connection, database string, w io.Writer, opts ExportOptions) error
+
+	// Import executes the SQL read from r against database
+	Import(ctx context.Context, conn Connection, database string, r io.Reader, opts ImportOptions) error
+
+	// List returns the names of all databases on the server
+	List(ctx context.Context, conn Connection) ([]string, error)
+
+	// Create creates a new, empty database
+	Create(ctx context.Context, conn Connection, name string) error
+
+	// Rename renames database from to to
+	Rename(ctx context.Context, conn Connection, from, to string) error
+
+	// Drop permanently removes a database
+	Drop(ctx context.Context, conn Connection, name string) error
+}
+
+// ToolVersioner is implemented by drivers whose Export runs a command line
+// client, to record which version of it wrote a dump. Drivers that hand
+// some exports to their native exporter report "native" for those opts.
+type ToolVersioner interface {
+	ToolVersion(ctx context.Context, opts ExportOptions) (string, error)
+}
+
+var (
+	mu       sync.RWMutex
+	registry = make(map[string]Driver)
+)
+
+// Register makes a driver available under the given database type name.
+// It panics if the name is already taken.
+func Register(name string, driver Driver) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if driver == nil {
+		panic("drivers: Register driver is nil")
+	}
+	if _, dup := registry[name]; dup {
+		panic("drivers: Register called twice for driver " + name)
+	}
+	registry[name] = driver
+}
+
+// Get returns the driver registered for the given database type name
+func Get(name string) (Driver, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	driver, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported database type: %s", name)
+	}
+	return driver, nil
+}
+
+// Names returns the sorted list of registered database type names
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,106 @@
+package drivers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// CommandError is returned when an external client exits with an error.
+// It keeps the captured stderr output so callers can show it to the user.
+type CommandError struct {
+	Err    error
+	Stderr string
+}
+
+func (e *CommandError) Error() string {
+	if e.Stderr != "" {
+		return fmt.Sprintf("%v: %s", e.Err, e.Stderr)
+	}
+	return e.Err.Error()
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// waitDelay bounds how long a cancelled command may keep its output pipes
+// open after being killed
+const waitDelay = 5 * time.Second
+
+// Command builds an invocation of an external client that is killed, along
+// with every process it started, once ctx is done
+func Command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	killProcessGroup(cmd)
+	cmd.WaitDelay = waitDelay
+	return cmd
+}
+
+// LogCommand logs the command line that is about to run. Callers must pass
+// args without any credentials.
+func LogCommand(name string, args []string) {
+	log.Printf("Running %s command: %s %s", name, name, strings.Join(args, " "))
+}
+
+// SecretFile writes content to a new temporary file that only the owner can
+// read, as CreateTemp uses mode 0600. It is for clients that take
+// credentials from a file rather than the command line or environment,
+// where other users could see them. The returned function removes the file.
+func SecretFile(pattern, content string) (path string, remove func(), err error) {
+	file, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", nil, err
+	}
+	remove = func() {
+		if err := os.Remove(file.Name()); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove credentials file %s: %v", file.Name(), err)
+		}
+	}
+	if _, err := file.WriteString(content); err != nil {
+		file.Close()
+		remove()
+		return "", nil, err
+	}
+	if err := file.Close(); err != nil {
+		remove()
+		return "", nil, err
+	}
+	return file.Name(), remove, nil
+}
+
+// Run executes cmd, capturing stderr into a CommandError on failure
+func Run(cmd *exec.Cmd) error {
+	return RunStreaming(cmd, nil)
+}
+
+// RunStreaming executes cmd like Run, additionally copying its stderr
+// output to w as it arrives when w is not nil
+func RunStreaming(cmd *exec.Cmd, w io.Writer) error {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if w != nil {
+		cmd.Stderr = io.MultiWriter(&stderr, w)
+	}
+
+	if err := cmd.Run(); err != nil {
+		return &CommandError{Err: err, Stderr: strings.TrimSpace(stderr.String())}
+	}
+	return nil
+}
+
+// Version returns the first line the client name prints for --version
+func Version(ctx context.Context, name string) (string, error) {
+	out, err := Command(ctx, name, "--version").Output()
+	if err != nil {
+		return "", err
+	}
+	line, _, _ := strings.Cut(string(out), "\n")
+	return strings.TrimSpace(line), nil
+}
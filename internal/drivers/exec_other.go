@@ -0,0 +1,9 @@
+//go:build !unix
+
+package drivers
+
+import "os/exec"
+
+// killProcessGroup leaves the default cancellation in place, which kills
+// only the client, on platforms without process groups
+func killProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,138 @@
+package drivers
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// TableFilter selects the tables an export covers and the rows read from
+// them. The zero value selects every row of every table.
+type TableFilter struct {
+	// Include lists the names or glob patterns, as understood by
+	// path.Match, of the tables to export. Empty includes every table.
+	// Patterns without a dot also match the unqualified name of schema
+	// qualified tables.
+	Include []string
+
+	// Exclude lists the names or patterns of tables to leave out, even
+	// when they are included
+	Exclude []string
+
+	// Where maps table names to the SQL condition their rows must satisfy
+	Where map[string]string
+}
+
+// IsZero reports whether f selects every row of every table
+func (f TableFilter) IsZero() bool {
+	return len(f.Include) == 0 && len(f.Exclude) == 0 && len(f.Where) == 0
+}
+
+// Validate returns an error if a pattern is malformed or a row filter is
+// not a single condition
+func (f TableFilter) Validate() error {
+	for _, pattern := range append(append([]string{}, f.Include...), f.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid table pattern: %s", pattern)
+		}
+	}
+	for table, condition := range f.Where {
+		if strings.TrimSpace(condition) == "" {
+			return fmt.Errorf("empty row filter for table %s", table)
+		}
+		// The condition is pasted into the query, so it must not be able
+		// to end the statement
+		if strings.Contains(condition, ";") {
+			return fmt.Errorf("row filter for table %s must not contain ';'", table)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether f includes the table called name
+func (f TableFilter) Matches(name string) bool {
+	return (len(f.Include) == 0 || matchAny(f.Include, name)) && !matchAny(f.Exclude, name)
+}
+
+// Select returns the names of names that f includes, in their original
+// order. It fails when no table is left or a row filter names a table that
+// is not exported.
+func (f TableFilter) Select(names []string) ([]string, error) {
+	var selected []string
+	exported := make(map[string]bool)
+	for _, name := range names {
+		if f.Matches(name) {
+			selected = append(selected, name)
+			exported[name] = true
+		}
+	}
+	if len(selected) == 0 {
+		return nil, errors.New("no tables match the table selection")
+	}
+
+	var unknown []string
+	for table := range f.Where {
+		if !exported[table] {
+			unknown = append(unknown, table)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("row filters name tables that are not exported: %s", strings.Join(unknown, ", "))
+	}
+	return selected, nil
+}
+
+// Apply returns the tables of tables that f includes, narrowing the query of
+// every table that has a row filter
+func (f TableFilter) Apply(tables []Table) ([]Table, error) {
+	names := make([]string, len(tables))
+	for i, table := range tables {
+		names[i] = table.Name
+	}
+	selected, err := f.Select(names)
+	if err != nil {
+		return nil, err
+	}
+
+	keep := make(map[string]bool, len(selected))
+	for _, name := range selected {
+		keep[name] = true
+	}
+	var filtered []Table
+	for _, table := range tables {
+		if !keep[table.Name] {
+			continue
+		}
+		table.Select = f.Narrow(table.Name, table.Select)
+		filtered = append(filtered, table)
+	}
+	return filtered, nil
+}
+
+// Narrow appends the row filter of the table called name, if it has one, to
+// query, which must select from that table without a WHERE clause
+func (f TableFilter) Narrow(name, query string) string {
+	if condition, ok := f.Where[name]; ok {
+		return query + " WHERE (" + condition + ")"
+	}
+	return query
+}
+
+// matchAny reports whether name matches one of patterns
+func matchAny(patterns []string, name string) bool {
+	_, unqualified, qualified := strings.Cut(name, ".")
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+		if qualified && !strings.Contains(pattern, ".") {
+			if ok, _ := path.Match(pattern, unqualified); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package drivers
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+)
+
+// ColumnType is the engine independent type of a column, as inferred from
+// CSV data or mapped from a column's declared type
+type ColumnType string
+
+// Column types
+const (
+	TypeInteger   ColumnType = "integer"
+	TypeFloat     ColumnType = "float"
+	TypeBoolean   ColumnType = "boolean"
+	TypeDate      ColumnType = "date"
+	TypeTimestamp ColumnType = "timestamp"
+	TypeText      ColumnType = "text"
+)
+
+// ColumnTypes lists every column type, most specific first
+var ColumnTypes = []ColumnType{TypeInteger, TypeFloat, TypeBoolean, TypeDate, TypeTimestamp, TypeText}
+
+// Column is a named, typed table column
+type Column struct {
+	Name string
+	Type ColumnType
+}
+
+// TableLoader is implemented by drivers that can create tables and bulk
+// load rows into them, which CSV imports rely on. Tables are named as
+// returned by Tables.
+type TableLoader interface {
+	TableReader
+
+	// Columns returns the columns of table in their declared order
+	Columns(ctx context.Context, db *sql.DB, table string) ([]Column, error)
+
+	// CreateTable creates table with the given columns
+	CreateTable(ctx context.Context, db *sql.DB, table string, columns []Column) error
+
+	// Load inserts every row returned by next into the named columns of
+	// table until next returns io.EOF, and returns the number of rows
+	// loaded. Values are strings in the canonical form of their column
+	// type (integers, decimals, 1/0, 2006-01-02, 2006-01-02 15:04:05.999999)
+	// or nil for NULL. Rows the server skips or stores with altered values
+	// instead of failing are reported to reject with their position among
+	// the rows of next, counting from 1, or 0 when the server does not say.
+	Load(ctx context.Context, db *sql.DB, table string, columns []string, next func() ([]any, error), reject func(row int64, reason string)) (int64, error)
+}
+
+// GenericType maps a declared column type, such as "varchar(255)" or
+// "timestamp without time zone", to a column type
+func GenericType(dbType string) ColumnType {
+	name := strings.ToUpper(dbType)
+	if i := strings.IndexByte(name, '('); i >= 0 {
+		name = name[:i]
+	}
+	words := strings.Fields(name)
+	if len(words) == 0 {
+		return TypeText
+	}
+
+	switch words[0] {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "INTEGER", "BIGINT", "INT2", "INT4", "INT8",
+		"SMALLSERIAL", "SERIAL", "BIGSERIAL", "SERIAL2", "SERIAL4", "SERIAL8":
+		return TypeInteger
+	case "FLOAT", "DOUBLE", "REAL", "DECIMAL", "NUMERIC", "FLOAT4", "FLOAT8":
+		return TypeFloat
+	case "BOOL", "BOOLEAN":
+		return TypeBoolean
+	case "DATE":
+		return TypeDate
+	case "DATETIME", "TIMESTAMP", "TIMESTAMPTZ":
+		return TypeTimestamp
+	default:
+		return TypeText
+	}
+}
@@ -0,0 +1,352 @@
+// Package mysql implements the MySQL/MariaDB driver on top of the mysql and
+// mysqldump command line clients.
+package mysql
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"strings"
+	"unicode/utf8"
+
+	"sqlclient-export-import/internal/drivers"
+)
+
+// maxNameLength is the longest database name MySQL accepts, in characters
+const maxNameLength = 64
+
+var systemDatabases = []string{"information_schema", "mysql", "performance_schema", "sys"}
+
+// Driver talks to MySQL and MariaDB servers
+type Driver struct{}
+
+// New returns a MySQL/MariaDB driver
+func New() *Driver {
+	return &Driver{}
+}
+
+// IsBinaryType reports whether a MySQL type name holds raw bytes, which
+// includes BIT
+func (d *Driver) IsBinaryType(dbType string) bool {
+	return isBinaryType(dbType)
+}
+
+func isBinaryType(dbType string) bool {
+	return strings.EqualFold(dbType, "BIT") || drivers.IsBinaryType(dbType)
+}
+
+// RequiresServer returns true since MySQL is a client/server database
+func (d *Driver) RequiresServer() bool {
+	return true
+}
+
+// DefaultPort returns the standard MySQL port
+func (d *Driver) DefaultPort() string {
+	return "3306"
+}
+
+// IsSystemDatabase reports whether name is one of the MySQL system schemas
+func (d *Driver) IsSystemDatabase(name string) bool {
+	for _, sysDB := range systemDatabases {
+		if strings.EqualFold(name, sysDB) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateName checks name against the MySQL rules for database names: at
+// most 64 characters of the Basic Multilingual Plane, not ending with a
+// space
+func (d *Driver) ValidateName(name string) error {
+	if err := drivers.CheckName(name); err != nil {
+		return err
+	}
+	if utf8.RuneCountInString(name) > maxNameLength {
+		return &drivers.InvalidNameError{Name: name, Reason: fmt.Sprintf("MySQL names are limited to %d characters", maxNameLength)}
+	}
+	if strings.HasSuffix(name, " ") {
+		return &drivers.InvalidNameError{Name: name, Reason: "MySQL names cannot end with a space"}
+	}
+	if strings.IndexFunc(name, func(r rune) bool { return r > 0xFFFF }) >= 0 {
+		return &drivers.InvalidNameError{Name: name, Reason: "MySQL names cannot contain supplementary characters such as emoji"}
+	}
+	return nil
+}
+
+// Export dumps database with mysqldump. Tables, views and sequences left out
+// of the dump are passed to --ignore-table. Since --where applies to every
+// table of a run, each table with a row filter is dumped by a run of its own
+// afterwards.
+func (d *Driver) Export(ctx context.Context, conn drivers.Connection, database string, w io.Writer, opts drivers.ExportOptions) error {
+	if err := d.ValidateName(database); err != nil {
+		return err
+	}
+
+	// Options shared by every run
+	shared := []string{"--column-statistics=0"}
+	switch opts.Mode {
+	case drivers.ModeSchema:
+		shared = append(shared, "--no-data")
+	case drivers.ModeData:
+		shared = append(shared, "--no-create-info")
+	}
+	if !opts.Schema() || opts.Skip.Triggers {
+		shared = append(shared, "--skip-triggers")
+	}
+
+	args := append(append([]string{}, shared...), "--databases", database)
+	if opts.Schema() {
+		if !opts.Skip.Routines {
+			args = append(args, "--routines")
+		}
+		if !opts.Skip.Events {
+			args = append(args, "--events")
+		}
+	} else {
+		args = append(args, "--no-create-db")
+	}
+
+	var filtered []string
+	if !opts.Tables.IsZero() || opts.Skip.Views || opts.Skip.Sequences {
+		var ignored []string
+		var err error
+		ignored, filtered, err = d.splitTables(ctx, conn, database, opts)
+		if err != nil {
+			return err
+		}
+		for _, table := range ignored {
+			args = append(args, "--ignore-table="+database+"."+table)
+		}
+	}
+
+	if err := dump(ctx, conn, w, opts.Stderr, args...); err != nil {
+		return err
+	}
+
+	for _, table := range filtered {
+		args := append(append([]string{}, shared...), "--where="+opts.Tables.Where[table], database, table)
+		if err := dump(ctx, conn, w, opts.Stderr, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dump runs mysqldump with args, writing the dump to w and its stderr
+// output to stderr
+func dump(ctx context.Context, conn drivers.Connection, w, stderr io.Writer, args ...string) error {
+	cmd, cleanup, err := command(ctx, "mysqldump", conn, args...)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	cmd.Stdout = w
+	return drivers.RunStreaming(cmd, stderr)
+}
+
+// splitTables lists the tables, views and sequences of database and returns
+// the ones the main mysqldump run ignores, because opts leaves them out or
+// narrows their rows, along with the tables dumped separately with a row
+// filter
+func (d *Driver) splitTables(ctx context.Context, conn drivers.Connection, database string, opts drivers.ExportOptions) ([]string, []string, error) {
+	db, err := open(ctx, conn, database, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer db.Close()
+
+	tables, views, sequences, err := listObjects(ctx, db)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var ignored, filtered []string
+	if opts.Skip.Views {
+		ignored = append(ignored, views...)
+	}
+	if opts.Skip.Sequences {
+		ignored = append(ignored, sequences...)
+	}
+	if opts.Tables.IsZero() {
+		return ignored, nil, nil
+	}
+
+	selected, err := opts.Tables.Select(tables)
+	if err != nil {
+		return nil, nil, err
+	}
+	keep := make(map[string]bool, len(selected))
+	for _, name := range selected {
+		keep[name] = true
+	}
+	for _, name := range tables {
+		// Row filters only matter when the rows are dumped
+		_, narrowed := opts.Tables.Where[name]
+		narrowed = narrowed && opts.Data()
+		if narrowed {
+			filtered = append(filtered, name)
+		}
+		if !keep[name] || narrowed {
+			ignored = append(ignored, name)
+		}
+	}
+	return ignored, filtered, nil
+}
+
+// ToolVersion reports the version of mysqldump
+func (d *Driver) ToolVersion(ctx context.Context, opts drivers.ExportOptions) (string, error) {
+	return drivers.Version(ctx, "mysqldump")
+}
+
+// Import pipes the SQL read from r into the mysql client
+func (d *Driver) Import(ctx context.Context, conn drivers.Connection, database string, r io.Reader, opts drivers.ImportOptions) error {
+	if err := d.ValidateName(database); err != nil {
+		return err
+	}
+	cmd, cleanup, err := command(ctx, "mysql", conn, "--max_allowed_packet=1G", database)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	cmd.Stdin = r
+	return drivers.RunStreaming(cmd, opts.Stderr)
+}
+
+// List returns every database on the server
+func (d *Driver) List(ctx context.Context, conn drivers.Connection) ([]string, error) {
+	var stdout bytes.Buffer
+	cmd, cleanup, err := command(ctx, "mysql", conn, "-e", "SHOW DATABASES;")
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	cmd.Stdout = &stdout
+	if err := drivers.Run(cmd); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line == "Database" {
+			continue
+		}
+		names = append(names, line)
+	}
+	return names, nil
+}
+
+// Create creates a new database
+func (d *Driver) Create(ctx context.Context, conn drivers.Connection, name string) error {
+	if err := d.ValidateName(name); err != nil {
+		return err
+	}
+	return execute(ctx, conn, "CREATE DATABASE "+dialect{}.QuoteIdentifier(name)+";")
+}
+
+// Rename copies every object of from into a new database named to and drops
+// from afterwards, since MySQL has no RENAME DATABASE statement
+func (d *Driver) Rename(ctx context.Context, conn drivers.Connection, from, to string) error {
+	if err := d.ValidateName(from); err != nil {
+		return err
+	}
+	if err := d.Create(ctx, conn, to); err != nil {
+		return fmt.Errorf("failed to create target database: %w", err)
+	}
+
+	if err := d.copy(ctx, conn, from, to); err != nil {
+		// Remove the half-filled target even when ctx was cancelled
+		if dropErr := d.Drop(context.Background(), conn, to); dropErr != nil {
+			log.Printf("Failed to drop partial database %s: %v", to, dropErr)
+		}
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := d.Drop(ctx, conn, from); err != nil {
+		return fmt.Errorf("failed to drop source database (rename partially completed): %w", err)
+	}
+	return nil
+}
+
+// copy streams the dump of from straight into to
+func (d *Driver) copy(ctx context.Context, conn drivers.Connection, from, to string) error {
+	pr, pw := io.Pipe()
+	exportErr := make(chan error, 1)
+	go func() {
+		err := dump(ctx, conn, pw, nil, "--column-statistics=0", from)
+		pw.CloseWithError(err)
+		exportErr <- err
+	}()
+
+	importErr := d.Import(ctx, conn, to, pr, drivers.ImportOptions{})
+	pr.Close()
+	if err := <-exportErr; err != nil {
+		return fmt.Errorf("failed to export source database: %w", err)
+	}
+	if importErr != nil {
+		return fmt.Errorf("failed to import to target database: %w", importErr)
+	}
+	return nil
+}
+
+// Drop removes a database
+func (d *Driver) Drop(ctx context.Context, conn drivers.Connection, name string) error {
+	if err := d.ValidateName(name); err != nil {
+		return err
+	}
+	return execute(ctx, conn, "DROP DATABASE "+dialect{}.QuoteIdentifier(name)+";")
+}
+
+// execute runs a statement with the mysql client
+func execute(ctx context.Context, conn drivers.Connection, statement string) error {
+	cmd, cleanup, err := command(ctx, "mysql", conn, "-e", statement)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	return drivers.Run(cmd)
+}
+
+// command builds an invocation of one of the MySQL clients with the
+// connection options followed by args. The password is passed in a
+// temporary option file, out of sight of ps; cleanup removes it and must be
+// called once the command has finished.
+func command(ctx context.Context, name string, conn drivers.Connection, args ...string) (cmd *exec.Cmd, cleanup func(), err error) {
+	connArgs := []string{
+		"-h", conn.Host,
+		"-P", conn.Port,
+		"-u", conn.Username,
+	}
+
+	cleanup = func() {}
+	if conn.Password != "" {
+		var path string
+		path, cleanup, err = drivers.SecretFile("mysql-*.cnf", "[client]\npassword=\""+optionValue(conn.Password)+"\"\n")
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to write the MySQL option file: %w", err)
+		}
+		// The client only accepts --defaults-extra-file as its first option
+		connArgs = append([]string{"--defaults-extra-file=" + path}, connArgs...)
+	}
+
+	drivers.LogCommand(name, append(append([]string{}, connArgs...), args...))
+	return drivers.Command(ctx, name, append(connArgs, args...)...), cleanup, nil
+}
+
+// optionReplacer escapes the characters that MySQL option files treat
+// specially inside values
+var optionReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+// optionValue escapes s for a double quoted option file value. The parser
+// toggles quoting at every unescaped quote and treats a # outside quotes as
+// the start of a comment, so quotes inside must be escaped too.
+func optionValue(s string) string {
+	return optionReplacer.Replace(s)
+}
@@ -0,0 +1,299 @@
+package mysql
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"sqlclient-export-import/internal/drivers"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+)
+
+// dialect renders MySQL identifiers and literals
+type dialect struct{}
+
+func (dialect) QuoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+func (dialect) Literal(value any, dbType string) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case time.Time:
+		return quoteString(v.Format("2006-01-02 15:04:05.999999"))
+	case []byte:
+		if isBinaryType(dbType) {
+			if len(v) == 0 {
+				return "''"
+			}
+			return "X'" + hex.EncodeToString(v) + "'"
+		}
+		return quoteString(string(v))
+	default:
+		return quoteString(fmt.Sprint(v))
+	}
+}
+
+// quoteString escapes s the same way mysqldump does
+func quoteString(s string) string {
+	var b strings.Builder
+	b.Grow(len(s) + 2)
+	b.WriteByte('\'')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case 0:
+			b.WriteString(`\0`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\'':
+			b.WriteString(`\'`)
+		case '"':
+			b.WriteString(`\"`)
+		case 0x1a:
+			b.WriteString(`\Z`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
+
+// open connects to database over database/sql. With parseTime, DATE and
+// DATETIME columns are scanned as time.Time instead of raw text.
+func open(ctx context.Context, conn drivers.Connection, database string, parseTime bool) (*sql.DB, error) {
+	dsn := mysqldriver.NewConfig()
+	dsn.User = conn.Username
+	dsn.Passwd = conn.Password
+	dsn.Net = "tcp"
+	dsn.Addr = net.JoinHostPort(conn.Host, conn.Port)
+	dsn.DBName = database
+	dsn.Params = map[string]string{"charset": "utf8mb4"}
+	dsn.ParseTime = parseTime
+
+	db, err := sql.Open("mysql", dsn.FormatDSN())
+	if err != nil {
+		return nil, err
+	}
+	// Keep a single session so session variables stay in effect
+	db.SetMaxOpenConns(1)
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// ExportNative dumps database without mysqldump, writing the DDL of every
+// table, sequence and view followed by batched INSERTs of the table data.
+// Routines, triggers and events are not dumped.
+func (d *Driver) ExportNative(ctx context.Context, conn drivers.Connection, database string, w io.Writer, opts drivers.ExportOptions) error {
+	db, err := open(ctx, conn, database, false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var version string
+	if err := db.QueryRowContext(ctx, "SELECT VERSION()").Scan(&version); err != nil {
+		return err
+	}
+
+	tables, views, sequences, err := listObjects(ctx, db)
+	if err != nil {
+		return err
+	}
+	if !opts.Tables.IsZero() {
+		if tables, err = opts.Tables.Select(tables); err != nil {
+			return err
+		}
+	}
+	if opts.Skip.Views {
+		views = nil
+	}
+	if opts.Skip.Sequences {
+		sequences = nil
+	}
+
+	q := dialect{}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "-- Native dump of database %s\n-- Server version: %s\n-- Dumped at: %s\n\n",
+		database, version, time.Now().Format(time.RFC3339))
+	fmt.Fprintf(bw, "SET NAMES utf8mb4;\nSET FOREIGN_KEY_CHECKS=0;\nSET UNIQUE_CHECKS=0;\n\n")
+	if opts.Schema() {
+		fmt.Fprintf(bw, "CREATE DATABASE IF NOT EXISTS %s;\n", q.QuoteIdentifier(database))
+	}
+	fmt.Fprintf(bw, "USE %s;\n\n", q.QuoteIdentifier(database))
+
+	for _, name := range sequences {
+		var ignored, ddl string
+		if err := db.QueryRowContext(ctx, "SHOW CREATE SEQUENCE "+q.QuoteIdentifier(name)).Scan(&ignored, &ddl); err != nil {
+			return err
+		}
+		var next int64
+		if err := db.QueryRowContext(ctx, "SELECT next_not_cached_value FROM "+q.QuoteIdentifier(name)).Scan(&next); err != nil {
+			return err
+		}
+		fmt.Fprintf(bw, "--\n-- Sequence structure for %s\n--\n\n", q.QuoteIdentifier(name))
+		if opts.Schema() {
+			fmt.Fprintf(bw, "DROP SEQUENCE IF EXISTS %s;\n%s;\n", q.QuoteIdentifier(name), ddl)
+		}
+		if opts.Data() {
+			fmt.Fprintf(bw, "SELECT SETVAL(%s, %d, 0);\n", q.QuoteIdentifier(name), next)
+		}
+		fmt.Fprintln(bw)
+	}
+
+	for _, name := range tables {
+		if opts.Schema() {
+			// SHOW CREATE TABLE includes the table's indexes and constraints
+			var ignored, ddl string
+			if err := db.QueryRowContext(ctx, "SHOW CREATE TABLE "+q.QuoteIdentifier(name)).Scan(&ignored, &ddl); err != nil {
+				return err
+			}
+			fmt.Fprintf(bw, "--\n-- Table structure for table %s\n--\n\n", q.QuoteIdentifier(name))
+			fmt.Fprintf(bw, "DROP TABLE IF EXISTS %s;\n%s;\n\n", q.QuoteIdentifier(name), ddl)
+		}
+		if !opts.Data() {
+			continue
+		}
+
+		columns, err := insertColumns(ctx, db, name)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(bw, "--\n-- Dumping data for table %s\n--\n\n", q.QuoteIdentifier(name))
+		query := opts.Tables.Narrow(name, "SELECT "+strings.Join(columns, ", ")+" FROM "+q.QuoteIdentifier(name))
+		if _, err := drivers.WriteInserts(ctx, bw, db, q, q.QuoteIdentifier(name), query); err != nil {
+			return fmt.Errorf("failed to dump table %s: %w", name, err)
+		}
+		fmt.Fprintln(bw)
+	}
+
+	if !opts.Schema() {
+		views = nil
+	}
+	// Views may read from views that come later by name, so like mysqldump
+	// every view is first created as a stand-in with the same columns and
+	// then replaced by its definition
+	for _, name := range views {
+		columns, err := viewColumns(ctx, db, name)
+		if err != nil {
+			return err
+		}
+		stand := []string{"1"} // for views too broken to list their columns
+		if len(columns) > 0 {
+			stand = make([]string, len(columns))
+			for i, column := range columns {
+				stand[i] = "1 AS " + q.QuoteIdentifier(column)
+			}
+		}
+		fmt.Fprintf(bw, "--\n-- Temporary view structure for view %s\n--\n\n", q.QuoteIdentifier(name))
+		fmt.Fprintf(bw, "DROP VIEW IF EXISTS %s;\nCREATE VIEW %s AS SELECT %s;\n\n", q.QuoteIdentifier(name), q.QuoteIdentifier(name), strings.Join(stand, ", "))
+	}
+	for _, name := range views {
+		var ignored, ddl, charset, collation string
+		if err := db.QueryRowContext(ctx, "SHOW CREATE VIEW "+q.QuoteIdentifier(name)).Scan(&ignored, &ddl, &charset, &collation); err != nil {
+			return err
+		}
+		fmt.Fprintf(bw, "--\n-- View structure for view %s\n--\n\n", q.QuoteIdentifier(name))
+		fmt.Fprintf(bw, "DROP VIEW IF EXISTS %s;\n%s;\n\n", q.QuoteIdentifier(name), ddl)
+	}
+
+	fmt.Fprintf(bw, "SET FOREIGN_KEY_CHECKS=1;\nSET UNIQUE_CHECKS=1;\n")
+	return bw.Flush()
+}
+
+// insertColumns lists the quoted names of the columns of table whose values
+// are dumped. Generated columns are left out, since the server refuses
+// values for them and computes them again on restore. DEFAULT_GENERATED
+// only marks expression defaults.
+func insertColumns(ctx context.Context, db *sql.DB, table string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `SELECT COLUMN_NAME FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?
+			AND EXTRA NOT REGEXP '(VIRTUAL|STORED|PERSISTENT) GENERATED'
+		ORDER BY ORDINAL_POSITION`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	q := dialect{}
+	var columns []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, err
+		}
+		columns = append(columns, q.QuoteIdentifier(column))
+	}
+	return columns, rows.Err()
+}
+
+// viewColumns returns the names of the columns of view in order
+func viewColumns(ctx context.Context, db *sql.DB, view string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `SELECT COLUMN_NAME FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION`, view)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, err
+		}
+		columns = append(columns, column)
+	}
+	return columns, rows.Err()
+}
+
+// listObjects returns the names of the base tables, views and (on MariaDB)
+// sequences of the database db is connected to
+func listObjects(ctx context.Context, db *sql.DB) (tables, views, sequences []string, err error) {
+	rows, err := db.QueryContext(ctx, "SHOW FULL TABLES")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name, kind string
+		if err := rows.Scan(&name, &kind); err != nil {
+			return nil, nil, nil, err
+		}
+		switch kind {
+		case "VIEW":
+			views = append(views, name)
+		case "SEQUENCE":
+			sequences = append(sequences, name)
+		default:
+			tables = append(tables, name)
+		}
+	}
+	return tables, views, sequences, rows.Err()
+}
@@ -0,0 +1,255 @@
+package mysql
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"sqlclient-export-import/internal/drivers"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+)
+
+// OpenDB connects to database, scanning date and time columns as time.Time
+func (d *Driver) OpenDB(ctx context.Context, conn drivers.Connection, database string) (*sql.DB, error) {
+	return open(ctx, conn, database, true)
+}
+
+// ServerVersion returns the version of the MySQL or MariaDB server
+func (d *Driver) ServerVersion(ctx context.Context, db *sql.DB) (string, error) {
+	var version string
+	err := db.QueryRowContext(ctx, "SELECT VERSION()").Scan(&version)
+	return version, err
+}
+
+// Tables lists the base tables of the current database
+func (d *Driver) Tables(ctx context.Context, db *sql.DB) ([]drivers.Table, error) {
+	rows, err := db.QueryContext(ctx, "SHOW FULL TABLES WHERE Table_type = 'BASE TABLE'")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	q := dialect{}
+	var tables []drivers.Table
+	for rows.Next() {
+		var name, kind string
+		if err := rows.Scan(&name, &kind); err != nil {
+			return nil, err
+		}
+		tables = append(tables, drivers.Table{
+			Name:   name,
+			Select: "SELECT * FROM " + q.QuoteIdentifier(name),
+		})
+	}
+	return tables, rows.Err()
+}
+
+// columnTypes are the declared types of columns created for CSV imports
+var columnTypes = map[drivers.ColumnType]string{
+	drivers.TypeInteger:   "BIGINT",
+	drivers.TypeFloat:     "DOUBLE",
+	drivers.TypeBoolean:   "BOOLEAN",
+	drivers.TypeDate:      "DATE",
+	drivers.TypeTimestamp: "DATETIME(6)",
+	drivers.TypeText:      "TEXT",
+}
+
+// loadCounter makes the reader name of every LOAD DATA statement unique
+var loadCounter atomic.Int64
+
+// Columns returns the columns of table in the current database
+func (d *Driver) Columns(ctx context.Context, db *sql.DB, table string) ([]drivers.Column, error) {
+	rows, err := db.QueryContext(ctx, `SELECT COLUMN_NAME, COLUMN_TYPE FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []drivers.Column
+	for rows.Next() {
+		var name, dbType string
+		if err := rows.Scan(&name, &dbType); err != nil {
+			return nil, err
+		}
+		column := drivers.Column{Name: name, Type: drivers.GenericType(dbType)}
+		// BOOLEAN columns are stored as TINYINT(1)
+		if strings.EqualFold(dbType, "tinyint(1)") {
+			column.Type = drivers.TypeBoolean
+		}
+		columns = append(columns, column)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("table %s not found", table)
+	}
+	return columns, nil
+}
+
+// CreateTable creates table with the given columns
+func (d *Driver) CreateTable(ctx context.Context, db *sql.DB, table string, columns []drivers.Column) error {
+	q := dialect{}
+	definitions := make([]string, len(columns))
+	for i, column := range columns {
+		definitions[i] = "  " + q.QuoteIdentifier(column.Name) + " " + columnTypes[column.Type]
+	}
+	_, err := db.ExecContext(ctx, fmt.Sprintf("CREATE TABLE %s (\n%s\n) DEFAULT CHARSET=utf8mb4", q.QuoteIdentifier(table), strings.Join(definitions, ",\n")))
+	return err
+}
+
+// Load streams the rows to the server with LOAD DATA LOCAL INFILE, which
+// requires local_infile to be enabled on the server. LOCAL loads turn
+// errors into warnings: rows with duplicate keys are skipped and values
+// that do not fit are truncated, which are reported to reject.
+func (d *Driver) Load(ctx context.Context, db *sql.DB, table string, columns []string, next func() ([]any, error), reject func(row int64, reason string)) (int64, error) {
+	q := dialect{}
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = q.QuoteIdentifier(column)
+	}
+
+	// The warnings are read on the connection that ran the load
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SET SESSION max_error_count = 65535"); err != nil {
+		return 0, err
+	}
+
+	pr, pw := io.Pipe()
+	defer pr.Close()
+
+	name := "load-" + strconv.FormatInt(loadCounter.Add(1), 10)
+	mysqldriver.RegisterReaderHandler(name, func() io.Reader { return pr })
+	defer mysqldriver.DeregisterReaderHandler(name)
+
+	var sent int64
+	writeErr := make(chan error, 1)
+	go func() {
+		err := writeLoadData(pw, func() ([]any, error) {
+			row, err := next()
+			if err == nil {
+				sent++
+			}
+			return row, err
+		})
+		pw.CloseWithError(err)
+		writeErr <- err
+	}()
+
+	// The stream uses the default LOAD DATA format: tab separated fields,
+	// backslash escapes and \N for NULL
+	result, err := conn.ExecContext(ctx, fmt.Sprintf("LOAD DATA LOCAL INFILE 'Reader::%s' INTO TABLE %s CHARACTER SET utf8mb4 (%s)",
+		name, q.QuoteIdentifier(table), strings.Join(quoted, ", ")))
+	pr.CloseWithError(io.ErrClosedPipe)
+	if rowErr := <-writeErr; rowErr != nil && rowErr != io.ErrClosedPipe {
+		return 0, rowErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	loaded, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if err := reportWarnings(ctx, conn, sent-loaded, reject); err != nil {
+		return loaded, err
+	}
+	return loaded, nil
+}
+
+// errDuplicateEntry is the code of the warnings for rows skipped because of
+// a duplicate key
+const errDuplicateEntry = 1062
+
+// warningRow finds the row number in warnings such as "Data truncated for
+// column 'a' at row 3"
+var warningRow = regexp.MustCompile(`(?i)\brow (\d+)`)
+
+// reportWarnings reports the rows that the last load on conn skipped, of
+// which there were skipped, or stored with altered values. Warnings about
+// the same row are reported together.
+func reportWarnings(ctx context.Context, conn *sql.Conn, skipped int64, reject func(row int64, reason string)) error {
+	rows, err := conn.QueryContext(ctx, "SHOW WARNINGS")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var order []int64
+	reasons := make(map[int64][]string)
+	for rows.Next() {
+		var level, message string
+		var code int
+		if err := rows.Scan(&level, &code, &message); err != nil {
+			return err
+		}
+		if code == errDuplicateEntry && skipped > 0 {
+			skipped--
+		}
+		var row int64
+		if m := warningRow.FindStringSubmatch(message); m != nil {
+			row, _ = strconv.ParseInt(m[1], 10, 64)
+		}
+		if row == 0 {
+			reject(0, message)
+			continue
+		}
+		if _, ok := reasons[row]; !ok {
+			order = append(order, row)
+		}
+		reasons[row] = append(reasons[row], message)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, row := range order {
+		reject(row, strings.Join(reasons[row], "; "))
+	}
+	// Skipped rows beyond the warnings the server kept
+	for ; skipped > 0; skipped-- {
+		reject(0, "skipped by the server")
+	}
+	return nil
+}
+
+// writeLoadData writes every row returned by next to w in the default LOAD
+// DATA text format
+func writeLoadData(w io.Writer, next func() ([]any, error)) error {
+	bw := bufio.NewWriter(w)
+	escaper := strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`, "\x00", `\0`)
+	for {
+		row, err := next()
+		if err == io.EOF {
+			return bw.Flush()
+		}
+		if err != nil {
+			return err
+		}
+		for i, value := range row {
+			if i > 0 {
+				bw.WriteByte('\t')
+			}
+			if value == nil {
+				bw.WriteString(`\N`)
+				continue
+			}
+			escaper.WriteString(bw, fmt.Sprint(value))
+		}
+		if err := bw.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package drivers
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// InvalidNameError reports a database name an engine does not accept
+type InvalidNameError struct {
+	Name   string
+	Reason string
+}
+
+func (e *InvalidNameError) Error() string {
+	return fmt.Sprintf("invalid database name %q: %s", e.Name, e.Reason)
+}
+
+// CheckName applies the rules every engine shares to a database name. It
+// must be non-empty UTF-8 without control characters, and must not start
+// with a dash, which the command line clients would take for an option.
+func CheckName(name string) error {
+	switch {
+	case name == "":
+		return &InvalidNameError{Name: name, Reason: "the name is empty"}
+	case !utf8.ValidString(name):
+		return &InvalidNameError{Name: name, Reason: "the name is not valid UTF-8"}
+	case strings.IndexFunc(name, unicode.IsControl) >= 0:
+		return &InvalidNameError{Name: name, Reason: "the name contains control characters"}
+	case strings.HasPrefix(name, "-"):
+		return &InvalidNameError{Name: name, Reason: "the name starts with a dash"}
+	}
+	return nil
+}
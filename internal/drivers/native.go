@@ -0,0 +1,143 @@
+package drivers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// InsertBatchSize is the number of rows grouped into a single INSERT
+// statement by the native exporters
+const InsertBatchSize = 500
+
+// NativeExporter is implemented by drivers that can dump a database over
+// database/sql, without the engine's command line client being installed
+type NativeExporter interface {
+	ExportNative(ctx context.Context, conn Connection, database string, w io.Writer, opts ExportOptions) error
+}
+
+// Table is a table whose rows can be read over database/sql
+type Table struct {
+	// Name identifies the table to users, qualified with its schema on
+	// engines that have schemas
+	Name string
+
+	// Select is the query that reads every row of the table
+	Select string
+}
+
+// TableReader is implemented by drivers whose tables can be read row by row
+// over database/sql, which the data export formats rely on
+type TableReader interface {
+	// OpenDB connects to database
+	OpenDB(ctx context.Context, conn Connection, database string) (*sql.DB, error)
+
+	// Tables lists the base tables of the database db is connected to
+	Tables(ctx context.Context, db *sql.DB) ([]Table, error)
+}
+
+// ServerVersioner is implemented by table readers that can report the
+// version of the engine behind a connection, which export manifests record
+type ServerVersioner interface {
+	ServerVersion(ctx context.Context, db *sql.DB) (string, error)
+}
+
+// Querier runs queries on a database, or within a transaction of it
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Dialect renders identifiers and values as SQL text for one engine
+type Dialect interface {
+	// QuoteIdentifier quotes a table or column name
+	QuoteIdentifier(name string) string
+
+	// Literal renders a value scanned from a column of the given database
+	// type name (as reported by sql.ColumnType) as a SQL literal
+	Literal(value any, dbType string) string
+}
+
+// WriteInserts runs query and writes the resulting rows to w as batched
+// INSERT statements into target, which must already be quoted. It returns
+// the number of rows written.
+func WriteInserts(ctx context.Context, w io.Writer, db Querier, dialect Dialect, target, query string) (int64, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return 0, err
+	}
+
+	quoted := make([]string, len(columnTypes))
+	for i, ct := range columnTypes {
+		quoted[i] = dialect.QuoteIdentifier(ct.Name())
+	}
+	prefix := fmt.Sprintf("INSERT INTO %s (%s) VALUES\n", target, strings.Join(quoted, ", "))
+
+	values := make([]any, len(columnTypes))
+	pointers := make([]any, len(columnTypes))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+
+	literals := make([]string, len(columnTypes))
+	inBatch := 0
+	var written int64
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return written, err
+		}
+		for i, value := range values {
+			literals[i] = dialect.Literal(value, columnTypes[i].DatabaseTypeName())
+		}
+
+		separator := ",\n"
+		if inBatch == 0 {
+			separator = prefix
+		}
+		if _, err := fmt.Fprintf(w, "%s(%s)", separator, strings.Join(literals, ", ")); err != nil {
+			return written, err
+		}
+
+		written++
+		inBatch++
+		if inBatch == InsertBatchSize {
+			if _, err := io.WriteString(w, ";\n"); err != nil {
+				return written, err
+			}
+			inBatch = 0
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return written, err
+	}
+
+	if inBatch > 0 {
+		if _, err := io.WriteString(w, ";\n"); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// IsBinaryType reports whether a database type name holds raw bytes that
+// must be written as a hex literal rather than a string. BIT is left out,
+// as MySQL scans it as bytes but PostgreSQL as text such as "1010".
+func IsBinaryType(dbType string) bool {
+	dbType = strings.ToUpper(dbType)
+	return strings.Contains(dbType, "BLOB") || strings.Contains(dbType, "BINARY") ||
+		dbType == "BYTEA" || dbType == "GEOMETRY"
+}
+
+// BinaryTyper is implemented by drivers whose engine has binary types that
+// IsBinaryType does not cover
+type BinaryTyper interface {
+	IsBinaryType(dbType string) bool
+}
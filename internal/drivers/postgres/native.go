@@ -0,0 +1,533 @@
+package postgres
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"sqlclient-export-import/internal/drivers"
+
+	_ "github.com/lib/pq"
+)
+
+// userSchemas filters out the catalogs PostgreSQL creates itself
+const userSchemas = `n.nspname NOT IN ('pg_catalog', 'information_schema')
+	AND n.nspname NOT LIKE 'pg_toast%' AND n.nspname NOT LIKE 'pg_temp%'`
+
+// dialect renders PostgreSQL identifiers and literals
+type dialect struct{}
+
+func (dialect) QuoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func (dialect) Literal(value any, dbType string) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return quoteString(strconv.FormatFloat(v, 'g', -1, 64))
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	case time.Time:
+		switch dbType {
+		case "DATE":
+			return quoteString(v.Format("2006-01-02"))
+		case "TIME":
+			return quoteString(v.Format("15:04:05.999999"))
+		case "TIMETZ":
+			return quoteString(v.Format("15:04:05.999999-07:00"))
+		case "TIMESTAMPTZ":
+			return quoteString(v.Format("2006-01-02 15:04:05.999999-07:00"))
+		default:
+			return quoteString(v.Format("2006-01-02 15:04:05.999999"))
+		}
+	case []byte:
+		if drivers.IsBinaryType(dbType) {
+			return `'\x` + hex.EncodeToString(v) + `'`
+		}
+		return quoteString(string(v))
+	default:
+		return quoteString(fmt.Sprint(v))
+	}
+}
+
+// quoteString renders s as a standard conforming string literal
+func quoteString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// open connects to database over database/sql
+func open(ctx context.Context, conn drivers.Connection, database string) (*sql.DB, error) {
+	dsn := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(conn.Username, conn.Password),
+		Host:   conn.Host + ":" + conn.Port,
+		Path:   "/" + database,
+	}
+	query := url.Values{}
+	query.Set("sslmode", "prefer")
+	dsn.RawQuery = query.Encode()
+
+	db, err := sql.Open("postgres", dsn.String())
+	if err != nil {
+		return nil, err
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// relation is a schema qualified table, view or sequence
+type relation struct {
+	oid    int64
+	schema string
+	name   string
+	kind   string // pg_class.relkind, such as "r" for tables and "p" for partitioned tables
+}
+
+// qualified returns the schema qualified name of r, as shown to users
+func (r relation) qualified() string {
+	return r.schema + "." + r.name
+}
+
+func (r relation) quoted() string {
+	q := dialect{}
+	return q.QuoteIdentifier(r.schema) + "." + q.QuoteIdentifier(r.name)
+}
+
+// from returns the FROM item that reads the rows of table r. A partitioned
+// table holds no rows itself, so it is read with its partitions, which are
+// not dumped on their own. Other tables leave out the rows of tables that
+// inherit from them, which are.
+func (r relation) from() string {
+	if r.kind == "p" {
+		return r.quoted()
+	}
+	return "ONLY " + r.quoted()
+}
+
+// ExportNative dumps database without pg_dump. It covers schemas, sequences,
+// tables with their constraints and indexes, views and the table data, but
+// not functions, triggers or custom types. Partitioned tables are recreated
+// as plain tables holding the rows of all their partitions.
+func (d *Driver) ExportNative(ctx context.Context, conn drivers.Connection, database string, w io.Writer, opts drivers.ExportOptions) error {
+	sqlDB, err := open(ctx, conn, database)
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+
+	// Read everything from one snapshot, as pg_dump does, so that the tables
+	// are consistent with each other and their row counts can be checked
+	db, err := sqlDB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Rollback()
+
+	var version string
+	if err := db.QueryRowContext(ctx, "SHOW server_version").Scan(&version); err != nil {
+		return err
+	}
+
+	q := dialect{}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "-- Native dump of database %s\n-- Server version: %s\n-- Dumped at: %s\n\n",
+		database, version, time.Now().Format(time.RFC3339))
+	fmt.Fprintf(bw, "SET statement_timeout = 0;\nSET client_encoding = 'UTF8';\nSET standard_conforming_strings = on;\n\n")
+
+	// Schemas
+	var schemas []string
+	if opts.Schema() {
+		if schemas, err = queryStrings(ctx, db, `SELECT n.nspname FROM pg_catalog.pg_namespace n
+			WHERE `+userSchemas+` AND n.nspname <> 'public' ORDER BY 1`); err != nil {
+			return err
+		}
+	}
+	for _, schema := range schemas {
+		fmt.Fprintf(bw, "CREATE SCHEMA IF NOT EXISTS %s;\n", q.QuoteIdentifier(schema))
+	}
+	if len(schemas) > 0 {
+		fmt.Fprintln(bw)
+	}
+
+	// Sequences are created before the tables whose defaults reference them.
+	// Identity sequences are recreated by the table definition itself.
+	type sequence struct {
+		relation
+		ddl       string
+		lastValue sql.NullInt64
+		identity  bool
+	}
+	var sequences []sequence
+	rows, err := db.QueryContext(ctx, `SELECT c.oid, n.nspname, c.relname,
+			format('AS %s INCREMENT BY %s MINVALUE %s MAXVALUE %s START WITH %s%s',
+				format_type(s.seqtypid, NULL), s.seqincrement, s.seqmin, s.seqmax, s.seqstart,
+				CASE WHEN s.seqcycle THEN ' CYCLE' ELSE ' NO CYCLE' END),
+			ps.last_value,
+			EXISTS (SELECT 1 FROM pg_catalog.pg_depend d WHERE d.objid = c.oid AND d.deptype = 'i')
+		FROM pg_catalog.pg_sequence s
+		JOIN pg_catalog.pg_class c ON c.oid = s.seqrelid
+		JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+		JOIN pg_catalog.pg_sequences ps ON ps.schemaname = n.nspname AND ps.sequencename = c.relname
+		WHERE `+userSchemas+` ORDER BY 2, 3`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var s sequence
+		if err := rows.Scan(&s.oid, &s.schema, &s.name, &s.ddl, &s.lastValue, &s.identity); err != nil {
+			rows.Close()
+			return err
+		}
+		sequences = append(sequences, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if opts.Skip.Sequences {
+		sequences = nil
+	}
+	for _, s := range sequences {
+		if s.identity || !opts.Schema() {
+			continue
+		}
+		fmt.Fprintf(bw, "--\n-- Sequence %s\n--\n\nCREATE SEQUENCE IF NOT EXISTS %s %s;\n\n", s.quoted(), s.quoted(), s.ddl)
+	}
+
+	// Tables
+	tables, err := queryRelations(ctx, db, "'r', 'p'")
+	if err != nil {
+		return err
+	}
+	if !opts.Tables.IsZero() {
+		if tables, err = selectRelations(tables, opts.Tables); err != nil {
+			return err
+		}
+	}
+	ddlTables, dataTables := tables, tables
+	if !opts.Schema() {
+		ddlTables = nil
+	}
+	if !opts.Data() {
+		dataTables = nil
+	}
+	var foreignKeys, indexes, identities []string
+	for _, table := range ddlTables {
+		ddl, alwaysIdentity, err := tableDefinition(ctx, db, table)
+		if err != nil {
+			return fmt.Errorf("failed to read definition of table %s: %w", table.name, err)
+		}
+		fmt.Fprintf(bw, "--\n-- Table structure for table %s\n--\n\n", table.quoted())
+		fmt.Fprintf(bw, "DROP TABLE IF EXISTS %s CASCADE;\n%s\n\n", table.quoted(), ddl)
+		for _, column := range alwaysIdentity {
+			identities = append(identities, fmt.Sprintf("ALTER TABLE ONLY %s ALTER COLUMN %s SET GENERATED ALWAYS;", table.quoted(), q.QuoteIdentifier(column)))
+		}
+
+		fks, err := queryStrings(ctx, db, `SELECT format('ALTER TABLE ONLY %s ADD CONSTRAINT %I %s;', $1::text, conname, pg_get_constraintdef(oid))
+			FROM pg_catalog.pg_constraint WHERE conrelid = $2 AND contype = 'f' ORDER BY conname`, table.quoted(), table.oid)
+		if err != nil {
+			return err
+		}
+		foreignKeys = append(foreignKeys, fks...)
+
+		idx, err := queryStrings(ctx, db, `SELECT pg_get_indexdef(i.indexrelid) || ';'
+			FROM pg_catalog.pg_index i
+			WHERE i.indrelid = $1
+			AND NOT EXISTS (SELECT 1 FROM pg_catalog.pg_constraint c WHERE c.conindid = i.indexrelid)
+			ORDER BY 1`, table.oid)
+		if err != nil {
+			return err
+		}
+		indexes = append(indexes, idx...)
+	}
+
+	// Table data
+	for _, table := range dataTables {
+		columns, err := insertColumns(ctx, db, table)
+		if err != nil {
+			return err
+		}
+		if len(columns) == 0 {
+			// Nothing to insert into a table without stored columns
+			continue
+		}
+		fmt.Fprintf(bw, "--\n-- Dumping data for table %s\n--\n\n", table.quoted())
+		query := opts.Tables.Narrow(table.qualified(), "SELECT "+strings.Join(columns, ", ")+" FROM "+table.from())
+		written, err := drivers.WriteInserts(ctx, bw, db, q, table.quoted(), query)
+		if err != nil {
+			return fmt.Errorf("failed to dump table %s: %w", table.name, err)
+		}
+		var count int64
+		if err := db.QueryRowContext(ctx, opts.Tables.Narrow(table.qualified(), "SELECT count(*) FROM "+table.from())).Scan(&count); err != nil {
+			return fmt.Errorf("failed to count the rows of table %s: %w", table.name, err)
+		}
+		if written != count {
+			return fmt.Errorf("dumped %d of the %d rows of table %s", written, count, table.name)
+		}
+		fmt.Fprintln(bw)
+	}
+
+	// Identity columns are created as BY DEFAULT so the data above could be
+	// inserted with its original values
+	if len(identities) > 0 {
+		fmt.Fprintf(bw, "%s\n\n", strings.Join(identities, "\n"))
+	}
+
+	// Sequence values
+	for _, s := range sequences {
+		if s.lastValue.Valid && opts.Data() {
+			fmt.Fprintf(bw, "SELECT pg_catalog.setval(%s, %d, true);\n", quoteString(s.quoted()), s.lastValue.Int64)
+		}
+	}
+	if len(sequences) > 0 && opts.Data() {
+		fmt.Fprintln(bw)
+	}
+
+	// Indexes and foreign keys go last so the data loads quickly
+	if len(indexes) > 0 {
+		fmt.Fprintf(bw, "--\n-- Indexes\n--\n\n%s\n\n", strings.Join(indexes, "\n"))
+	}
+	if len(foreignKeys) > 0 {
+		fmt.Fprintf(bw, "--\n-- Foreign keys\n--\n\n%s\n\n", strings.Join(foreignKeys, "\n"))
+	}
+
+	// Views
+	var views []relation
+	if opts.Schema() && !opts.Skip.Views {
+		if views, err = queryRelations(ctx, db, "'v', 'm'"); err != nil {
+			return err
+		}
+		if views, err = orderViews(ctx, db, views); err != nil {
+			return err
+		}
+	}
+	for _, view := range views {
+		var kind, definition string
+		if err := db.QueryRowContext(ctx, "SELECT relkind::text, pg_get_viewdef($1::oid, true) FROM pg_catalog.pg_class WHERE oid = $1", view.oid).
+			Scan(&kind, &definition); err != nil {
+			return err
+		}
+		fmt.Fprintf(bw, "--\n-- View structure for view %s\n--\n\n", view.quoted())
+		if kind == "m" {
+			fmt.Fprintf(bw, "CREATE MATERIALIZED VIEW %s AS\n%s\n\n", view.quoted(), definition)
+		} else {
+			fmt.Fprintf(bw, "CREATE OR REPLACE VIEW %s AS\n%s\n\n", view.quoted(), definition)
+		}
+	}
+
+	return bw.Flush()
+}
+
+// orderViews sorts views so that every view comes after the views it reads
+// from, which it cannot be created without, keeping name order otherwise
+func orderViews(ctx context.Context, db drivers.Querier, views []relation) ([]relation, error) {
+	// A view reads from the relations its rewrite rule depends on
+	rows, err := db.QueryContext(ctx, `SELECT DISTINCT r.ev_class, d.refobjid
+		FROM pg_catalog.pg_rewrite r
+		JOIN pg_catalog.pg_depend d ON d.classid = 'pg_catalog.pg_rewrite'::regclass AND d.objid = r.oid
+		WHERE d.refclassid = 'pg_catalog.pg_class'::regclass AND d.refobjid <> r.ev_class
+		ORDER BY 1, 2`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	readsFrom := make(map[int64][]int64)
+	for rows.Next() {
+		var view, ref int64
+		if err := rows.Scan(&view, &ref); err != nil {
+			return nil, err
+		}
+		readsFrom[view] = append(readsFrom[view], ref)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	byOID := make(map[int64]relation, len(views))
+	for _, view := range views {
+		byOID[view.oid] = view
+	}
+	ordered := make([]relation, 0, len(views))
+	visited := make(map[int64]bool, len(views))
+	var visit func(view relation)
+	visit = func(view relation) {
+		if visited[view.oid] {
+			return
+		}
+		visited[view.oid] = true
+		for _, ref := range readsFrom[view.oid] {
+			if dependency, ok := byOID[ref]; ok {
+				visit(dependency)
+			}
+		}
+		ordered = append(ordered, view)
+	}
+	for _, view := range views {
+		visit(view)
+	}
+	return ordered, nil
+}
+
+// tableDefinition builds the CREATE TABLE statement for table, including its
+// primary key, unique and check constraints. It also returns the columns that
+// are GENERATED ALWAYS AS IDENTITY, which the statement declares BY DEFAULT.
+func tableDefinition(ctx context.Context, db drivers.Querier, table relation) (string, []string, error) {
+	q := dialect{}
+	rows, err := db.QueryContext(ctx, `SELECT a.attname, format_type(a.atttypid, a.atttypmod), a.attnotnull,
+			a.attidentity::text, a.attgenerated::text, COALESCE(pg_get_expr(d.adbin, d.adrelid), '')
+		FROM pg_catalog.pg_attribute a
+		LEFT JOIN pg_catalog.pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+		WHERE a.attrelid = $1 AND a.attnum > 0 AND NOT a.attisdropped
+		ORDER BY a.attnum`, table.oid)
+	if err != nil {
+		return "", nil, err
+	}
+	defer rows.Close()
+
+	var lines, alwaysIdentity []string
+	for rows.Next() {
+		var name, dataType, identity, generated, def string
+		var notNull bool
+		if err := rows.Scan(&name, &dataType, &notNull, &identity, &generated, &def); err != nil {
+			return "", nil, err
+		}
+		line := "    " + q.QuoteIdentifier(name) + " " + dataType
+		switch {
+		case identity == "a":
+			alwaysIdentity = append(alwaysIdentity, name)
+			line += " GENERATED BY DEFAULT AS IDENTITY"
+		case identity == "d":
+			line += " GENERATED BY DEFAULT AS IDENTITY"
+		case generated == "s":
+			line += " GENERATED ALWAYS AS (" + def + ") STORED"
+		case def != "":
+			line += " DEFAULT " + def
+		}
+		if notNull {
+			line += " NOT NULL"
+		}
+		lines = append(lines, line)
+	}
+	if err := rows.Err(); err != nil {
+		return "", nil, err
+	}
+
+	constraints, err := queryStrings(ctx, db, `SELECT format('    CONSTRAINT %I %s', conname, pg_get_constraintdef(oid))
+		FROM pg_catalog.pg_constraint WHERE conrelid = $1 AND contype IN ('p', 'u', 'c')
+		ORDER BY contype = 'p' DESC, conname`, table.oid)
+	if err != nil {
+		return "", nil, err
+	}
+	lines = append(lines, constraints...)
+
+	return fmt.Sprintf("CREATE TABLE %s (\n%s\n);", table.quoted(), strings.Join(lines, ",\n")), alwaysIdentity, nil
+}
+
+// insertColumns lists the quoted names of the columns of table whose values
+// are dumped. Generated columns are left out, since their values cannot be
+// inserted and are computed again on restore.
+func insertColumns(ctx context.Context, db drivers.Querier, table relation) ([]string, error) {
+	return queryStrings(ctx, db, `SELECT quote_ident(attname) FROM pg_catalog.pg_attribute
+		WHERE attrelid = $1 AND attnum > 0 AND NOT attisdropped AND attgenerated = ''
+		ORDER BY attnum`, table.oid)
+}
+
+// queryRelations lists the user relations of the given pg_class kinds
+func queryRelations(ctx context.Context, db drivers.Querier, kinds string) ([]relation, error) {
+	rows, err := db.QueryContext(ctx, `SELECT c.oid, n.nspname, c.relname, c.relkind::text
+		FROM pg_catalog.pg_class c
+		JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind IN (`+kinds+`) AND NOT c.relispartition AND `+userSchemas+`
+		ORDER BY 2, 3`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var relations []relation
+	for rows.Next() {
+		var r relation
+		if err := rows.Scan(&r.oid, &r.schema, &r.name, &r.kind); err != nil {
+			return nil, err
+		}
+		relations = append(relations, r)
+	}
+	return relations, rows.Err()
+}
+
+// partitions lists the quoted names of the partitions of the partitioned
+// table r, including those of its partitioned partitions
+func partitions(ctx context.Context, db drivers.Querier, r relation) ([]string, error) {
+	return queryStrings(ctx, db, `WITH RECURSIVE tree AS (
+			SELECT inhrelid FROM pg_catalog.pg_inherits WHERE inhparent = $1
+			UNION ALL
+			SELECT i.inhrelid FROM pg_catalog.pg_inherits i JOIN tree t ON i.inhparent = t.inhrelid
+		)
+		SELECT format('%I.%I', n.nspname, c.relname)
+		FROM tree
+		JOIN pg_catalog.pg_class c ON c.oid = tree.inhrelid
+		JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+		ORDER BY 1`, r.oid)
+}
+
+// selectRelations returns the relations of relations that filter includes,
+// matching them by their schema qualified name
+func selectRelations(relations []relation, filter drivers.TableFilter) ([]relation, error) {
+	names := make([]string, len(relations))
+	for i, r := range relations {
+		names[i] = r.qualified()
+	}
+	selected, err := filter.Select(names)
+	if err != nil {
+		return nil, err
+	}
+
+	keep := make(map[string]bool, len(selected))
+	for _, name := range selected {
+		keep[name] = true
+	}
+	var kept []relation
+	for i, r := range relations {
+		if keep[names[i]] {
+			kept = append(kept, r)
+		}
+	}
+	return kept, nil
+}
+
+// queryStrings returns the first column of every row of query
+func queryStrings(ctx context.Context, db drivers.Querier, query string, args ...any) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
@@ -0,0 +1,428 @@
+// Package postgres implements the PostgreSQL driver on top of the psql and
+// pg_dump command line clients.
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"sqlclient-export-import/internal/drivers"
+)
+
+// maxNameLength is the longest database name PostgreSQL accepts, in bytes.
+// Longer names are silently truncated by the server.
+const maxNameLength = 63
+
+var systemDatabases = []string{"postgres", "template0", "template1"}
+
+// Driver talks to PostgreSQL servers
+type Driver struct{}
+
+// New returns a PostgreSQL driver
+func New() *Driver {
+	return &Driver{}
+}
+
+// RequiresServer returns true since PostgreSQL is a client/server database
+func (d *Driver) RequiresServer() bool {
+	return true
+}
+
+// DefaultPort returns the standard PostgreSQL port
+func (d *Driver) DefaultPort() string {
+	return "5432"
+}
+
+// IsSystemDatabase reports whether name is the maintenance database or a template
+func (d *Driver) IsSystemDatabase(name string) bool {
+	for _, sysDB := range systemDatabases {
+		if strings.EqualFold(name, sysDB) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateName checks name against the PostgreSQL rules for database names:
+// at most 63 bytes, and no equals sign or URI prefix, which would turn the
+// name into a connection string when passed to the clients
+func (d *Driver) ValidateName(name string) error {
+	if err := drivers.CheckName(name); err != nil {
+		return err
+	}
+	if len(name) > maxNameLength {
+		return &drivers.InvalidNameError{Name: name, Reason: fmt.Sprintf("PostgreSQL names are limited to %d bytes", maxNameLength)}
+	}
+	if strings.Contains(name, "=") || strings.HasPrefix(name, "postgres://") || strings.HasPrefix(name, "postgresql://") {
+		return &drivers.InvalidNameError{Name: name, Reason: "the clients would read the name as a connection string"}
+	}
+	return nil
+}
+
+// Export dumps database with pg_dump. Tables left out of the selection are
+// passed to -T, partitioned ones with each of their partitions, which
+// pg_dump would otherwise still dump. pg_dump has no options to leave out
+// kinds of objects and cannot filter rows, so those exports go through a
+// custom format archive whose table of contents is filtered before
+// pg_restore writes it out as SQL. Filtered rows, read through the parent
+// for partitioned tables, are copied out by psql between the data and
+// post-data sections, before the indexes, constraints and triggers are
+// created.
+func (d *Driver) Export(ctx context.Context, conn drivers.Connection, database string, w io.Writer, opts drivers.ExportOptions) error {
+	if err := d.ValidateName(database); err != nil {
+		return err
+	}
+
+	var args []string
+	switch opts.Mode {
+	case drivers.ModeSchema:
+		args = append(args, "--schema-only")
+	case drivers.ModeData:
+		args = append(args, "--data-only")
+	}
+
+	var db *sql.DB
+	var filtered []relation
+	if !opts.Tables.IsZero() {
+		var err error
+		db, err = open(ctx, conn, database)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		relations, err := queryRelations(ctx, db, "'r', 'p'")
+		if err != nil {
+			return err
+		}
+		selected, err := selectRelations(relations, opts.Tables)
+		if err != nil {
+			return err
+		}
+
+		keep := make(map[int64]bool, len(selected))
+		for _, r := range selected {
+			keep[r.oid] = true
+		}
+		for _, r := range relations {
+			_, filter := opts.Tables.Where[r.qualified()]
+			filter = filter && opts.Data() && keep[r.oid]
+			if keep[r.oid] && !filter {
+				continue
+			}
+			names := []string{r.quoted()}
+			if r.kind == "p" {
+				children, err := partitions(ctx, db, r)
+				if err != nil {
+					return err
+				}
+				names = append(names, children...)
+			}
+			for _, name := range names {
+				if filter {
+					args = append(args, "--exclude-table-data="+name)
+				} else {
+					args = append(args, "-T", name)
+				}
+			}
+			if filter {
+				filtered = append(filtered, r)
+			}
+		}
+	}
+
+	skip := opts.Skip
+	skip.Events = false // PostgreSQL has no scheduled events
+	if len(filtered) == 0 && skip == (drivers.Objects{}) {
+		return dump(ctx, conn, w, opts, append(args, database)...)
+	}
+
+	archive, err := os.CreateTemp(opts.TempDir, "pg_dump-*.dump")
+	if err != nil {
+		return err
+	}
+	archive.Close()
+	defer os.Remove(archive.Name())
+	if err := dump(ctx, conn, io.Discard, opts, append(args, "--format=custom", "--file="+archive.Name(), database)...); err != nil {
+		return err
+	}
+
+	list, err := restoreList(ctx, archive.Name(), skip, opts.TempDir)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(list)
+
+	if len(filtered) == 0 {
+		return restore(ctx, archive.Name(), list, "", w, opts)
+	}
+	for _, section := range []string{"pre-data", "data"} {
+		if err := restore(ctx, archive.Name(), list, section, w, opts); err != nil {
+			return err
+		}
+	}
+	for _, r := range filtered {
+		if err := copyRows(ctx, conn, db, database, r, opts.Tables.Where[r.qualified()], w, opts); err != nil {
+			return fmt.Errorf("failed to dump table %s: %w", r.name, err)
+		}
+	}
+	return restore(ctx, archive.Name(), list, "post-data", w, opts)
+}
+
+// dump runs pg_dump with args, writing the dump to w
+func dump(ctx context.Context, conn drivers.Connection, w io.Writer, opts drivers.ExportOptions, args ...string) error {
+	cmd, cleanup, err := command(ctx, "pg_dump", conn, args...)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	cmd.Stdout = w
+	return drivers.RunStreaming(cmd, opts.Stderr)
+}
+
+// skippedEntries maps the object kinds of a pg_restore table of contents to
+// the option that leaves them out. Longer kinds such as "SEQUENCE SET" and
+// "MATERIALIZED VIEW DATA" match by prefix.
+var skippedEntries = []struct {
+	kind string
+	skip func(drivers.Objects) bool
+}{
+	{"FUNCTION ", func(o drivers.Objects) bool { return o.Routines }},
+	{"PROCEDURE ", func(o drivers.Objects) bool { return o.Routines }},
+	{"AGGREGATE ", func(o drivers.Objects) bool { return o.Routines }},
+	{"TRIGGER ", func(o drivers.Objects) bool { return o.Triggers }},
+	{"EVENT TRIGGER ", func(o drivers.Objects) bool { return o.Triggers }},
+	{"VIEW ", func(o drivers.Objects) bool { return o.Views }},
+	{"MATERIALIZED VIEW ", func(o drivers.Objects) bool { return o.Views }},
+	{"SEQUENCE ", func(o drivers.Objects) bool { return o.Sequences }},
+}
+
+// restoreList writes the table of contents of archive, without the entries
+// skip leaves out, to a temporary file for pg_restore --use-list and
+// returns its path
+func restoreList(ctx context.Context, archive string, skip drivers.Objects, tempDir string) (string, error) {
+	var stdout bytes.Buffer
+	cmd := restoreCommand(ctx, "--list", archive)
+	cmd.Stdout = &stdout
+	if err := drivers.Run(cmd); err != nil {
+		return "", err
+	}
+
+	list, err := os.CreateTemp(tempDir, "pg_restore-*.list")
+	if err != nil {
+		return "", err
+	}
+	defer list.Close()
+
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if skipEntry(line, skip) {
+			continue
+		}
+		if _, err := fmt.Fprintln(list, line); err != nil {
+			os.Remove(list.Name())
+			return "", err
+		}
+	}
+	return list.Name(), nil
+}
+
+// skipEntry reports whether a line of a pg_restore table of contents, such
+// as "215; 1255 16386 FUNCTION public add(integer, integer) owner", is an
+// object skip leaves out, or the comment or privileges of one
+func skipEntry(line string, skip drivers.Objects) bool {
+	if strings.HasPrefix(line, ";") {
+		return false
+	}
+	_, entry, ok := strings.Cut(line, ";")
+	fields := strings.Fields(entry)
+	if !ok || len(fields) < 3 {
+		return false
+	}
+	entry = strings.Join(fields[2:], " ") + " "
+
+	// Comments and privileges name their object after the schema, as in
+	// "COMMENT public FUNCTION add(integer, integer) owner"
+	if len(fields) > 4 && (fields[2] == "COMMENT" || fields[2] == "ACL") {
+		entry = strings.Join(fields[4:], " ") + " "
+	}
+	for _, e := range skippedEntries {
+		if strings.HasPrefix(entry, e.kind) && e.skip(skip) {
+			return true
+		}
+	}
+	return false
+}
+
+// restore writes the entries of archive named in list as SQL to w, limited
+// to section unless it is empty
+func restore(ctx context.Context, archive, list, section string, w io.Writer, opts drivers.ExportOptions) error {
+	args := []string{"--use-list=" + list}
+	if section != "" {
+		args = append(args, "--section="+section)
+	}
+	cmd := restoreCommand(ctx, append(args, archive)...)
+	cmd.Stdout = w
+	return drivers.RunStreaming(cmd, opts.Stderr)
+}
+
+// restoreCommand builds an invocation of pg_restore, which only reads
+// archives here and never connects to the server
+func restoreCommand(ctx context.Context, args ...string) *exec.Cmd {
+	drivers.LogCommand("pg_restore", args)
+	return drivers.Command(ctx, "pg_restore", args...)
+}
+
+// copyRows writes the rows of table that satisfy condition to w as a COPY
+// block, in the text format pg_dump uses. Generated columns are left out
+// since COPY FROM cannot load them.
+func copyRows(ctx context.Context, conn drivers.Connection, db *sql.DB, database string, table relation, condition string, w io.Writer, opts drivers.ExportOptions) error {
+	columns, err := insertColumns(ctx, db, table)
+	if err != nil {
+		return err
+	}
+	list := strings.Join(columns, ", ")
+
+	fmt.Fprintf(w, "\n--\n-- Filtered data for table %s\n--\n\nCOPY %s (%s) FROM stdin;\n", table.quoted(), table.quoted(), list)
+	query := fmt.Sprintf("COPY (SELECT %s FROM %s WHERE (%s)) TO STDOUT", list, table.quoted(), condition)
+	cmd, cleanup, err := command(ctx, "psql", conn, "-X", "-q", "-v", "ON_ERROR_STOP=1", "-d", database, "-c", query)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	cmd.Stdout = w
+	if err := drivers.RunStreaming(cmd, opts.Stderr); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\\.\n\n")
+	return err
+}
+
+// ToolVersion reports the version of pg_dump
+func (d *Driver) ToolVersion(ctx context.Context, opts drivers.ExportOptions) (string, error) {
+	return drivers.Version(ctx, "pg_dump")
+}
+
+// Import pipes the SQL read from r into psql
+func (d *Driver) Import(ctx context.Context, conn drivers.Connection, database string, r io.Reader, opts drivers.ImportOptions) error {
+	if err := d.ValidateName(database); err != nil {
+		return err
+	}
+	cmd, cleanup, err := command(ctx, "psql", conn, "-d", database)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	cmd.Stdin = r
+	return drivers.RunStreaming(cmd, opts.Stderr)
+}
+
+// List returns every non-template database on the server
+func (d *Driver) List(ctx context.Context, conn drivers.Connection) ([]string, error) {
+	var stdout bytes.Buffer
+	cmd, cleanup, err := command(ctx, "psql", conn,
+		"-t", // Tuples only, no headers
+		"-c", "SELECT datname FROM pg_database WHERE datistemplate = false;",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	cmd.Stdout = &stdout
+	if err := drivers.Run(cmd); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		names = append(names, line)
+	}
+	return names, nil
+}
+
+// Create creates a new database
+func (d *Driver) Create(ctx context.Context, conn drivers.Connection, name string) error {
+	if err := d.ValidateName(name); err != nil {
+		return err
+	}
+	return execute(ctx, conn, "CREATE DATABASE "+dialect{}.QuoteIdentifier(name)+";")
+}
+
+// Rename renames a database in place
+func (d *Driver) Rename(ctx context.Context, conn drivers.Connection, from, to string) error {
+	for _, name := range []string{from, to} {
+		if err := d.ValidateName(name); err != nil {
+			return err
+		}
+	}
+	q := dialect{}
+	return execute(ctx, conn, "ALTER DATABASE "+q.QuoteIdentifier(from)+" RENAME TO "+q.QuoteIdentifier(to)+";")
+}
+
+// Drop removes a database
+func (d *Driver) Drop(ctx context.Context, conn drivers.Connection, name string) error {
+	if err := d.ValidateName(name); err != nil {
+		return err
+	}
+	return execute(ctx, conn, "DROP DATABASE "+dialect{}.QuoteIdentifier(name)+";")
+}
+
+// execute runs a statement with psql
+func execute(ctx context.Context, conn drivers.Connection, statement string) error {
+	cmd, cleanup, err := command(ctx, "psql", conn, "-c", statement)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	return drivers.Run(cmd)
+}
+
+// command builds an invocation of one of the PostgreSQL clients with the
+// connection options followed by args. The password is passed in a
+// temporary password file named by PGPASSFILE rather than in the
+// environment; cleanup removes it and must be called once the command has
+// finished.
+func command(ctx context.Context, name string, conn drivers.Connection, args ...string) (cmd *exec.Cmd, cleanup func(), err error) {
+	args = append([]string{
+		"-h", conn.Host,
+		"-p", conn.Port,
+		"-U", conn.Username,
+	}, args...)
+
+	drivers.LogCommand(name, args)
+
+	cmd = drivers.Command(ctx, name, args...)
+	cleanup = func() {}
+	if conn.Password != "" {
+		var path string
+		path, cleanup, err = drivers.SecretFile("pgpass-*", "*:*:*:*:"+passFileField(conn.Password)+"\n")
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to write the password file: %w", err)
+		}
+		// A PGPASSWORD inherited from the server would take precedence
+		for _, env := range os.Environ() {
+			if !strings.HasPrefix(env, "PGPASSWORD=") {
+				cmd.Env = append(cmd.Env, env)
+			}
+		}
+		cmd.Env = append(cmd.Env, "PGPASSFILE="+path)
+	}
+	return cmd, cleanup, nil
+}
+
+// passFileReplacer escapes the field separators of a password file
+var passFileReplacer = strings.NewReplacer(`\`, `\\`, ":", `\:`)
+
+// passFileField escapes s for a field of a password file
+func passFileField(s string) string {
+	return passFileReplacer.Replace(s)
+}
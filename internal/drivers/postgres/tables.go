@@ -0,0 +1,141 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+
+	"sqlclient-export-import/internal/drivers"
+
+	"github.com/lib/pq"
+)
+
+// OpenDB connects to database
+func (d *Driver) OpenDB(ctx context.Context, conn drivers.Connection, database string) (*sql.DB, error) {
+	return open(ctx, conn, database)
+}
+
+// ServerVersion returns the version of the PostgreSQL server
+func (d *Driver) ServerVersion(ctx context.Context, db *sql.DB) (string, error) {
+	var version string
+	err := db.QueryRowContext(ctx, "SHOW server_version").Scan(&version)
+	return version, err
+}
+
+// Tables lists the tables of every user schema as schema.table. Rows of
+// partitions are read through their partitioned parent.
+func (d *Driver) Tables(ctx context.Context, db *sql.DB) ([]drivers.Table, error) {
+	relations, err := queryRelations(ctx, db, "'r', 'p'")
+	if err != nil {
+		return nil, err
+	}
+
+	tables := make([]drivers.Table, len(relations))
+	for i, r := range relations {
+		tables[i] = drivers.Table{
+			Name:   r.qualified(),
+			Select: "SELECT * FROM " + r.quoted(),
+		}
+	}
+	return tables, nil
+}
+
+// columnTypes are the declared types of columns created for CSV imports
+var columnTypes = map[drivers.ColumnType]string{
+	drivers.TypeInteger:   "bigint",
+	drivers.TypeFloat:     "double precision",
+	drivers.TypeBoolean:   "boolean",
+	drivers.TypeDate:      "date",
+	drivers.TypeTimestamp: "timestamp",
+	drivers.TypeText:      "text",
+}
+
+// Columns returns the columns of table, which may be qualified with its schema
+func (d *Driver) Columns(ctx context.Context, db *sql.DB, table string) ([]drivers.Column, error) {
+	rows, err := db.QueryContext(ctx, `SELECT a.attname, format_type(a.atttypid, a.atttypmod)
+		FROM pg_catalog.pg_attribute a
+		WHERE a.attrelid = $1::regclass AND a.attnum > 0 AND NOT a.attisdropped
+		ORDER BY a.attnum`, quoteName(table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []drivers.Column
+	for rows.Next() {
+		var name, dbType string
+		if err := rows.Scan(&name, &dbType); err != nil {
+			return nil, err
+		}
+		columns = append(columns, drivers.Column{Name: name, Type: drivers.GenericType(dbType)})
+	}
+	return columns, rows.Err()
+}
+
+// CreateTable creates table with the given columns, in the first schema of
+// the search path unless the name is qualified
+func (d *Driver) CreateTable(ctx context.Context, db *sql.DB, table string, columns []drivers.Column) error {
+	q := dialect{}
+	definitions := make([]string, len(columns))
+	for i, column := range columns {
+		definitions[i] = "    " + q.QuoteIdentifier(column.Name) + " " + columnTypes[column.Type]
+	}
+	_, err := db.ExecContext(ctx, fmt.Sprintf("CREATE TABLE %s (\n%s\n)", quoteName(table), strings.Join(definitions, ",\n")))
+	return err
+}
+
+// Load streams the rows to the server with COPY FROM STDIN in a single
+// transaction
+func (d *Driver) Load(ctx context.Context, db *sql.DB, table string, columns []string, next func() ([]any, error), reject func(row int64, reason string)) (int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	copyIn := pq.CopyIn(table, columns...)
+	if schema, name, ok := strings.Cut(table, "."); ok {
+		copyIn = pq.CopyInSchema(schema, name, columns...)
+	}
+	stmt, err := tx.PrepareContext(ctx, copyIn)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	var loaded int64
+	for {
+		row, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		if _, err := stmt.ExecContext(ctx, row...); err != nil {
+			return 0, err
+		}
+		loaded++
+	}
+
+	// An Exec without arguments flushes the buffered rows
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return 0, err
+	}
+	if err := stmt.Close(); err != nil {
+		return 0, err
+	}
+	return loaded, tx.Commit()
+}
+
+// quoteName quotes a table name, keeping the schema of a schema.table name
+// separate
+func quoteName(table string) string {
+	q := dialect{}
+	if schema, name, ok := strings.Cut(table, "."); ok {
+		return q.QuoteIdentifier(schema) + "." + q.QuoteIdentifier(name)
+	}
+	return q.QuoteIdentifier(table)
+}
@@ -0,0 +1,203 @@
+package sqlite
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"sqlclient-export-import/internal/drivers"
+
+	_ "modernc.org/sqlite"
+)
+
+// dialect renders SQLite identifiers and literals
+type dialect struct{}
+
+func (dialect) QuoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func (dialect) Literal(value any, dbType string) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case time.Time:
+		if v.Location() == time.UTC {
+			return quoteString(v.Format("2006-01-02 15:04:05.999999999"))
+		}
+		return quoteString(v.Format("2006-01-02 15:04:05.999999999-07:00"))
+	case []byte:
+		return "X'" + hex.EncodeToString(v) + "'"
+	case string:
+		return quoteString(v)
+	default:
+		return quoteString(fmt.Sprint(v))
+	}
+}
+
+func quoteString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// open opens an existing database file over database/sql in the given
+// access mode, "ro" or "rw"
+func (d *Driver) open(database, mode string) (*sql.DB, error) {
+	path, err := d.path(database)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("database file %s not found", filepath.Base(path))
+	}
+	return sql.Open("sqlite", "file:"+uriReplacer.Replace(path)+"?mode="+mode)
+}
+
+// uriReplacer escapes the characters of a file path that SQLite URIs treat
+// specially, so that a database name cannot add query parameters
+var uriReplacer = strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23")
+
+// ExportNative dumps the database file without the sqlite3 shell. The
+// output mirrors .dump: tables and their rows, then indexes, views and
+// triggers, and finally the AUTOINCREMENT counters, which count as
+// sequences.
+func (d *Driver) ExportNative(ctx context.Context, conn drivers.Connection, database string, w io.Writer, opts drivers.ExportOptions) error {
+	db, err := d.open(database, "ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, `SELECT type, name, tbl_name, sql FROM sqlite_master
+		WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%'
+		ORDER BY CASE type WHEN 'table' THEN 0 WHEN 'index' THEN 1 WHEN 'view' THEN 2 ELSE 3 END, rowid`)
+	if err != nil {
+		return err
+	}
+	type object struct {
+		kind, name, table, ddl string
+	}
+	var objects []object
+	for rows.Next() {
+		var o object
+		if err := rows.Scan(&o.kind, &o.name, &o.table, &o.ddl); err != nil {
+			rows.Close()
+			return err
+		}
+		objects = append(objects, o)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Leave out the tables the filter does not select, along with their
+	// indexes and triggers
+	if !opts.Tables.IsZero() {
+		var tables []string
+		for _, o := range objects {
+			if o.kind == "table" {
+				tables = append(tables, o.name)
+			}
+		}
+		selected, err := opts.Tables.Select(tables)
+		if err != nil {
+			return err
+		}
+		excluded := make(map[string]bool, len(tables))
+		for _, name := range tables {
+			excluded[name] = true
+		}
+		for _, name := range selected {
+			delete(excluded, name)
+		}
+		kept := objects[:0]
+		for _, o := range objects {
+			if !excluded[o.table] {
+				kept = append(kept, o)
+			}
+		}
+		objects = kept
+	}
+
+	q := dialect{}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "-- Native dump of database %s\n-- Dumped at: %s\n\n", database, time.Now().Format(time.RFC3339))
+	fmt.Fprintf(bw, "PRAGMA foreign_keys=OFF;\nBEGIN TRANSACTION;\n")
+
+	hasSequences := false
+	for _, o := range objects {
+		if (o.kind == "view" && opts.Skip.Views) || (o.kind == "trigger" && opts.Skip.Triggers) {
+			continue
+		}
+		if opts.Schema() {
+			fmt.Fprintf(bw, "%s;\n", o.ddl)
+		}
+		if o.kind != "table" || !opts.Data() {
+			continue
+		}
+		if strings.Contains(strings.ToUpper(o.ddl), "AUTOINCREMENT") {
+			hasSequences = true
+		}
+		// Virtual tables keep their rows in shadow tables
+		if strings.HasPrefix(strings.ToUpper(o.ddl), "CREATE VIRTUAL TABLE") {
+			continue
+		}
+		columns, err := insertColumns(ctx, db, o.name)
+		if err != nil {
+			return err
+		}
+		query := opts.Tables.Narrow(o.name, "SELECT "+strings.Join(columns, ", ")+" FROM "+q.QuoteIdentifier(o.name))
+		if _, err := drivers.WriteInserts(ctx, bw, db, q, q.QuoteIdentifier(o.name), query); err != nil {
+			return fmt.Errorf("failed to dump table %s: %w", o.name, err)
+		}
+	}
+
+	if hasSequences && !opts.Skip.Sequences {
+		fmt.Fprintf(bw, "DELETE FROM sqlite_sequence;\n")
+		if _, err := drivers.WriteInserts(ctx, bw, db, q, "sqlite_sequence", "SELECT name, seq FROM sqlite_sequence"); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(bw, "COMMIT;\n")
+	return bw.Flush()
+}
+
+// insertColumns lists the quoted names of the columns of table whose values
+// are dumped. Generated columns, hidden 2 (virtual) and 3 (stored) in
+// table_xinfo, are left out since SQLite refuses values for them.
+func insertColumns(ctx context.Context, db *sql.DB, table string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name FROM pragma_table_xinfo(?) WHERE hidden NOT IN (2, 3) ORDER BY cid", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	q := dialect{}
+	var columns []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, err
+		}
+		columns = append(columns, q.QuoteIdentifier(column))
+	}
+	return columns, rows.Err()
+}
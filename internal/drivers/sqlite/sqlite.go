@@ -0,0 +1,253 @@
+// Package sqlite implements the SQLite driver. Databases are files kept in a
+// single data directory and dumps go through the sqlite3 command line shell.
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"sqlclient-export-import/internal/drivers"
+)
+
+// extensions lists the file extensions recognised as SQLite databases. The
+// first one is appended to names created without an extension.
+var extensions = []string{".db", ".sqlite", ".sqlite3"}
+
+// sidecars are the companion files SQLite keeps next to a database
+var sidecars = []string{"-wal", "-shm", "-journal"}
+
+// Driver manages SQLite database files under a data directory
+type Driver struct {
+	dir string
+}
+
+// New returns a SQLite driver rooted at dir
+func New(dir string) *Driver {
+	return &Driver{dir: dir}
+}
+
+// RequiresServer returns false since SQLite databases are local files
+func (d *Driver) RequiresServer() bool {
+	return false
+}
+
+// DefaultPort returns an empty port since SQLite does not listen on one
+func (d *Driver) DefaultPort() string {
+	return ""
+}
+
+// IsSystemDatabase always returns false since SQLite has no system databases
+func (d *Driver) IsSystemDatabase(name string) bool {
+	return false
+}
+
+// maxNameLength is the longest file name most file systems accept, in bytes
+const maxNameLength = 255
+
+// ValidateName checks that name is a plain file name, with room for the
+// extension and sidecar suffixes, so that it resolves inside the data
+// directory
+func (d *Driver) ValidateName(name string) error {
+	if err := drivers.CheckName(name); err != nil {
+		return err
+	}
+	if name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return &drivers.InvalidNameError{Name: name, Reason: "SQLite names cannot contain path separators"}
+	}
+	if len(name)+len(extensions[0])+len("-journal") > maxNameLength {
+		return &drivers.InvalidNameError{Name: name, Reason: fmt.Sprintf("SQLite file names are limited to %d bytes", maxNameLength)}
+	}
+	return nil
+}
+
+// Export dumps the database file with the sqlite3 .dump command. The shell
+// only selects tables by LIKE pattern, where "_" matches any character, and
+// cannot leave out kinds of objects, so narrower dumps are written by the
+// native exporter, whose output mirrors .dump.
+func (d *Driver) Export(ctx context.Context, conn drivers.Connection, database string, w io.Writer, opts drivers.ExportOptions) error {
+	if exportsNatively(opts) {
+		return d.ExportNative(ctx, conn, database, w, opts)
+	}
+
+	path, err := d.path(database)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("database file %s not found", filepath.Base(path))
+	}
+
+	cmd := command(ctx, path, ".dump")
+	cmd.Stdout = w
+	return drivers.RunStreaming(cmd, opts.Stderr)
+}
+
+// exportsNatively reports whether Export hands opts to the native exporter
+func exportsNatively(opts drivers.ExportOptions) bool {
+	return !opts.Tables.IsZero() || !opts.Schema() || !opts.Data() || opts.Skip != (drivers.Objects{})
+}
+
+// ToolVersion reports the version of the sqlite3 shell, which prints it
+// followed by the build date and source hash
+func (d *Driver) ToolVersion(ctx context.Context, opts drivers.ExportOptions) (string, error) {
+	if exportsNatively(opts) {
+		return "native", nil
+	}
+	version, err := drivers.Version(ctx, "sqlite3")
+	if err != nil {
+		return "", err
+	}
+	if fields := strings.Fields(version); len(fields) > 0 {
+		version = fields[0]
+	}
+	return "sqlite3 " + version, nil
+}
+
+// Import runs the SQL read from r against the database file, creating the
+// file if it does not exist yet
+func (d *Driver) Import(ctx context.Context, conn drivers.Connection, database string, r io.Reader, opts drivers.ImportOptions) error {
+	path, err := d.path(database)
+	if err != nil {
+		return err
+	}
+
+	_, statErr := os.Stat(path)
+	created := errors.Is(statErr, os.ErrNotExist)
+
+	// Safe mode refuses the dot-commands, functions and ATTACH that would
+	// let an uploaded file run programs or touch other files as the server.
+	// It also turns on defensive mode, which the schema rows that .dump
+	// writes for virtual tables need off; that only affects this database.
+	cmd := command(ctx, "-safe", "-bail", "-cmd", ".dbconfig defensive off", path)
+	cmd.Stdin = r
+	err = drivers.RunStreaming(cmd, opts.Stderr)
+	if err != nil && created {
+		// Do not leave a half-imported database behind when the import
+		// created the file
+		for _, suffix := range append([]string{""}, sidecars...) {
+			os.Remove(path + suffix)
+		}
+	}
+	return err
+}
+
+// List returns the database files found in the data directory
+func (d *Driver) List(ctx context.Context, conn drivers.Connection) ([]string, error) {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() || !hasDatabaseExtension(entry.Name()) {
+			continue
+		}
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Create creates a new, empty database file. SQLite treats a zero-length
+// file as a valid empty database.
+func (d *Driver) Create(ctx context.Context, conn drivers.Connection, name string) error {
+	path, err := d.path(name)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("database file %s already exists", filepath.Base(path))
+		}
+		return err
+	}
+	return f.Close()
+}
+
+// Rename moves the database file, along with its sidecar files, to a new name
+func (d *Driver) Rename(ctx context.Context, conn drivers.Connection, from, to string) error {
+	fromPath, err := d.path(from)
+	if err != nil {
+		return err
+	}
+	toPath, err := d.path(to)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(fromPath); err != nil {
+		return fmt.Errorf("database file %s not found", filepath.Base(fromPath))
+	}
+	if _, err := os.Stat(toPath); err == nil {
+		return fmt.Errorf("database file %s already exists", filepath.Base(toPath))
+	}
+
+	if err := os.Rename(fromPath, toPath); err != nil {
+		return err
+	}
+	for _, suffix := range sidecars {
+		if err := os.Rename(fromPath+suffix, toPath+suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Drop deletes the database file and its sidecar files
+func (d *Driver) Drop(ctx context.Context, conn drivers.Connection, name string) error {
+	path, err := d.path(name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("database file %s not found", filepath.Base(path))
+		}
+		return err
+	}
+	for _, suffix := range sidecars {
+		if err := os.Remove(path + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// path resolves a database name to a file inside the data directory. Names
+// without a recognised extension get the default one appended.
+func (d *Driver) path(name string) (string, error) {
+	if err := d.ValidateName(name); err != nil {
+		return "", err
+	}
+	if !hasDatabaseExtension(name) {
+		name += extensions[0]
+	}
+	return filepath.Join(d.dir, name), nil
+}
+
+func hasDatabaseExtension(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range extensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// command builds an invocation of the sqlite3 shell
+func command(ctx context.Context, args ...string) *exec.Cmd {
+	drivers.LogCommand("sqlite3", args)
+	return drivers.Command(ctx, "sqlite3", args...)
+}
@@ -0,0 +1,133 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+
+	"sqlclient-export-import/internal/drivers"
+)
+
+// columnTypes are the declared types of columns created for CSV imports
+var columnTypes = map[drivers.ColumnType]string{
+	drivers.TypeInteger:   "INTEGER",
+	drivers.TypeFloat:     "REAL",
+	drivers.TypeBoolean:   "BOOLEAN",
+	drivers.TypeDate:      "DATE",
+	drivers.TypeTimestamp: "DATETIME",
+	drivers.TypeText:      "TEXT",
+}
+
+// OpenDB opens the database file for reading and writing
+func (d *Driver) OpenDB(ctx context.Context, conn drivers.Connection, database string) (*sql.DB, error) {
+	return d.open(database, "rw")
+}
+
+// ServerVersion returns the version of the SQLite library reading the
+// database file
+func (d *Driver) ServerVersion(ctx context.Context, db *sql.DB) (string, error) {
+	var version string
+	err := db.QueryRowContext(ctx, "SELECT sqlite_version()").Scan(&version)
+	return version, err
+}
+
+// Tables lists the tables of the database file, leaving out SQLite's own
+func (d *Driver) Tables(ctx context.Context, db *sql.DB) ([]drivers.Table, error) {
+	rows, err := db.QueryContext(ctx, `SELECT name FROM sqlite_master
+		WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	q := dialect{}
+	var tables []drivers.Table
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tables = append(tables, drivers.Table{
+			Name:   name,
+			Select: "SELECT * FROM " + q.QuoteIdentifier(name),
+		})
+	}
+	return tables, rows.Err()
+}
+
+// Columns returns the columns of table
+func (d *Driver) Columns(ctx context.Context, db *sql.DB, table string) ([]drivers.Column, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name, type FROM pragma_table_info(?) ORDER BY cid", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []drivers.Column
+	for rows.Next() {
+		var name, dbType string
+		if err := rows.Scan(&name, &dbType); err != nil {
+			return nil, err
+		}
+		columns = append(columns, drivers.Column{Name: name, Type: drivers.GenericType(dbType)})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("table %s not found", table)
+	}
+	return columns, nil
+}
+
+// CreateTable creates table with the given columns
+func (d *Driver) CreateTable(ctx context.Context, db *sql.DB, table string, columns []drivers.Column) error {
+	q := dialect{}
+	definitions := make([]string, len(columns))
+	for i, column := range columns {
+		definitions[i] = "    " + q.QuoteIdentifier(column.Name) + " " + columnTypes[column.Type]
+	}
+	_, err := db.ExecContext(ctx, fmt.Sprintf("CREATE TABLE %s (\n%s\n)", q.QuoteIdentifier(table), strings.Join(definitions, ",\n")))
+	return err
+}
+
+// Load inserts the rows in a single transaction with a prepared INSERT
+func (d *Driver) Load(ctx context.Context, db *sql.DB, table string, columns []string, next func() ([]any, error), reject func(row int64, reason string)) (int64, error) {
+	q := dialect{}
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = q.QuoteIdentifier(column)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		q.QuoteIdentifier(table), strings.Join(quoted, ", "), placeholders))
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	var loaded int64
+	for {
+		row, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		if _, err := stmt.ExecContext(ctx, row...); err != nil {
+			return 0, err
+		}
+		loaded++
+	}
+	return loaded, tx.Commit()
+}
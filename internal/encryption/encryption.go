@@ -0,0 +1,203 @@
+// Package encryption encrypts export streams with age
+// (https://age-encryption.org), to the public keys of recipients or with a
+// passphrase, and detects and decrypts encrypted import streams. Encrypted
+// exports can also be decrypted with the age command line tool.
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+	"filippo.io/age/armor"
+)
+
+// Extension is appended to the names of encrypted exports
+const Extension = ".age"
+
+var (
+	ageMagic   = []byte("age-encryption.org/")
+	armorMagic = []byte(armor.Header)
+)
+
+// ErrKeyRequired is returned for encrypted imports without a key
+var ErrKeyRequired = errors.New("the file is encrypted; enter its passphrase or an identity to decrypt it")
+
+// Options choose how an export is encrypted: to the recipients or with the
+// passphrase, which cannot be combined. Without either the export stays in
+// plaintext.
+type Options struct {
+	Recipients []string // age (age1...) or SSH public keys
+	Passphrase string
+}
+
+// Enabled reports whether the options encrypt
+func (o Options) Enabled() bool {
+	return len(o.Recipients) > 0 || o.Passphrase != ""
+}
+
+// Validate returns an error if the recipients cannot be parsed or are
+// combined with a passphrase
+func (o Options) Validate() error {
+	_, err := o.recipients()
+	return err
+}
+
+// Describe summarises the options for the export catalog, without the
+// passphrase
+func (o Options) Describe() string {
+	switch {
+	case o.Passphrase != "":
+		return "age, passphrase"
+	case len(o.Recipients) == 1:
+		return "age, 1 recipient"
+	case len(o.Recipients) > 1:
+		return fmt.Sprintf("age, %d recipients", len(o.Recipients))
+	default:
+		return ""
+	}
+}
+
+func (o Options) recipients() ([]age.Recipient, error) {
+	if o.Passphrase != "" {
+		if len(o.Recipients) > 0 {
+			return nil, errors.New("encrypt to recipients or with a passphrase, not both")
+		}
+		recipient, err := age.NewScryptRecipient(o.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+		return []age.Recipient{recipient}, nil
+	}
+
+	recipients := make([]age.Recipient, 0, len(o.Recipients))
+	for _, key := range o.Recipients {
+		var recipient age.Recipient
+		var err error
+		if strings.HasPrefix(key, "ssh-") {
+			recipient, err = agessh.ParseRecipient(key)
+		} else {
+			recipient, err = age.ParseX25519Recipient(key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient %q: use an age public key (age1...) or an SSH public key", key)
+		}
+		recipients = append(recipients, recipient)
+	}
+	return recipients, nil
+}
+
+// SplitRecipients splits a comma or newline separated list of public keys,
+// skipping blank lines and # comments as age recipient files do
+func SplitRecipients(list string) []string {
+	var keys []string
+	for _, line := range strings.Split(list, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		// SSH keys contain spaces but no commas
+		for _, key := range strings.Split(line, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+// NewWriter returns a writer that encrypts into w as o asks. Closing it
+// finishes the encryption but does not close w.
+func NewWriter(w io.Writer, o Options) (io.WriteCloser, error) {
+	if !o.Enabled() {
+		return nopWriteCloser{w}, nil
+	}
+	recipients, err := o.recipients()
+	if err != nil {
+		return nil, err
+	}
+	return age.Encrypt(w, recipients...)
+}
+
+// Keys decrypt an encrypted import
+type Keys struct {
+	Identities string // age identities (AGE-SECRET-KEY-1...) or an unencrypted SSH private key
+	Passphrase string
+}
+
+// Validate returns an error if the identities cannot be parsed. The
+// message never quotes them.
+func (k Keys) Validate() error {
+	_, err := k.identities()
+	return err
+}
+
+func (k Keys) identities() ([]age.Identity, error) {
+	var identities []age.Identity
+	if strings.TrimSpace(k.Identities) != "" {
+		if strings.Contains(k.Identities, "-----BEGIN") {
+			identity, err := agessh.ParseIdentity([]byte(k.Identities))
+			if err != nil {
+				return nil, errors.New("the identity is not an unencrypted SSH private key")
+			}
+			identities = append(identities, identity)
+		} else {
+			parsed, err := age.ParseIdentities(strings.NewReader(k.Identities))
+			if err != nil {
+				return nil, errors.New("the identity is not an age secret key (AGE-SECRET-KEY-1...)")
+			}
+			identities = append(identities, parsed...)
+		}
+	}
+	if k.Passphrase != "" {
+		identity, err := age.NewScryptIdentity(k.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, nil
+}
+
+// NewReader returns a reader that transparently decrypts r with keys when
+// it is an age file, binary or armored, and passes it through otherwise
+func NewReader(r io.Reader, keys Keys) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(len(armorMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if !Detect(header) {
+		return br, nil
+	}
+
+	identities, err := keys.identities()
+	if err != nil {
+		return nil, err
+	}
+	if len(identities) == 0 {
+		return nil, ErrKeyRequired
+	}
+	var src io.Reader = br
+	if bytes.HasPrefix(header, armorMagic) {
+		src = armor.NewReader(br)
+	}
+	return age.Decrypt(src, identities...)
+}
+
+// Detect reports whether a stream starting with header is encrypted
+func Detect(header []byte) bool {
+	return bytes.HasPrefix(header, ageMagic) || bytes.HasPrefix(header, armorMagic)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
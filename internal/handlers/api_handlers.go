@@ -0,0 +1,320 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"sqlclient-export-import/internal/auth"
+	"sqlclient-export-import/internal/catalog"
+	"sqlclient-export-import/internal/jobs"
+	"sqlclient-export-import/internal/models"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// apiPrefix is the path of the current version of the JSON API
+const apiPrefix = "/api/v1"
+
+// apiError is the error object of failed API requests, sent as
+// {"error": {"code": ..., "message": ...}}
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// errorCodes are the codes of the API error objects by HTTP status
+var errorCodes = map[int]string{
+	fiber.StatusBadRequest:            "invalid_request",
+	fiber.StatusUnauthorized:          "unauthorized",
+	fiber.StatusForbidden:             "forbidden",
+	fiber.StatusNotFound:              "not_found",
+	fiber.StatusMethodNotAllowed:      "method_not_allowed",
+	fiber.StatusConflict:              "conflict",
+	fiber.StatusRequestEntityTooLarge: "too_large",
+	fiber.StatusUnsupportedMediaType:  "unsupported_media_type",
+	fiber.StatusInternalServerError:   "internal_error",
+	fiber.StatusBadGateway:            "operation_failed",
+}
+
+// APIErrors is the middleware of the API routes. It reports every error
+// they return as a JSON error object with the matching status.
+func APIErrors(c *fiber.Ctx) error {
+	err := c.Next()
+	if err == nil {
+		return nil
+	}
+
+	status := fiber.StatusInternalServerError
+	body := apiError{Message: err.Error()}
+	var fiberErr *fiber.Error
+	switch {
+	case errors.As(err, &fiberErr):
+		status = fiberErr.Code
+		body.Message = fiberErr.Message
+	case errors.Is(err, catalog.ErrNotFound):
+		status = fiber.StatusNotFound
+		body.Message = "Export not found"
+	}
+	if status >= fiber.StatusInternalServerError {
+		log.Printf("API error: %s %s: %v", c.Method(), c.Path(), err)
+	}
+
+	body.Code = errorCodes[status]
+	if body.Code == "" {
+		body.Code = "error"
+	}
+	return c.Status(status).JSON(fiber.Map{"error": body})
+}
+
+// RequireToken returns a middleware that lets an API request through only
+// with the bearer token of a user whose role allows role
+func RequireToken(role auth.Role) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		secret, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+		if !ok || strings.TrimSpace(secret) == "" {
+			c.Set(fiber.HeaderWWWAuthenticate, `Bearer realm="api"`)
+			return fiber.NewError(fiber.StatusUnauthorized, "An API token is required in the Authorization header")
+		}
+		user, err := userStore.TokenUser(c.UserContext(), strings.TrimSpace(secret))
+		if errors.Is(err, auth.ErrNotFound) {
+			c.Set(fiber.HeaderWWWAuthenticate, `Bearer realm="api", error="invalid_token"`)
+			return fiber.NewError(fiber.StatusUnauthorized, "Invalid API token")
+		}
+		if err != nil {
+			return err
+		}
+		c.Locals(currentUserKey, user)
+
+		if !user.Role.Allows(role) {
+			return fiber.NewError(fiber.StatusForbidden, "The "+string(user.Role)+" role does not allow this; it needs "+string(role))
+		}
+		return c.Next()
+	}
+}
+
+// APIStartExportHandler starts an export job from a JSON export form
+func APIStartExportHandler(c *fiber.Ctx) error {
+	var exportForm models.ExportForm
+	if err := parseJSON(c, &exportForm); err != nil {
+		return err
+	}
+
+	job, err := startExport(exportForm, requestActor(c))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	return apiJobAccepted(c, job)
+}
+
+// APIExportsHandler lists the export catalog, newest first, optionally
+// filtered by database type, database and status
+func APIExportsHandler(c *fiber.Ctx) error {
+	entries, err := exportCatalog.List(c.UserContext(), catalog.Filter{
+		Type:     c.Query("type"),
+		Database: c.Query("database"),
+		Status:   c.Query("status"),
+		Limit:    c.QueryInt("limit", 200),
+	})
+	if err != nil {
+		return err
+	}
+	if entries == nil {
+		entries = []catalog.Entry{}
+	}
+	return c.JSON(entries)
+}
+
+// APIExportHandler returns a catalogued export
+func APIExportHandler(c *fiber.Ctx) error {
+	entry, err := catalogEntry(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(entry)
+}
+
+// APIDownloadExportHandler sends the file of a catalogued export
+func APIDownloadExportHandler(c *fiber.Ctx) error {
+	return sendExport(c, requestActor(c))
+}
+
+// APIVerifyExportHandler re-hashes the file of a catalogued export and
+// compares it with its manifest
+func APIVerifyExportHandler(c *fiber.Ctx) error {
+	entry, err := catalogEntry(c)
+	if err != nil {
+		return err
+	}
+	verification, err := verifyExport(entry)
+	if err != nil {
+		return err
+	}
+	return c.JSON(verification)
+}
+
+// APIRestoreExportHandler starts a job that imports a catalogued SQL export
+// straight from its storage into the target of a JSON import form
+func APIRestoreExportHandler(c *fiber.Ctx) error {
+	entry, err := catalogEntry(c)
+	if err != nil {
+		return err
+	}
+	var importForm models.ImportForm
+	if err := parseJSON(c, &importForm); err != nil {
+		return err
+	}
+	importForm.Export = entry.ID
+
+	job, err := startRestore(c.UserContext(), importForm, requestActor(c))
+	if err != nil {
+		return failedOperation(err, "Failed to restore export: ")
+	}
+	return apiJobAccepted(c, job)
+}
+
+// APIImportHandler saves an uploaded SQL file and starts a job that imports
+// it. The request is a multipart form with the file in sqlFile and the
+// fields of the import form.
+func APIImportHandler(c *fiber.Ctx) error {
+	if !strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEMultipartForm) {
+		return fiber.NewError(fiber.StatusUnsupportedMediaType, "Imports are uploaded as multipart/form-data")
+	}
+	file, err := c.FormFile("sqlFile")
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Please upload a SQL file in the sqlFile field")
+	}
+	var importForm models.ImportForm
+	if err := c.BodyParser(&importForm); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid form data: "+err.Error())
+	}
+
+	driver, err := planImport(c.UserContext(), &importForm)
+	if err != nil {
+		return err
+	}
+	filename, err := saveUpload(c, file)
+	if err != nil {
+		return err
+	}
+	return apiJobAccepted(c, submitImport(driver, importForm, filename, file.Filename, requestActor(c)))
+}
+
+// APIListDatabasesHandler lists the user databases on the server of a JSON
+// connection
+func APIListDatabasesHandler(c *fiber.Ctx) error {
+	var conn models.ConnectionForm
+	if err := parseJSON(c, &conn); err != nil {
+		return err
+	}
+
+	databases, err := listServerDatabases(c.UserContext(), &conn, requestActor(c))
+	if err != nil {
+		return failedOperation(err, "Failed to list databases: ")
+	}
+	names := make([]string, len(databases))
+	for i, database := range databases {
+		names[i] = database.Name
+	}
+	return c.JSON(fiber.Map{"databases": names})
+}
+
+// APICreateDatabaseHandler creates the database named in the JSON request
+func APICreateDatabaseHandler(c *fiber.Ctx) error {
+	var dbOp models.DatabaseOperation
+	if err := parseJSON(c, &dbOp); err != nil {
+		return err
+	}
+	// The new database is named by the database field here
+	if dbOp.NewDatabase == "" {
+		dbOp.NewDatabase = dbOp.Database
+	}
+
+	if err := createDatabase(c.UserContext(), &dbOp, requestActor(c)); err != nil {
+		return failedOperation(err, "Failed to create database: ")
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"database": dbOp.NewDatabase})
+}
+
+// APIRenameDatabaseHandler starts a job that renames the database named in
+// the JSON request to its newDatabase
+func APIRenameDatabaseHandler(c *fiber.Ctx) error {
+	var dbOp models.DatabaseOperation
+	if err := parseJSON(c, &dbOp); err != nil {
+		return err
+	}
+
+	job, err := startRename(c.UserContext(), &dbOp, requestActor(c))
+	if err != nil {
+		return err
+	}
+	return apiJobAccepted(c, job)
+}
+
+// APIDropDatabaseHandler drops the database named in the JSON request
+func APIDropDatabaseHandler(c *fiber.Ctx) error {
+	var dbOp models.DatabaseOperation
+	if err := parseJSON(c, &dbOp); err != nil {
+		return err
+	}
+
+	if err := dropDatabase(c.UserContext(), &dbOp, requestActor(c)); err != nil {
+		return failedOperation(err, "Failed to drop database: ")
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// APIJobHandler reports the status of a job
+func APIJobHandler(c *fiber.Ctx) error {
+	job, ok := jobManager.Get(c.Params("id"))
+	if !ok {
+		return fiber.NewError(fiber.StatusNotFound, "Job not found")
+	}
+	return c.JSON(job.Snapshot())
+}
+
+// APICancelJobHandler stops a queued or running job
+func APICancelJobHandler(c *fiber.Ctx) error {
+	job, ok := jobManager.Get(c.Params("id"))
+	if !ok {
+		return fiber.NewError(fiber.StatusNotFound, "Job not found")
+	}
+	if !job.Cancel() {
+		return fiber.NewError(fiber.StatusConflict, "Job has already finished")
+	}
+	log.Printf("Cancelling job %s: %s", job.ID, job.Description)
+	return c.Status(fiber.StatusAccepted).JSON(job.Snapshot())
+}
+
+// apiJobAccepted responds to an API request that started a job with the
+// job and its location
+func apiJobAccepted(c *fiber.Ctx, job *jobs.Job) error {
+	c.Location(apiPrefix + "/jobs/" + job.ID)
+	return c.Status(fiber.StatusAccepted).JSON(job.Snapshot())
+}
+
+// parseJSON decodes the JSON body of an API request into v, rejecting
+// unknown fields so that misspelt options are not silently ignored
+func parseJSON(c *fiber.Ctx, v any) error {
+	if !c.Is("json") {
+		return fiber.NewError(fiber.StatusUnsupportedMediaType, "Request bodies must be application/json")
+	}
+	decoder := json.NewDecoder(bytes.NewReader(c.Body()))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid JSON body: "+err.Error())
+	}
+	return nil
+}
+
+// failedOperation reports err from a shared operation. Mistakes in the
+// request are returned as they are; failures of the operation are the
+// fault of the database server, so they are reported as a bad gateway.
+func failedOperation(err error, prefix string) error {
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return err
+	}
+	return fiber.NewError(fiber.StatusBadGateway, prefix+describeError(err))
+}
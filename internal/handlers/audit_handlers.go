@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/url"
+	"sqlclient-export-import/internal/audit"
+	"sqlclient-export-import/internal/models"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// auditDateFormat is the format of the from and to filters of the audit page
+const auditDateFormat = "2006-01-02"
+
+// AuditPageHandler lists the audit log, newest first, filtered by the
+// query parameters. API clients get JSON; format=csv downloads the listing
+// as a CSV file.
+func AuditPageHandler(c *fiber.Ctx) error {
+	filter := audit.Filter{
+		Query:    c.Query("q"),
+		Actor:    c.Query("actor"),
+		Action:   c.Query("action"),
+		Database: c.Query("database"),
+		Outcome:  c.Query("outcome"),
+		Limit:    c.QueryInt("limit", 200),
+	}
+	var err error
+	if from := c.Query("from"); from != "" {
+		filter.Since, err = time.ParseInLocation(auditDateFormat, from, time.Local)
+	}
+	if to := c.Query("to"); to != "" && err == nil {
+		filter.Until, err = time.ParseInLocation(auditDateFormat, to, time.Local)
+		// The to date is inclusive
+		filter.Until = filter.Until.AddDate(0, 0, 1)
+	}
+	if err != nil {
+		return auditError(c, fiber.NewError(fiber.StatusBadRequest, "Dates need the YYYY-MM-DD format"))
+	}
+
+	entries, err := auditLog.List(c.UserContext(), filter)
+	if err != nil {
+		return auditError(c, err)
+	}
+	if c.Query("format") == "csv" {
+		return sendAuditCSV(c, entries)
+	}
+	if wantsJSON(c) {
+		if entries == nil {
+			entries = []audit.Entry{}
+		}
+		return c.JSON(entries)
+	}
+
+	return c.Render("audit", fiber.Map{
+		"Title":    "Audit Log",
+		"Entries":  entries,
+		"Filter":   filter,
+		"From":     c.Query("from"),
+		"To":       c.Query("to"),
+		"JSONLink": auditLink(c, "json"),
+		"CSVLink":  auditLink(c, "csv"),
+		"Actions":  audit.Actions,
+		"Outcomes": audit.Outcomes,
+	})
+}
+
+// auditLink links to the audit listing of the request in format
+func auditLink(c *fiber.Ctx, format string) string {
+	query, _ := url.ParseQuery(string(c.Request().URI().QueryString()))
+	query.Set("format", format)
+	return "/audit?" + query.Encode()
+}
+
+// sendAuditCSV sends entries as a CSV attachment, with the parameters of
+// every entry as a JSON object
+func sendAuditCSV(c *fiber.Ctx, entries []audit.Entry) error {
+	c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	c.Attachment("audit_" + time.Now().Format("20060102_150405") + ".csv")
+
+	w := csv.NewWriter(c)
+	w.Write([]string{"id", "time", "actor", "client_ip", "action", "type", "host", "port", "database", "params", "outcome", "error", "duration_ms"})
+	for _, e := range entries {
+		params, err := json.Marshal(e.Params)
+		if err != nil {
+			return err
+		}
+		w.Write([]string{
+			strconv.FormatInt(e.ID, 10),
+			e.Time.Format(time.RFC3339),
+			e.Name,
+			e.IP,
+			e.Action,
+			e.Type,
+			e.Host,
+			e.Port,
+			e.Database,
+			string(params),
+			e.Outcome,
+			e.Error,
+			strconv.FormatInt(e.Duration.Milliseconds(), 10),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// auditError reports a failed audit log request as JSON to API clients and
+// through the error page to browsers
+func auditError(c *fiber.Ctx, err error) error {
+	var fiberErr *fiber.Error
+	if !errors.As(err, &fiberErr) {
+		fiberErr = fiber.NewError(fiber.StatusInternalServerError, "Failed to read the audit log: "+err.Error())
+	}
+
+	if wantsJSON(c) {
+		return c.Status(fiberErr.Code).JSON(fiber.Map{"error": fiberErr.Message})
+	}
+	return fiberErr
+}
+
+// requestActor identifies the logged in user and the client of a request
+// for the audit log
+func requestActor(c *fiber.Ctx) audit.Actor {
+	actor := audit.Actor{IP: c.IP()}
+	if user := currentUser(c); user != nil {
+		actor.Name = user.Username
+	}
+	return actor
+}
+
+// auditEntry starts an audit log entry for an operation on database over
+// conn
+func auditEntry(actor audit.Actor, action string, conn models.ConnectionForm, database string) audit.Entry {
+	entry := audit.Entry{
+		Actor:    actor,
+		Action:   action,
+		Type:     conn.Type,
+		Host:     conn.Host,
+		Port:     conn.Port,
+		Database: database,
+		Params:   map[string]string{},
+	}
+	if conn.Profile != 0 {
+		entry.Params["profile"] = strconv.FormatInt(conn.Profile, 10)
+	}
+	if conn.Username != "" {
+		entry.Params["username"] = conn.Username
+	}
+	return entry
+}
+
+// recordAudit completes entry with the time taken since started and the
+// outcome of err, and appends it to the audit log. The operation's context
+// may be cancelled already, so the entry is recorded without it; failures
+// to record are logged.
+func recordAudit(entry audit.Entry, started time.Time, err error) {
+	entry.Time = started
+	entry.Duration = time.Since(started)
+	switch {
+	case err == nil:
+		entry.Outcome = audit.OutcomeSucceeded
+	case errors.Is(err, context.Canceled):
+		entry.Outcome = audit.OutcomeCancelled
+	default:
+		entry.Outcome = audit.OutcomeFailed
+		entry.Error = err.Error()
+	}
+
+	if recordErr := auditLog.Record(context.Background(), &entry); recordErr != nil {
+		log.Printf("Failed to record %s of %s in the audit log: %v", entry.Action, entry.Database, recordErr)
+	}
+}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/url"
+	"sqlclient-export-import/internal/auth"
+	"sqlclient-export-import/internal/config"
+	"sqlclient-export-import/internal/models"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// sessionCookie names the cookie holding the session token
+const sessionCookie = "session"
+
+// currentUserKey is the key of the logged in user in the request locals.
+// Locals are passed to the views, so the layout can show the user.
+const currentUserKey = "CurrentUser"
+
+// Require returns a middleware that lets the request through only for a
+// logged in user whose role allows role. Browsers without a session are
+// sent to the login page, API clients get 401 and users with a lesser role
+// get 403.
+func Require(role auth.Role) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, err := sessionUser(c)
+		if err != nil {
+			return err
+		}
+		if user == nil {
+			return loginRequired(c)
+		}
+		c.Locals(currentUserKey, user)
+
+		if !user.Role.Allows(role) {
+			fiberErr := fiber.NewError(fiber.StatusForbidden, "The "+string(user.Role)+" role does not allow this; it needs "+string(role))
+			if wantsJSON(c) {
+				return c.Status(fiberErr.Code).JSON(fiber.Map{"error": fiberErr.Message})
+			}
+			return fiberErr
+		}
+		return c.Next()
+	}
+}
+
+// currentUser returns the user logged in for the request, set by Require
+func currentUser(c *fiber.Ctx) *auth.User {
+	user, _ := c.Locals(currentUserKey).(*auth.User)
+	return user
+}
+
+// sessionUser looks up the user of the session cookie, returning nil when
+// there is no valid session
+func sessionUser(c *fiber.Ctx) (*auth.User, error) {
+	token := c.Cookies(sessionCookie)
+	if token == "" {
+		return nil, nil
+	}
+	user, err := userStore.Session(c.UserContext(), token)
+	if errors.Is(err, auth.ErrNotFound) {
+		return nil, nil
+	}
+	return user, err
+}
+
+// loginRequired answers a request that needs a session. Page requests are
+// redirected to the login page, which returns to the page afterwards.
+func loginRequired(c *fiber.Ctx) error {
+	login := "/login"
+	if c.Method() == fiber.MethodGet {
+		login += "?next=" + url.QueryEscape(c.OriginalURL())
+	}
+
+	switch {
+	case wantsJSON(c):
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Login required"})
+	case c.Get("HX-Request") != "":
+		// htmx follows this header with a full page load
+		c.Set("HX-Redirect", login)
+		return c.SendStatus(fiber.StatusUnauthorized)
+	}
+	return c.Redirect(login, fiber.StatusSeeOther)
+}
+
+// LoginPageHandler renders the login page, or goes straight on for users
+// who are already logged in
+func LoginPageHandler(c *fiber.Ctx) error {
+	next := safeNext(c.Query("next"))
+	if user, err := sessionUser(c); err == nil && user != nil {
+		return c.Redirect(next, fiber.StatusSeeOther)
+	}
+
+	return c.Render("login", fiber.Map{
+		"Title": "Log In",
+		"Login": models.LoginForm{Next: next},
+	})
+}
+
+// LoginHandler checks a username and password and starts a session. API
+// clients get the user as JSON along with the session cookie.
+func LoginHandler(c *fiber.Ctx) error {
+	var form models.LoginForm
+	if err := c.BodyParser(&form); err != nil {
+		return formError(c, fiber.StatusBadRequest, "login", fiber.Map{
+			"Title": "Log In",
+			"Error": "Invalid form data: " + err.Error(),
+		})
+	}
+	form.Next = safeNext(form.Next)
+
+	user, err := userStore.Authenticate(c.UserContext(), form.Username, form.Password)
+	if err != nil {
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			status = fiber.StatusUnauthorized
+			log.Printf("Failed login for %q from %s", form.Username, c.IP())
+		}
+		form.Password = ""
+		return formError(c, status, "login", fiber.Map{
+			"Title": "Log In",
+			"Error": "Login failed: " + err.Error(),
+			"Login": form,
+		})
+	}
+
+	token, expires, err := userStore.CreateSession(c.UserContext(), user.ID)
+	if err != nil {
+		return err
+	}
+	c.Cookie(&fiber.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		Secure:   c.Secure(),
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+	log.Printf("User %s logged in from %s", user.Username, c.IP())
+
+	if wantsJSON(c) {
+		return c.JSON(user)
+	}
+	return c.Redirect(form.Next, fiber.StatusSeeOther)
+}
+
+// LogoutHandler ends the session of the request
+func LogoutHandler(c *fiber.Ctx) error {
+	if token := c.Cookies(sessionCookie); token != "" {
+		if err := userStore.DeleteSession(c.UserContext(), token); err != nil {
+			return err
+		}
+	}
+	c.Cookie(&fiber.Cookie{
+		Name:     sessionCookie,
+		Path:     "/",
+		Expires:  time.Unix(0, 0),
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+
+	if wantsJSON(c) {
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+	return c.Redirect("/login", fiber.StatusSeeOther)
+}
+
+// safeNext returns next when it is a path on this server, so that the login
+// page cannot be used to redirect elsewhere, and the home page otherwise
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, `/\`) {
+		return "/"
+	}
+	return next
+}
+
+// createAdmin creates the configured admin user when there are no users
+// yet, with a generated password unless one is configured
+func createAdmin(c *config.Config) error {
+	ctx := context.Background()
+	n, err := userStore.Count(ctx)
+	if err != nil || n > 0 {
+		return err
+	}
+
+	password := c.AdminPassword
+	if password == "" {
+		var random [12]byte
+		if _, err := rand.Read(random[:]); err != nil {
+			return err
+		}
+		password = hex.EncodeToString(random[:])
+	}
+	admin := &auth.User{Username: c.AdminUsername, Role: auth.RoleAdmin}
+	if err := validateUser(admin, password, true); err != nil {
+		return err
+	}
+	if err := userStore.Save(ctx, admin, password); err != nil {
+		return err
+	}
+
+	if c.AdminPassword == "" {
+		log.Printf("Created the admin user %q with the password %s; change it on the Users page", admin.Username, password)
+	} else {
+		log.Printf("Created the admin user %q with the password from ADMIN_PASSWORD", admin.Username)
+	}
+	return nil
+}
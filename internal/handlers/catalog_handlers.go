@@ -0,0 +1,290 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sqlclient-export-import/internal/catalog"
+	"sqlclient-export-import/internal/dataexport"
+	"sqlclient-export-import/internal/drivers"
+	"sqlclient-export-import/internal/manifest"
+	"sqlclient-export-import/internal/models"
+	"sqlclient-export-import/internal/storage"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ExportsPageHandler lists the export catalog, newest first, optionally
+// filtered by database type, database and status. API clients get JSON.
+func ExportsPageHandler(c *fiber.Ctx) error {
+	filter := catalog.Filter{
+		Type:     c.Query("type"),
+		Database: c.Query("database"),
+		Status:   c.Query("status"),
+		Limit:    c.QueryInt("limit", 200),
+	}
+
+	entries, err := exportCatalog.List(c.UserContext(), filter)
+	if err != nil {
+		return catalogError(c, err)
+	}
+	if wantsJSON(c) {
+		if entries == nil {
+			entries = []catalog.Entry{}
+		}
+		return c.JSON(entries)
+	}
+
+	databases, err := exportCatalog.Databases(c.UserContext())
+	if err != nil {
+		return catalogError(c, err)
+	}
+	return c.Render("exports", fiber.Map{
+		"Title":     "Exports",
+		"Entries":   entries,
+		"Filter":    filter,
+		"Types":     drivers.Names(),
+		"Databases": databases,
+		"Statuses":  []string{catalog.StatusSucceeded, catalog.StatusFailed, catalog.StatusCancelled},
+	})
+}
+
+// ExportEntryHandler shows everything recorded about one export
+func ExportEntryHandler(c *fiber.Ctx) error {
+	entry, err := catalogEntry(c)
+	if err != nil {
+		return catalogError(c, err)
+	}
+	if wantsJSON(c) {
+		return c.JSON(entry)
+	}
+
+	return renderEntry(c, entry, nil)
+}
+
+// VerifyEntryHandler re-hashes the file of a catalogued export and compares
+// it with its manifest. Browsers see the outcome on the export's page.
+func VerifyEntryHandler(c *fiber.Ctx) error {
+	entry, err := catalogEntry(c)
+	if err != nil {
+		return catalogError(c, err)
+	}
+	verification, err := verifyExport(entry)
+	if err != nil {
+		return catalogError(c, err)
+	}
+	if wantsJSON(c) {
+		return c.JSON(verification)
+	}
+	return renderEntry(c, entry, verification)
+}
+
+// DownloadEntryHandler sends the file of a catalogued export
+func DownloadEntryHandler(c *fiber.Ctx) error {
+	if err := sendExport(c, requestActor(c)); err != nil {
+		return catalogError(c, err)
+	}
+	return nil
+}
+
+// DeleteEntryHandler removes an export's file and its catalog entry.
+// Browsers are sent back to the catalog; API clients get 204 No Content.
+func DeleteEntryHandler(c *fiber.Ctx) error {
+	entry, err := catalogEntry(c)
+	if err != nil {
+		return catalogError(c, err)
+	}
+
+	if entry.File != "" {
+		store, err := storage.Get(entry.Storage)
+		if err != nil {
+			return catalogError(c, err)
+		}
+		if err := store.Remove(c.UserContext(), entry.File); err != nil {
+			return catalogError(c, err)
+		}
+		if err := store.Remove(c.UserContext(), manifest.Path(entry.File)); err != nil {
+			return catalogError(c, err)
+		}
+	}
+	if err := exportCatalog.Delete(c.UserContext(), entry.ID); err != nil {
+		return catalogError(c, err)
+	}
+	log.Printf("Deleted export %d of %s (%s)", entry.ID, entry.Database, entry.File)
+
+	if wantsJSON(c) {
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+	return c.Redirect("/exports", fiber.StatusSeeOther)
+}
+
+// RestoreOptions lists the SQL exports that still have a file, newest
+// first, for the restore picker of the import page. It is registered as a
+// template function.
+func RestoreOptions() ([]catalog.Entry, error) {
+	entries, err := exportCatalog.List(context.Background(), catalog.Filter{Status: catalog.StatusSucceeded, Limit: 200})
+	if err != nil {
+		return nil, err
+	}
+	var restorable []catalog.Entry
+	for _, entry := range entries {
+		if entry.File != "" && (entry.Format == "" || entry.Format == dataexport.SQL) {
+			restorable = append(restorable, entry)
+		}
+	}
+	return restorable, nil
+}
+
+// renderEntry renders the page of a catalogued export, with the source's
+// server version from its manifest and the outcome of a verification if
+// there was one
+func renderEntry(c *fiber.Ctx, entry *catalog.Entry, verification *manifest.Verification) error {
+	data := fiber.Map{
+		"Title":        "Export " + strconv.FormatInt(entry.ID, 10),
+		"Entry":        entry,
+		"Verification": verification,
+	}
+	if store, err := storage.Get(entry.Storage); err == nil && entry.File != "" {
+		data["Location"] = store.Location(entry.File)
+		if m, err := manifest.Read(c.UserContext(), store, entry.File); err == nil {
+			data["Manifest"] = m
+		}
+	}
+	return c.Render("export_entry", data)
+}
+
+// catalogEntry looks up the catalog entry named by the :id route parameter
+func catalogEntry(c *fiber.Ctx) (*catalog.Entry, error) {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return nil, catalog.ErrNotFound
+	}
+	return exportCatalog.Get(c.UserContext(), id)
+}
+
+// catalogError reports a failed catalog request as JSON to API clients and
+// through the error page to browsers
+func catalogError(c *fiber.Ctx, err error) error {
+	var fiberErr *fiber.Error
+	switch {
+	case errors.Is(err, catalog.ErrNotFound):
+		fiberErr = fiber.NewError(fiber.StatusNotFound, "Export not found")
+	case errors.As(err, &fiberErr):
+	default:
+		fiberErr = fiber.NewError(fiber.StatusInternalServerError, "Failed to read the export catalog: "+err.Error())
+	}
+
+	if wantsJSON(c) {
+		return c.Status(fiberErr.Code).JSON(fiber.Map{"error": fiberErr.Message})
+	}
+	return fiberErr
+}
+
+// exportOptions summarises the options of an export for the catalog,
+// leaving out those left empty
+func exportOptions(exportForm models.ExportForm) map[string]string {
+	options := map[string]string{
+		"engine":      exportForm.Engine,
+		"compression": exportForm.Compression,
+		"mode":        exportForm.Mode,
+		"skip":        strings.Join(exportForm.Skip, ", "),
+		"tables":      strings.Join(exportForm.Tables, ", "),
+		"include":     strings.Join(splitList(exportForm.Include), ", "),
+		"exclude":     strings.Join(splitList(exportForm.Exclude), ", "),
+	}
+	var where []string
+	for i, table := range exportForm.WhereTables {
+		if i < len(exportForm.WhereConditions) && strings.TrimSpace(exportForm.WhereConditions[i]) != "" {
+			where = append(where, table+": "+strings.TrimSpace(exportForm.WhereConditions[i]))
+		}
+	}
+	options["where"] = strings.Join(where, "; ")
+
+	if dataexport.IsDataFormat(exportForm.Format) {
+		options["archive"] = exportForm.Archive
+		options["dateFormat"] = exportForm.DateFormat
+		if exportForm.Format == dataexport.CSV {
+			options["header"] = strconv.FormatBool(exportForm.Header)
+			options["delimiter"] = exportForm.Delimiter
+			options["null"] = exportForm.Null
+		}
+	}
+
+	for name, value := range options {
+		if value == "" {
+			delete(options, name)
+		}
+	}
+	return options
+}
+
+// toolVersion names what wrote an export: the version of the driver's
+// command line client, or "native" for the native engine and the data
+// formats, which are written over database/sql. The form has been
+// validated by the time the export runs.
+func toolVersion(ctx context.Context, exportForm models.ExportForm) string {
+	if exportForm.Engine == "native" || dataexport.IsDataFormat(exportForm.Format) {
+		return "native"
+	}
+
+	driver, err := drivers.Get(exportForm.Type)
+	if err != nil {
+		return ""
+	}
+	versioner, ok := driver.(drivers.ToolVersioner)
+	if !ok {
+		return ""
+	}
+	filter, _ := tableFilter(exportForm)
+	skip, _ := exportObjects(exportForm)
+	version, err := versioner.ToolVersion(ctx, drivers.ExportOptions{Tables: filter, Mode: exportForm.Mode, Skip: skip})
+	if err != nil {
+		log.Printf("Failed to get the %s client version: %v", exportForm.Type, err)
+		return ""
+	}
+	return version
+}
+
+// serverVersion returns the version of the engine an export reads from, or
+// an empty string when the driver cannot tell or the server cannot be
+// reached
+func serverVersion(ctx context.Context, exportForm models.ExportForm) string {
+	driver, err := drivers.Get(exportForm.Type)
+	if err != nil {
+		return ""
+	}
+	reader, ok := driver.(drivers.TableReader)
+	versioner, isVersioner := driver.(drivers.ServerVersioner)
+	if !ok || !isVersioner {
+		return ""
+	}
+	db, err := reader.OpenDB(ctx, exportConnection(exportForm), exportForm.Database)
+	if err != nil {
+		log.Printf("Failed to get the %s server version: %v", exportForm.Type, err)
+		return ""
+	}
+	defer db.Close()
+	version, err := versioner.ServerVersion(ctx, db)
+	if err != nil {
+		log.Printf("Failed to get the %s server version: %v", exportForm.Type, err)
+		return ""
+	}
+	return version
+}
+
+// setChecksumHeaders sends the SHA-256 checksum of a download, in hex to
+// compare with sha256sum and as a Digest header (RFC 3230) for clients
+// that check it
+func setChecksumHeaders(c *fiber.Ctx, sha256Hex string) {
+	sum, err := hex.DecodeString(sha256Hex)
+	if err != nil || len(sum) != sha256.Size {
+		return
+	}
+	c.Set("X-Checksum-SHA256", sha256Hex)
+	c.Set("Digest", "sha-256="+base64.StdEncoding.EncodeToString(sum))
+}
@@ -0,0 +1,430 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sqlclient-export-import/internal/audit"
+	"sqlclient-export-import/internal/compression"
+	"sqlclient-export-import/internal/csvimport"
+	"sqlclient-export-import/internal/dataexport"
+	"sqlclient-export-import/internal/drivers"
+	"sqlclient-export-import/internal/jobs"
+	"sqlclient-export-import/internal/models"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Preview sizes of CSV imports
+const (
+	defaultPreviewRows = 20
+	maxPreviewRows     = 200
+)
+
+// unsafeTableChars are replaced when deriving a table name from a file name
+var unsafeTableChars = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+
+// csvTable is a table offered as the target of a CSV import
+type csvTable struct {
+	Name    string
+	Columns []drivers.Column
+}
+
+// CSVImportPageHandler renders the first step of a CSV import, which
+// uploads the file
+func CSVImportPageHandler(c *fiber.Ctx) error {
+	return c.Render("import_csv", fiber.Map{
+		"Title": "Import CSV",
+	})
+}
+
+// CSVPreviewHandler saves an uploaded CSV file and renders a preview of it
+// along with the tables of the target database to map its columns to
+func CSVPreviewHandler(c *fiber.Ctx) error {
+	var csvForm models.CSVImportForm
+	if err := c.BodyParser(&csvForm); err != nil {
+		return c.Status(fiber.StatusBadRequest).Render("import_csv", fiber.Map{
+			"Title": "Import CSV",
+			"Error": "Invalid form data: " + err.Error(),
+		})
+	}
+
+	file, err := c.FormFile("csvFile")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).Render("import_csv", fiber.Map{
+			"Title": "Import CSV",
+			"Error": "Please upload a CSV file: " + err.Error(),
+			"CSV":   csvForm,
+		})
+	}
+
+	if _, err := csvLoader(&csvForm); err != nil {
+		return c.Status(fiber.StatusBadRequest).Render("import_csv", fiber.Map{
+			"Title": "Import CSV",
+			"Error": err.Error(),
+			"CSV":   csvForm,
+		})
+	}
+
+	// Save the file
+	csvForm.Upload = uploadName(file.Filename)
+	if err := c.SaveFile(file, filepath.Join(cfg.UploadDirectory, csvForm.Upload)); err != nil {
+		log.Printf("Error saving file: %v", err)
+		return c.Status(fiber.StatusInternalServerError).Render("import_csv", fiber.Map{
+			"Title": "Import CSV",
+			"Error": "Failed to save uploaded file: " + err.Error(),
+			"CSV":   csvForm,
+		})
+	}
+	log.Printf("CSV file saved to %s", csvForm.Upload)
+
+	// Suggest a table named after the file
+	if csvForm.NewTable == "" {
+		name := strings.SplitN(filepath.Base(file.Filename), ".", 2)[0]
+		csvForm.NewTable = strings.Trim(unsafeTableChars.ReplaceAllString(name, "_"), "_")
+	}
+
+	return renderCSVMapping(c, fiber.StatusOK, csvForm, "")
+}
+
+// CSVImportHandler starts a job that loads a previewed CSV file into a new
+// or existing table, reporting the records it rejects
+func CSVImportHandler(c *fiber.Ctx) error {
+	var csvForm models.CSVImportForm
+	if err := c.BodyParser(&csvForm); err != nil {
+		return formError(c, fiber.StatusBadRequest, "import_csv", fiber.Map{
+			"Title": "Import CSV",
+			"Error": "Invalid form data: " + err.Error(),
+		})
+	}
+
+	loader, err := csvLoader(&csvForm)
+	if err != nil {
+		return formError(c, fiber.StatusBadRequest, "import_csv", fiber.Map{
+			"Title": "Import CSV",
+			"Error": err.Error(),
+			"CSV":   csvForm,
+		})
+	}
+
+	opts, preview, err := readCSVPreview(csvForm)
+	if err != nil {
+		return formError(c, fiber.StatusBadRequest, "import_csv", fiber.Map{
+			"Title": "Import CSV",
+			"Error": err.Error(),
+			"CSV":   csvForm,
+		})
+	}
+
+	// Resolve the target table and columns before starting the job so that
+	// mistakes can be fixed on the mapping page
+	conn := csvConnection(csvForm)
+	db, err := loader.OpenDB(c.UserContext(), conn, csvForm.Database)
+	if err != nil {
+		return renderCSVMapping(c, fiber.StatusBadRequest, csvForm, "Failed to connect to the database: "+err.Error())
+	}
+	table, create, mappings, err := csvMappings(c.UserContext(), loader, db, csvForm, preview)
+	db.Close()
+	if err != nil {
+		return renderCSVMapping(c, fiber.StatusBadRequest, csvForm, err.Error())
+	}
+
+	columns := make([]string, len(mappings))
+	for i, mapping := range mappings {
+		columns[i] = mapping.Column.Name
+	}
+	filename := filepath.Join(cfg.UploadDirectory, csvForm.Upload)
+	width := len(preview.Columns)
+
+	entry := auditEntry(requestActor(c), audit.ActionImport, csvForm.ConnectionForm, csvForm.Database)
+	entry.Params["format"] = "csv"
+	entry.Params["file"] = csvForm.Upload
+	entry.Params["table"] = table
+	entry.Params["mode"] = csvForm.Mode
+	entry.Params["keepUpload"] = strconv.FormatBool(csvForm.KeepUpload)
+
+	description := "Import of " + csvForm.Upload + " into " + csvForm.Database + "." + table + " (" + csvForm.Type + ")"
+	job := jobManager.Submit("import", description, func(ctx context.Context, job *jobs.Job) (err error) {
+		defer func(started time.Time) { recordAudit(entry, started, err) }(time.Now())
+
+		inFile, err := os.Open(filename)
+		if err != nil {
+			return fmt.Errorf("failed to open import file: %w", err)
+		}
+		defer inFile.Close()
+
+		if info, err := inFile.Stat(); err == nil {
+			job.SetTotal(info.Size())
+		}
+
+		reader, err := compression.NewReader(job.CountReader(inFile))
+		if err != nil {
+			return fmt.Errorf("failed to read import file: %w", err)
+		}
+		defer reader.Close()
+
+		db, err := loader.OpenDB(ctx, conn, csvForm.Database)
+		if err != nil {
+			return errors.New("Failed to connect to the database: " + err.Error())
+		}
+		defer db.Close()
+
+		if create != nil {
+			if err := loader.CreateTable(ctx, db, table, create); err != nil {
+				return errors.New("Failed to create table: " + err.Error())
+			}
+			log.Printf("Created table %s in %s", table, csvForm.Database)
+		}
+		job.SetTable(table)
+
+		result, err := csvimport.Load(ctx, reader, opts, mappings, width,
+			func(next func() ([]any, error), reject func(row int64, reason string)) (int64, error) {
+				return loader.Load(ctx, db, table, columns, next, reject)
+			},
+			func(r csvimport.Rejection) {
+				if r.Line == 0 {
+					fmt.Fprintf(job.Stderr(), "Rejected a row: %s\n", r.Reason)
+					return
+				}
+				fmt.Fprintf(job.Stderr(), "Rejected line %d: %s\n", r.Line, r.Reason)
+			})
+		if err != nil {
+			if ctx.Err() != nil {
+				log.Printf("Import of %s into %s cancelled", csvForm.Upload, table)
+				return ctx.Err()
+			}
+			errorMsg := "Failed to import CSV file: " + describeError(err)
+			log.Printf("Import error: %s", errorMsg)
+			return errors.New(errorMsg)
+		}
+
+		log.Printf("Loaded %d rows from %s into %s, rejected %d", result.Loaded, csvForm.Upload, table, result.Rejected)
+		job.SetResult("loaded", strconv.FormatInt(result.Loaded, 10))
+		job.SetResult("rejected", strconv.FormatInt(result.Rejected, 10))
+		if !csvForm.KeepUpload {
+			removeUpload(filename)
+		}
+		return nil
+	})
+
+	return jobAccepted(c, job)
+}
+
+// csvLoader validates the connection fields of a CSV import, filling in
+// those of its connection profile and defaults, and returns the driver's table loader
+func csvLoader(csvForm *models.CSVImportForm) (drivers.TableLoader, error) {
+	if err := useProfile(context.Background(), &csvForm.ConnectionForm); err != nil {
+		return nil, err
+	}
+	driver, err := drivers.Get(csvForm.Type)
+	if err != nil {
+		return nil, err
+	}
+	loader, ok := driver.(drivers.TableLoader)
+	if !ok {
+		return nil, errors.New("CSV imports are not supported for " + csvForm.Type)
+	}
+
+	if csvForm.Database == "" || missingServerFields(driver, csvForm.Host, csvForm.Username) {
+		return nil, errors.New("Please fill in all required fields")
+	}
+	if err := driver.ValidateName(csvForm.Database); err != nil {
+		return nil, err
+	}
+
+	// Set defaults for options left empty
+	if csvForm.Port == "" {
+		csvForm.Port = driver.DefaultPort()
+	}
+	if csvForm.PreviewRows <= 0 {
+		csvForm.PreviewRows = defaultPreviewRows
+	}
+	csvForm.PreviewRows = min(csvForm.PreviewRows, maxPreviewRows)
+	if csvForm.Mode == "" {
+		csvForm.Mode = "create"
+	}
+	return loader, nil
+}
+
+// readCSVPreview reads the start of the saved upload of a CSV import
+func readCSVPreview(csvForm models.CSVImportForm) (csvimport.Options, *csvimport.Preview, error) {
+	delimiter, err := dataexport.ParseDelimiter(csvForm.Delimiter)
+	if err != nil {
+		return csvimport.Options{}, nil, err
+	}
+	opts := csvimport.Options{
+		Delimiter: delimiter,
+		Header:    csvForm.Header,
+		Null:      csvForm.Null,
+	}
+
+	if csvForm.Upload == "" || csvForm.Upload != filepath.Base(csvForm.Upload) {
+		return opts, nil, errors.New("The uploaded file is missing, please upload it again")
+	}
+	inFile, err := os.Open(filepath.Join(cfg.UploadDirectory, csvForm.Upload))
+	if err != nil {
+		return opts, nil, errors.New("The uploaded file is missing, please upload it again")
+	}
+	defer inFile.Close()
+
+	reader, err := compression.NewReader(inFile)
+	if err != nil {
+		return opts, nil, fmt.Errorf("failed to read uploaded file: %w", err)
+	}
+	defer reader.Close()
+
+	preview, err := csvimport.ReadPreview(reader, opts, csvForm.PreviewRows)
+	if err != nil {
+		return opts, nil, fmt.Errorf("failed to read CSV file: %w", err)
+	}
+	return opts, preview, nil
+}
+
+// csvMappings pairs every CSV column that has a target with the table
+// column it is loaded into. For new tables it also returns the columns to
+// create.
+func csvMappings(ctx context.Context, loader drivers.TableLoader, db *sql.DB, csvForm models.CSVImportForm, preview *csvimport.Preview) (string, []drivers.Column, []csvimport.Mapping, error) {
+	table := strings.TrimSpace(csvForm.NewTable)
+	var existing []drivers.Column
+	if csvForm.Mode == "existing" {
+		table = csvForm.Table
+		if table == "" {
+			return "", nil, nil, errors.New("Please choose the table to load into")
+		}
+		columns, err := loader.Columns(ctx, db, table)
+		if err != nil {
+			return "", nil, nil, fmt.Errorf("failed to read the columns of %s: %w", table, err)
+		}
+		existing = columns
+	} else if table == "" {
+		return "", nil, nil, errors.New("Please provide a name for the new table")
+	}
+
+	var create []drivers.Column
+	var mappings []csvimport.Mapping
+	used := make(map[string]bool)
+	for i := range preview.Columns {
+		target := ""
+		if i < len(csvForm.Targets) {
+			target = strings.TrimSpace(csvForm.Targets[i])
+		}
+		if target == "" {
+			continue
+		}
+		if used[strings.ToLower(target)] {
+			return "", nil, nil, fmt.Errorf("column %s is mapped more than once", target)
+		}
+		used[strings.ToLower(target)] = true
+
+		column := drivers.Column{Name: target, Type: drivers.TypeText}
+		if existing != nil {
+			found := false
+			for _, col := range existing {
+				if strings.EqualFold(col.Name, target) {
+					column, found = col, true
+					break
+				}
+			}
+			if !found {
+				return "", nil, nil, fmt.Errorf("table %s has no column %s", table, target)
+			}
+		} else {
+			if i < len(csvForm.Types) {
+				column.Type = drivers.ColumnType(csvForm.Types[i])
+			}
+			if !validColumnType(column.Type) {
+				return "", nil, nil, fmt.Errorf("unsupported column type: %s", column.Type)
+			}
+			create = append(create, column)
+		}
+		mappings = append(mappings, csvimport.Mapping{Source: i, Column: column})
+	}
+
+	if len(mappings) == 0 {
+		return "", nil, nil, errors.New("Please map at least one column")
+	}
+	return table, create, mappings, nil
+}
+
+// renderCSVMapping renders the second step of a CSV import: a preview of
+// the uploaded file and the form mapping its columns to a table
+func renderCSVMapping(c *fiber.Ctx, status int, csvForm models.CSVImportForm, errorMsg string) error {
+	if wantsJSON(c) && errorMsg != "" {
+		return c.Status(status).JSON(fiber.Map{"error": errorMsg})
+	}
+
+	loader, err := csvLoader(&csvForm)
+	if err == nil {
+		var preview *csvimport.Preview
+		if _, preview, err = readCSVPreview(csvForm); err == nil {
+			var tables []csvTable
+			if tables, err = csvTables(c.UserContext(), loader, csvForm); err == nil {
+				return c.Status(status).Render("import_csv", fiber.Map{
+					"Title":       "Import CSV",
+					"Error":       errorMsg,
+					"CSV":         csvForm,
+					"Preview":     preview,
+					"Tables":      tables,
+					"ColumnTypes": drivers.ColumnTypes,
+				})
+			}
+			err = errors.New("Failed to list tables: " + err.Error())
+		}
+	}
+
+	return c.Status(fiber.StatusBadRequest).Render("import_csv", fiber.Map{
+		"Title": "Import CSV",
+		"Error": err.Error(),
+		"CSV":   csvForm,
+	})
+}
+
+// csvTables lists the tables of the target database with their columns
+func csvTables(ctx context.Context, loader drivers.TableLoader, csvForm models.CSVImportForm) ([]csvTable, error) {
+	db, err := loader.OpenDB(ctx, csvConnection(csvForm), csvForm.Database)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	tables, err := loader.Tables(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]csvTable, len(tables))
+	for i, table := range tables {
+		columns, err := loader.Columns(ctx, db, table.Name)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = csvTable{Name: table.Name, Columns: columns}
+	}
+	return result, nil
+}
+
+func validColumnType(t drivers.ColumnType) bool {
+	for _, known := range drivers.ColumnTypes {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+// csvConnection extracts the driver connection from a CSV import form
+func csvConnection(form models.CSVImportForm) drivers.Connection {
+	return drivers.Connection{
+		Host:     form.Host,
+		Port:     form.Port,
+		Username: form.Username,
+		Password: form.Password,
+	}
+}
@@ -1,25 +1,190 @@
 package handlers
 
 import (
-	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"sqlclient-export-import/internal/audit"
+	"sqlclient-export-import/internal/auth"
+	"sqlclient-export-import/internal/catalog"
+	"sqlclient-export-import/internal/compression"
 	"sqlclient-export-import/internal/config"
+	"sqlclient-export-import/internal/dataexport"
+	"sqlclient-export-import/internal/drivers"
+	"sqlclient-export-import/internal/drivers/mysql"
+	"sqlclient-export-import/internal/drivers/postgres"
+	"sqlclient-export-import/internal/drivers/sqlite"
+	"sqlclient-export-import/internal/encryption"
+	"sqlclient-export-import/internal/jobs"
+	"sqlclient-export-import/internal/manifest"
 	"sqlclient-export-import/internal/models"
+	"sqlclient-export-import/internal/profiles"
+	"sqlclient-export-import/internal/retention"
+	"sqlclient-export-import/internal/scheduler"
+	"sqlclient-export-import/internal/storage"
 	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-var cfg *config.Config
+var (
+	cfg             *config.Config
+	jobManager      *jobs.Manager
+	exportCatalog   *catalog.Catalog
+	profileStore    *profiles.Store
+	backupScheduler *scheduler.Scheduler
+	userStore       *auth.Store
+	auditLog        *audit.Log
+)
+
+// Initialize sets up the handlers with the application configuration and
+// starts the background tasks of the server
+func Initialize(c *config.Config) error {
+	if err := Open(c); err != nil {
+		return err
+	}
+
+	// Local users log in to the web UI. The first start creates an admin.
+	var err error
+	userStore, err = auth.Open(filepath.Join(c.DataDirectory, "users.db"), c.SessionTTL)
+	if err != nil {
+		return fmt.Errorf("failed to open the users: %w", err)
+	}
+	if err := createAdmin(c); err != nil {
+		return fmt.Errorf("failed to create the admin user: %w", err)
+	}
+
+	// Enforce the retention policy in the background
+	sweeper := &retention.Sweeper{
+		Catalog: exportCatalog,
+		Policy: retention.Policy{
+			MaxAge:        c.RetentionMaxAge,
+			MaxTotalBytes: c.RetentionMaxBytes,
+			KeepLast:      c.RetentionKeepLast,
+			Daily:         c.RetentionDaily,
+			Weekly:        c.RetentionWeekly,
+			Monthly:       c.RetentionMonthly,
+		},
+		UploadDir:    c.UploadDirectory,
+		UploadMaxAge: c.UploadMaxAge,
+	}
+	if c.RetentionInterval > 0 {
+		go sweeper.Run(context.Background(), c.RetentionInterval)
+	}
+
+	// Run the backup schedules. Their passwords and passphrases are sealed
+	// with the master key of the connection profiles.
+	store, err := scheduler.OpenStore(filepath.Join(c.DataDirectory, "schedules.db"), profileStore)
+	if err != nil {
+		return fmt.Errorf("failed to open the schedules: %w", err)
+	}
+	backupScheduler = scheduler.New(store, runSchedule)
+	if err := backupScheduler.Start(); err != nil {
+		return fmt.Errorf("failed to start the scheduler: %w", err)
+	}
+	return nil
+}
 
-// Initialize sets up the handlers with the application configuration
-func Initialize(c *config.Config) {
+// Open sets up what the database operations need: the job manager, the
+// export catalog and storages, the connection profiles, the audit log and
+// the database drivers. The server and the command line client share it.
+func Open(c *config.Config) error {
 	cfg = c
+	jobManager = jobs.NewManager(c.JobWorkers)
+
+	var err error
+	exportCatalog, err = catalog.Open(filepath.Join(c.DataDirectory, "catalog.db"))
+	if err != nil {
+		return fmt.Errorf("failed to open the export catalog: %w", err)
+	}
+
+	// Connection profiles keep their passwords encrypted with the master
+	// key, generated into the data directory when none is configured
+	masterKey := c.MasterKey
+	if masterKey == "" {
+		keyFile := filepath.Join(c.DataDirectory, "master.key")
+		var created bool
+		if masterKey, created, err = profiles.KeyFile(keyFile); err != nil {
+			return fmt.Errorf("failed to read the master key: %w", err)
+		}
+		if created {
+			log.Printf("Generated a master key for connection profiles in %s; set MASTER_KEY to keep it elsewhere", keyFile)
+		}
+	}
+	profileStore, err = profiles.Open(filepath.Join(c.DataDirectory, "profiles.db"), masterKey)
+	if err != nil {
+		return fmt.Errorf("failed to open the connection profiles: %w", err)
+	}
+
+	// Every database operation is recorded in the audit log
+	auditLog, err = audit.Open(filepath.Join(c.DataDirectory, "audit.db"))
+	if err != nil {
+		return fmt.Errorf("failed to open the audit log: %w", err)
+	}
+
+	// Register the supported database engines
+	drivers.Register("mysql", mysql.New())
+	drivers.Register("mariadb", mysql.New())
+	drivers.Register("postgres", postgres.New())
+	drivers.Register("sqlite", sqlite.New(c.SQLiteDirectory))
+
+	// Exports are kept in the export directory and the remote storages
+	// that are configured
+	return registerStorages(c)
+}
+
+// registerStorages registers the export directory and the S3 and SFTP
+// storages that are configured
+func registerStorages(c *config.Config) error {
+	storage.Register(storage.NewLocal(c.ExportDirectory))
+	if c.S3Bucket != "" {
+		s3, err := storage.NewS3(storage.S3Config{
+			Endpoint:  c.S3Endpoint,
+			Region:    c.S3Region,
+			Bucket:    c.S3Bucket,
+			Prefix:    c.S3Prefix,
+			AccessKey: c.S3AccessKey,
+			SecretKey: c.S3SecretKey,
+			UseSSL:    c.S3UseSSL,
+		})
+		if err != nil {
+			return err
+		}
+		storage.Register(s3)
+	}
+	if c.SFTPHost != "" {
+		sftp, err := storage.NewSFTP(storage.SFTPConfig{
+			Host:       c.SFTPHost,
+			Port:       c.SFTPPort,
+			Username:   c.SFTPUsername,
+			Password:   c.SFTPPassword,
+			KeyFile:    c.SFTPKeyFile,
+			Directory:  c.SFTPDirectory,
+			KnownHosts: c.SFTPKnownHosts,
+		})
+		if err != nil {
+			return err
+		}
+		storage.Register(sftp)
+	}
+	if _, err := storage.Get(c.Storage); err != nil {
+		return fmt.Errorf("STORAGE names the %s storage, which is not configured", c.Storage)
+	}
+	return nil
+}
+
+// StorageOptions lists the configured storages for the export forms
+func StorageOptions() []string {
+	return storage.Names()
 }
 
 // HomeHandler renders the home page
@@ -41,154 +206,399 @@ func ExportDatabaseHandler(c *fiber.Ctx) error {
 	// Parse form
 	var exportForm models.ExportForm
 	if err := c.BodyParser(&exportForm); err != nil {
-		return c.Status(fiber.StatusBadRequest).Render("export", fiber.Map{
+		return formError(c, fiber.StatusBadRequest, "export", fiber.Map{
 			"Title": "Export Database",
 			"Error": "Invalid form data",
 		})
 	}
 
-	// Validate form data
-	if exportForm.Host == "" || exportForm.Database == "" || exportForm.Username == "" {
-		return c.Status(fiber.StatusBadRequest).Render("export", fiber.Map{
+	job, err := startExport(exportForm, requestActor(c))
+	if err != nil {
+		return formError(c, fiber.StatusBadRequest, "export", fiber.Map{
 			"Title":  "Export Database",
-			"Error":  "Please fill in all required fields",
+			"Error":  err.Error(),
 			"Export": exportForm,
 		})
 	}
+	return jobAccepted(c, job)
+}
+
+// exportPlan is a validated export, ready to run as a job
+type exportPlan struct {
+	form        models.ExportForm
+	description string
+	extension   string
+	write       func(ctx context.Context, job *jobs.Job, w io.Writer) error
+}
+
+// startExport validates an export and starts a job that runs it. The
+// actor is recorded in the export catalog and the audit log, which also
+// records exports rejected here.
+func startExport(exportForm models.ExportForm, actor audit.Actor) (*jobs.Job, error) {
+	started := time.Now()
+	plan, err := planExport(exportForm)
+	if err != nil {
+		recordAudit(auditEntry(actor, audit.ActionExport, exportForm.ConnectionForm, exportForm.Database), started, err)
+		return nil, err
+	}
+	return submitExport(plan, actor), nil
+}
+
+// planExport validates an export, filling in the connection of its profile
+// and defaults
+func planExport(exportForm models.ExportForm) (*exportPlan, error) {
+	if err := useProfile(context.Background(), &exportForm.ConnectionForm); err != nil {
+		return nil, err
+	}
+	driver, err := drivers.Get(exportForm.Type)
+	if err != nil {
+		return nil, errors.New("Unsupported database type")
+	}
+
+	// Validate form data
+	if exportForm.Database == "" || missingServerFields(driver, exportForm.Host, exportForm.Username) {
+		return nil, errors.New("Please fill in all required fields")
+	}
+	if err := driver.ValidateName(exportForm.Database); err != nil {
+		return nil, err
+	}
 
 	// Set default port if not provided
 	if exportForm.Port == "" {
-		switch exportForm.Type {
-		case "mysql", "mariadb":
-			exportForm.Port = "3306"
-		case "postgres":
-			exportForm.Port = "5432"
-		}
+		exportForm.Port = driver.DefaultPort()
 	}
 
-	// Generate filename with timestamp
-	timestamp := time.Now().Format("20060102_150405")
-	filename := filepath.Join(cfg.ExportDirectory, exportForm.Database+"_"+timestamp+".sql")
-	downloadFilename := exportForm.Database + "_" + timestamp + ".sql"
-
-	// Perform the export based on database type
-	var cmd *exec.Cmd
-	var stderr bytes.Buffer
-
-	switch exportForm.Type {
-	case "mysql", "mariadb":
-		// For MySQL/MariaDB, we'll use a simpler approach
-		args := []string{
-			"-h", exportForm.Host,
-			"-P", exportForm.Port,
-			"-u", exportForm.Username,
-			"--column-statistics=0",
-		}
-
-		if exportForm.Password != "" {
-			// Pass password directly with -p option (no space between -p and password)
-			args = append(args, "-p"+exportForm.Password)
-		}
+	if err := compression.Validate(exportForm.Compression); err != nil {
+		return nil, err
+	}
+	if err := exportEncryption(exportForm).Validate(); err != nil {
+		return nil, err
+	}
+	if exportForm.Storage == "" {
+		exportForm.Storage = cfg.Storage
+	}
+	if _, err := storage.Get(exportForm.Storage); err != nil {
+		return nil, errors.New("The " + exportForm.Storage + " storage is not configured")
+	}
 
-		args = append(args, "--databases", exportForm.Database)
+	filter, err := tableFilter(exportForm)
+	if err != nil {
+		return nil, err
+	}
+	skip, err := exportObjects(exportForm)
+	if err != nil {
+		return nil, err
+	}
 
-		// Log the command (without password)
-		log.Printf("Running mysqldump command: mysqldump -h %s -P %s -u %s --column-statistics=0 --databases %s",
-			exportForm.Host, exportForm.Port, exportForm.Username, exportForm.Database)
+	// CSV, NDJSON and JSON exports write one file per table
+	switch {
+	case dataexport.IsDataFormat(exportForm.Format):
+		return planDataExport(driver, exportForm, filter)
+	case exportForm.Format != "" && exportForm.Format != dataexport.SQL:
+		return nil, errors.New("Unsupported export format: " + exportForm.Format)
+	}
 
-		cmd = exec.Command("mysqldump", args...)
-		cmd.Stderr = &stderr
-	case "postgres":
-		env := os.Environ()
-		if exportForm.Password != "" {
-			env = append(env, "PGPASSWORD="+exportForm.Password)
+	// Pick the export engine
+	export := driver.Export
+	if exportForm.Engine == "native" {
+		native, ok := driver.(drivers.NativeExporter)
+		if !ok {
+			return nil, errors.New("The native export engine does not support " + exportForm.Type)
 		}
+		export = native.ExportNative
+	}
 
-		args := []string{
-			"-h", exportForm.Host,
-			"-p", exportForm.Port,
-			"-U", exportForm.Username,
-			exportForm.Database,
-		}
+	description := "Export of " + exportForm.Database + " (" + exportForm.Type + ")"
+	switch exportForm.Mode {
+	case drivers.ModeSchema:
+		description = "Schema export of " + exportForm.Database + " (" + exportForm.Type + ")"
+	case drivers.ModeData:
+		description = "Data export of " + exportForm.Database + " (" + exportForm.Type + ")"
+	}
+	return &exportPlan{form: exportForm, description: description, extension: ".sql", write: func(ctx context.Context, job *jobs.Job, w io.Writer) error {
+		return export(ctx, exportConnection(exportForm), exportForm.Database, job.WatchTables(w), drivers.ExportOptions{
+			Stderr:  job.Stderr(),
+			Tables:  filter,
+			Mode:    exportForm.Mode,
+			Skip:    skip,
+			TempDir: cfg.ExportDirectory,
+		})
+	}}, nil
+}
 
-		// Log the command (without password)
-		log.Printf("Running pg_dump command: pg_dump -h %s -p %s -U %s %s",
-			exportForm.Host, exportForm.Port, exportForm.Username, exportForm.Database)
+// planDataExport validates the data format options of an export that
+// writes every table filter selects as a CSV, NDJSON or JSON file of an
+// archive
+func planDataExport(driver drivers.Driver, exportForm models.ExportForm, filter drivers.TableFilter) (*exportPlan, error) {
+	reader, ok := driver.(drivers.TableReader)
+	if !ok {
+		return nil, errors.New("Data export formats are not supported for " + exportForm.Type)
+	}
 
-		cmd = exec.Command("pg_dump", args...)
-		cmd.Env = env
-		cmd.Stderr = &stderr
-	default:
-		return c.Status(fiber.StatusBadRequest).Render("export", fiber.Map{
-			"Title":  "Export Database",
-			"Error":  "Unsupported database type",
-			"Export": exportForm,
-		})
+	// Set defaults for options left empty
+	if exportForm.Archive == "" {
+		exportForm.Archive = dataexport.Zip
+	}
+	if exportForm.DateFormat == "" {
+		exportForm.DateFormat = dataexport.DateRFC3339
 	}
 
-	// Open the output file
-	outFile, err := os.Create(filename)
+	delimiter, err := dataexport.ParseDelimiter(exportForm.Delimiter)
+	opts := dataexport.Options{
+		Format:     exportForm.Format,
+		Archive:    exportForm.Archive,
+		Header:     exportForm.Header,
+		Delimiter:  delimiter,
+		Null:       exportForm.Null,
+		DateFormat: exportForm.DateFormat,
+		TempDir:    cfg.ExportDirectory,
+	}
+	if typer, ok := driver.(drivers.BinaryTyper); ok {
+		opts.Binary = typer.IsBinaryType
+	}
+	if err == nil {
+		err = opts.Validate()
+	}
+	if err == nil && exportForm.Mode == drivers.ModeSchema {
+		err = errors.New("schema only exports need the SQL dump format")
+	}
+	if err == nil && opts.Archive == dataexport.Zip && exportForm.Compression != "" && exportForm.Compression != compression.None {
+		err = errors.New("zip archives are already compressed; choose no compression or a tar archive")
+	}
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).Render("export", fiber.Map{
-			"Title":  "Export Database",
-			"Error":  "Failed to create export file: " + err.Error(),
-			"Export": exportForm,
-		})
+		return nil, err
 	}
-	defer outFile.Close()
 
-	// Set the output to the file
-	cmd.Stdout = outFile
+	description := "Export of " + exportForm.Database + " as " + strings.ToUpper(opts.Format) + " (" + exportForm.Type + ")"
+	return &exportPlan{form: exportForm, description: description, extension: opts.Extension(), write: func(ctx context.Context, job *jobs.Job, w io.Writer) error {
+		db, err := reader.OpenDB(ctx, exportConnection(exportForm), exportForm.Database)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
 
-	// Execute the command
-	if err := cmd.Run(); err != nil {
-		// If the command fails, remove the file and return an error with stderr output
-		os.Remove(filename)
+		tables, err := reader.Tables(ctx, db)
+		if err != nil {
+			return err
+		}
+		if !filter.IsZero() {
+			if tables, err = filter.Apply(tables); err != nil {
+				return err
+			}
+		}
+		return dataexport.Export(ctx, db, tables, w, opts, job.SetTable)
+	}}, nil
+}
 
-		errorMsg := fmt.Sprintf("Failed to export database: %v", err)
-		stderrOutput := stderr.String()
+// submitExport starts a job that writes plan into a new file in the
+// storage the form chose, named after the database with the plan's
+// extension and the compression and encryption suffixes appended. The
+// outcome is recorded in the export catalog and the audit log.
+func submitExport(plan *exportPlan, actor audit.Actor) *jobs.Job {
+	exportForm := plan.form
 
-		if stderrOutput != "" {
-			errorMsg += "\nDetails: " + stderrOutput
+	// Generate filename with timestamp
+	timestamp := time.Now().Format("20060102_150405")
+	name := fileNameReplacer.Replace(exportForm.Database) + "_" + timestamp
+	extension := plan.extension + compression.Extension(exportForm.Compression)
+	encryptionOptions := exportEncryption(exportForm)
+	if encryptionOptions.Enabled() {
+		extension += encryption.Extension
+	}
+	store, _ := storage.Get(exportForm.Storage)
+
+	// Run the export in the background
+	return jobManager.Submit("export", plan.description, func(ctx context.Context, job *jobs.Job) error {
+		entry := &catalog.Entry{
+			JobID:       job.ID,
+			Storage:     store.Name(),
+			Type:        exportForm.Type,
+			Host:        exportForm.Host,
+			Port:        exportForm.Port,
+			Database:    exportForm.Database,
+			Format:      exportForm.Format,
+			Options:     exportOptions(exportForm),
+			StartedAt:   time.Now(),
+			ToolVersion: toolVersion(ctx, exportForm),
+			TriggeredBy: actor.Name,
+		}
+		engineVersion := serverVersion(ctx, exportForm)
+		if entry.Format == "" {
+			entry.Format = dataexport.SQL
+		}
+		if encryptionOptions.Enabled() {
+			entry.Options["encryption"] = encryptionOptions.Describe()
+			if len(encryptionOptions.Recipients) > 0 {
+				entry.Options["recipients"] = strings.Join(encryptionOptions.Recipients, ", ")
+			}
 		}
 
-		// Add helpful suggestions based on the error
-		if strings.Contains(stderrOutput, "Access denied") {
-			errorMsg += "\n\nSuggestions:\n- Check your username and password\n- Ensure the user has permission to access the database"
-		} else if strings.Contains(stderrOutput, "Unknown database") {
-			errorMsg += "\n\nSuggestions:\n- Check if the database name is correct\n- Ensure the database exists on the server"
-		} else if strings.Contains(stderrOutput, "Connection refused") {
-			errorMsg += "\n\nSuggestions:\n- Check if the host and port are correct\n- Ensure the database server is running and accessible"
+		var checksum string
+		outFile, downloadFilename, err := createExportFile(ctx, store, name, extension)
+		if err == nil {
+			checksum, err = writeExport(ctx, job, exportForm, store.Location(downloadFilename), outFile, plan.write)
+		}
+		entry.FinishedAt = time.Now()
+		switch {
+		case err == nil:
+			entry.Status = catalog.StatusSucceeded
+			entry.File = downloadFilename
+			entry.SHA256 = checksum
+			if info, statErr := store.Stat(context.Background(), downloadFilename); statErr == nil {
+				entry.Size = info.Size
+			}
+		case ctx.Err() != nil:
+			entry.Status = catalog.StatusCancelled
+		default:
+			entry.Status = catalog.StatusFailed
+			entry.Error = err.Error()
 		}
 
-		log.Printf("Export error: %s", errorMsg)
+		// The job's context may be cancelled already, so the entry is
+		// recorded without it
+		if recordErr := exportCatalog.Add(context.Background(), entry); recordErr != nil {
+			log.Printf("Failed to record export of %s in the catalog: %v", exportForm.Database, recordErr)
+		}
+		manifestWritten := err == nil && writeManifest(store, entry, engineVersion)
+		auditRecord := auditEntry(actor, audit.ActionExport, exportForm.ConnectionForm, exportForm.Database)
+		for name, value := range entry.Options {
+			auditRecord.Params[name] = value
+		}
+		auditRecord.Params["format"] = entry.Format
+		auditRecord.Params["storage"] = entry.Storage
+		if entry.File != "" {
+			auditRecord.Params["file"] = entry.File
+		}
+		recordAudit(auditRecord, entry.StartedAt, err)
+		if err != nil {
+			return err
+		}
 
-		return c.Status(fiber.StatusInternalServerError).Render("export", fiber.Map{
-			"Title":  "Export Database",
-			"Error":  errorMsg,
-			"Export": exportForm,
-		})
+		downloadLink := "/db/download?file=" + downloadFilename
+		if store.Name() != storage.Local {
+			downloadLink += "&storage=" + store.Name()
+		}
+		job.SetResult("file", downloadFilename)
+		job.SetResult("storage", store.Name())
+		job.SetResult("location", store.Location(downloadFilename))
+		job.SetResult("sha256", checksum)
+		job.SetResult("downloadLink", downloadLink)
+		if manifestWritten {
+			job.SetResult("manifest", downloadFilename+manifest.Suffix)
+		}
+		if entry.ID != 0 {
+			job.SetResult("exportId", fmt.Sprint(entry.ID))
+			job.SetResult("exportLink", fmt.Sprintf("/exports/%d", entry.ID))
+		}
+		return nil
+	})
+}
+
+// writeManifest writes the manifest of the successful export entry next to
+// its file in store. A failure is logged rather than failing the export,
+// whose checksum is in the catalog as well.
+func writeManifest(store storage.Storage, entry *catalog.Entry, serverVersion string) bool {
+	err := manifest.Write(context.Background(), store, entry.File, &manifest.Manifest{
+		ExportID:      entry.ID,
+		Type:          entry.Type,
+		ServerVersion: serverVersion,
+		Host:          entry.Host,
+		Port:          entry.Port,
+		Database:      entry.Database,
+		Format:        entry.Format,
+		Options:       entry.Options,
+		ToolVersion:   entry.ToolVersion,
+		Size:          entry.Size,
+		SHA256:        entry.SHA256,
+		StartedAt:     entry.StartedAt,
+		FinishedAt:    entry.FinishedAt,
+		TriggeredBy:   entry.TriggeredBy,
+	})
+	if err != nil {
+		log.Printf("Failed to write the manifest of %s: %v", store.Location(entry.File), err)
+		return false
 	}
+	return true
+}
 
-	// Log success
-	log.Printf("Database exported successfully to %s", filename)
+// fileNameReplacer replaces the path separators that MySQL and PostgreSQL
+// allow in database names, so that exports stay in the storage's directory
+var fileNameReplacer = strings.NewReplacer("/", "_", `\`, "_")
+
+// createExportFile creates a new file in store named name followed by
+// extension, and returns it with its name. Exports of the same database
+// started within the same second get a numbered name instead of
+// overwriting each other.
+func createExportFile(ctx context.Context, store storage.Storage, name, extension string) (storage.Writer, string, error) {
+	for n := 1; ; n++ {
+		filename := name + extension
+		if n > 1 {
+			filename = fmt.Sprintf("%s_%d%s", name, n, extension)
+		}
+		file, err := store.Create(ctx, filename)
+		if !errors.Is(err, fs.ErrExist) {
+			if err != nil {
+				return nil, "", fmt.Errorf("failed to create export file: %w", err)
+			}
+			return file, filename, nil
+		}
+	}
+}
 
-	// Check if the client wants to download the file
-	if c.Query("download") == "true" {
-		return c.Download(filename, downloadFilename)
+// writeExport runs write into outFile, compressed and encrypted as the form
+// asks, and returns the SHA-256 checksum of the file, which location
+// names. A partial file is discarded when the export fails or is
+// cancelled.
+func writeExport(ctx context.Context, job *jobs.Job, exportForm models.ExportForm, location string, outFile storage.Writer, write func(ctx context.Context, job *jobs.Job, w io.Writer) error) (string, error) {
+	// Compress and then encrypt the export on its way to the file,
+	// checksumming what is written
+	hash := sha256.New()
+	encryptor, err := encryption.NewWriter(job.CountWriter(io.MultiWriter(outFile, hash)), exportEncryption(exportForm))
+	if err != nil {
+		outFile.Abort()
+		return "", err
+	}
+	compressor, err := compression.NewWriter(encryptor, exportForm.Compression)
+	if err != nil {
+		outFile.Abort()
+		return "", err
 	}
 
-	// Return success with download link
-	return c.Render("export", fiber.Map{
-		"Title":            "Export Database",
-		"Success":          "Database exported successfully to " + filename,
-		"Export":           exportForm,
-		"DownloadLink":     "/db/download?file=" + filepath.Base(filename),
-		"DownloadFilename": downloadFilename,
-	})
+	// Execute the export
+	err = write(ctx, job, compressor)
+	if closeErr := compressor.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := encryptor.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		// Completes the file, or its upload to a remote storage
+		err = outFile.Close()
+	} else {
+		// If the export fails or is cancelled, remove the partial file
+		outFile.Abort()
+	}
+	if err != nil {
+		// Return an error with stderr output
+		if ctx.Err() != nil {
+			log.Printf("Export of %s cancelled, removed %s", exportForm.Database, location)
+			return "", ctx.Err()
+		}
+
+		errorMsg := "Failed to export database: " + describeError(err)
+		log.Printf("Export error: %s", errorMsg)
+		return "", errors.New(errorMsg)
+	}
+
+	// Log success
+	log.Printf("Database exported successfully to %s", location)
+	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
-// DownloadExportHandler handles downloading exported database files
+// DownloadExportHandler handles downloading exported database files from
+// the export directory, or from the storage named by the storage query
+// parameter
 func DownloadExportHandler(c *fiber.Ctx) error {
 	filename := c.Query("file")
 	if filename == "" {
@@ -198,22 +608,67 @@ func DownloadExportHandler(c *fiber.Ctx) error {
 	// Ensure the filename is just a basename (no path)
 	filename = filepath.Base(filename)
 
-	// Construct the full path
-	fullPath := filepath.Join(cfg.ExportDirectory, filename)
+	started := time.Now()
+	entry := audit.Entry{Actor: requestActor(c), Action: audit.ActionDownload, Params: map[string]string{"file": filename}}
+	store, err := storage.Get(c.Query("storage"))
+	if err != nil {
+		recordAudit(entry, started, err)
+		return c.Status(fiber.StatusBadRequest).SendString("Unknown storage")
+	}
+	entry.Params["storage"] = store.Name()
 
-	// Check if the file exists
-	if _, err := os.Stat(fullPath); os.IsNotExist(err) {
+	// Send the file as a download, with its checksum when it has a manifest
+	var sha256Hex string
+	if m, err := manifest.Read(c.UserContext(), store, filename); err == nil {
+		sha256Hex = m.SHA256
+	}
+	err = sendStoredFile(c, store, filename, sha256Hex)
+	recordAudit(entry, started, err)
+	if errors.Is(err, fs.ErrNotExist) {
 		return c.Status(fiber.StatusNotFound).SendString("File not found")
 	}
+	return err
+}
+
+// sendStoredFile sends the file name of store as a download, with its
+// checksum when it is known
+func sendStoredFile(c *fiber.Ctx, store storage.Storage, name, sha256Hex string) error {
+	// Files of the export directory are sent by the web server itself
+	if local, ok := store.(*storage.LocalStorage); ok {
+		if _, err := local.Stat(c.UserContext(), name); err != nil {
+			return err
+		}
+		setChecksumHeaders(c, sha256Hex)
+		return c.Download(local.Path(name), name)
+	}
 
-	// Send the file as a download
-	return c.Download(fullPath, filename)
+	info, err := store.Stat(c.UserContext(), name)
+	if err != nil {
+		return err
+	}
+	// The stream outlives the request handler, so it is not bound to the
+	// request's context
+	file, err := store.Open(context.Background(), name)
+	if err != nil {
+		return err
+	}
+	setChecksumHeaders(c, sha256Hex)
+	c.Attachment(name)
+	return c.SendStream(file, int(info.Size))
 }
 
-// ImportPageHandler renders the import page
+// ImportPageHandler renders the import page. The export query parameter
+// picks a catalogued export to restore.
 func ImportPageHandler(c *fiber.Ctx) error {
+	importForm := models.ImportForm{Export: int64(c.QueryInt("export"))}
+	if importForm.Export != 0 {
+		if entry, err := exportCatalog.Get(c.UserContext(), importForm.Export); err == nil {
+			importForm.Type = entry.Type
+		}
+	}
 	return c.Render("import", fiber.Map{
-		"Title": "Import Database",
+		"Title":  "Import Database",
+		"Import": importForm,
 	})
 }
 
@@ -221,13 +676,29 @@ func ImportPageHandler(c *fiber.Ctx) error {
 func ImportDatabaseHandler(c *fiber.Ctx) error {
 	log.Println("Starting database import process")
 
+	// Parse form
+	var importForm models.ImportForm
+	if err := c.BodyParser(&importForm); err != nil {
+		log.Printf("Error parsing form: %v", err)
+		return formError(c, fiber.StatusBadRequest, "import", fiber.Map{
+			"Title":  "Import Database",
+			"Error":  "Invalid form data: " + err.Error(),
+			"Import": importForm,
+		})
+	}
+	if c.FormValue("source") == "export" {
+		return restoreExport(c, importForm)
+	}
+	importForm.Export = 0
+
 	// Get the uploaded file
 	file, err := c.FormFile("sqlFile")
 	if err != nil {
 		log.Printf("Error getting uploaded file: %v", err)
-		return c.Status(fiber.StatusBadRequest).Render("import", fiber.Map{
-			"Title": "Import Database",
-			"Error": "Please upload a SQL file: " + err.Error(),
+		return formError(c, fiber.StatusBadRequest, "import", fiber.Map{
+			"Title":  "Import Database",
+			"Error":  "Please upload a SQL file: " + err.Error(),
+			"Import": importForm,
 		})
 	}
 
@@ -239,156 +710,175 @@ func ImportDatabaseHandler(c *fiber.Ctx) error {
 			file.Size, cfg.MaxUploadSize)
 	}
 
-	// Parse form
-	var importForm models.ImportForm
-	if err := c.BodyParser(&importForm); err != nil {
-		log.Printf("Error parsing form: %v", err)
-		return c.Status(fiber.StatusBadRequest).Render("import", fiber.Map{
-			"Title": "Import Database",
-			"Error": "Invalid form data: " + err.Error(),
+	driver, err := planImport(c.UserContext(), &importForm)
+	if err != nil {
+		log.Printf("Invalid import: %v", err)
+		return formError(c, fiber.StatusBadRequest, "import", fiber.Map{
+			"Title":  "Import Database",
+			"Error":  err.Error(),
+			"Import": importForm,
 		})
 	}
 
-	// Validate form data
-	if importForm.Host == "" || importForm.Database == "" || importForm.Username == "" {
-		log.Println("Missing required fields in form data")
-		return c.Status(fiber.StatusBadRequest).Render("import", fiber.Map{
+	filename, err := saveUpload(c, file)
+	if err != nil {
+		return formError(c, fiber.StatusInternalServerError, "import", fiber.Map{
 			"Title":  "Import Database",
-			"Error":  "Please fill in all required fields",
+			"Error":  "Failed to save uploaded file: " + err.Error(),
 			"Import": importForm,
 		})
 	}
 
-	// Set default port if not provided
-	if importForm.Port == "" {
-		switch importForm.Type {
-		case "mysql", "mariadb":
-			importForm.Port = "3306"
-		case "postgres":
-			importForm.Port = "5432"
-		}
-	}
-
-	// Save the file
-	timestamp := time.Now().Format("20060102_150405")
-	filename := filepath.Join(cfg.UploadDirectory, timestamp+"_"+file.Filename)
-	log.Printf("Saving file to: %s", filename)
+	job := submitImport(driver, importForm, filename, file.Filename, requestActor(c))
+	return jobAccepted(c, job)
+}
 
-	if err := c.SaveFile(file, filename); err != nil {
-		log.Printf("Error saving file: %v", err)
-		return c.Status(fiber.StatusInternalServerError).Render("import", fiber.Map{
+// restoreExport starts restoring the catalogued export chosen on the import
+// page instead of an upload
+func restoreExport(c *fiber.Ctx, importForm models.ImportForm) error {
+	if importForm.Export == 0 {
+		return formError(c, fiber.StatusBadRequest, "import", fiber.Map{
 			"Title":  "Import Database",
-			"Error":  "Failed to save uploaded file: " + err.Error(),
+			"Error":  "Please choose an export to restore",
 			"Import": importForm,
 		})
 	}
+	job, err := startRestore(c.UserContext(), importForm, requestActor(c))
+	if err != nil {
+		log.Printf("Invalid restore: %v", err)
+		status, message := operationFailure(err, "Failed to restore export: ")
+		return formError(c, status, "import", fiber.Map{
+			"Title":  "Import Database",
+			"Error":  message,
+			"Import": importForm,
+		})
+	}
+	return jobAccepted(c, job)
+}
 
-	log.Printf("File saved successfully: %s", filename)
-
-	// Perform the import based on database type
-	var cmd *exec.Cmd
-	var stderr bytes.Buffer
+// uploadName names a saved upload after the time it arrived and the
+// client's file name, with a random part so that concurrent uploads of the
+// same file do not overwrite each other
+func uploadName(original string) string {
+	var random [4]byte
+	rand.Read(random[:])
+	return time.Now().Format("20060102_150405") + "_" + hex.EncodeToString(random[:]) + "_" + filepath.Base(original)
+}
 
-	switch importForm.Type {
-	case "mysql", "mariadb":
-		args := []string{
-			"-h", importForm.Host,
-			"-P", importForm.Port,
-			"-u", importForm.Username,
-			"--max_allowed_packet=1G", // Increase max allowed packet size
-		}
+// removeUpload deletes an upload that is no longer needed
+func removeUpload(filename string) {
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove upload %s: %v", filename, err)
+		return
+	}
+	log.Printf("Removed upload %s", filename)
+}
 
-		if importForm.Password != "" {
-			// Pass password directly with -p option (no space between -p and password)
-			args = append(args, "-p"+importForm.Password)
-		}
+// formError reports a problem with a submitted form, re-rendering the form
+// for browsers and returning a JSON error for API clients
+func formError(c *fiber.Ctx, status int, view string, data fiber.Map) error {
+	if wantsJSON(c) {
+		return c.Status(status).JSON(fiber.Map{"error": data["Error"]})
+	}
+	return c.Status(status).Render(view, data)
+}
 
-		args = append(args, importForm.Database)
+// missingServerFields reports whether a server based driver is missing the
+// host or username it needs to connect
+func missingServerFields(driver drivers.Driver, host, username string) bool {
+	return driver.RequiresServer() && (host == "" || username == "")
+}
 
-		// Log the command (without password)
-		log.Printf("Running mysql command: mysql -h %s -P %s -u %s --max_allowed_packet=1G %s",
-			importForm.Host, importForm.Port, importForm.Username, importForm.Database)
+// describeError formats a driver error for display, appending the client's
+// stderr output and suggestions for common failures
+func describeError(err error) string {
+	var cmdErr *drivers.CommandError
+	if !errors.As(err, &cmdErr) {
+		return err.Error()
+	}
 
-		cmd = exec.Command("mysql", args...)
-		cmd.Stderr = &stderr
+	errorMsg := cmdErr.Err.Error()
+	stderrOutput := cmdErr.Stderr
 
-		// Open the input file
-		inFile, err := os.Open(filename)
-		if err != nil {
-			log.Printf("Error opening file for import: %v", err)
-			return c.Status(fiber.StatusInternalServerError).Render("import", fiber.Map{
-				"Title":  "Import Database",
-				"Error":  "Failed to open import file: " + err.Error(),
-				"Import": importForm,
-			})
-		}
-		defer inFile.Close()
-
-		// Set the input from the file
-		cmd.Stdin = inFile
-	case "postgres":
-		env := os.Environ()
-		if importForm.Password != "" {
-			env = append(env, "PGPASSWORD="+importForm.Password)
-		}
+	if stderrOutput != "" {
+		errorMsg += "\nDetails: " + stderrOutput
+	}
 
-		args := []string{
-			"-h", importForm.Host,
-			"-p", importForm.Port,
-			"-U", importForm.Username,
-			"-d", importForm.Database,
-			"-f", filename,
-		}
+	// Add helpful suggestions based on the error
+	if strings.Contains(stderrOutput, "Access denied") {
+		errorMsg += "\n\nSuggestions:\n- Check your username and password\n- Ensure the user has permission to access the database"
+	} else if strings.Contains(stderrOutput, "Unknown database") {
+		errorMsg += "\n\nSuggestions:\n- Check if the database name is correct\n- Ensure the database exists on the server"
+	} else if strings.Contains(stderrOutput, "Connection refused") {
+		errorMsg += "\n\nSuggestions:\n- Check if the host and port are correct\n- Ensure the database server is running and accessible"
+	}
 
-		// Log the command (without password)
-		log.Printf("Running psql command: psql -h %s -p %s -U %s -d %s -f %s",
-			importForm.Host, importForm.Port, importForm.Username, importForm.Database, filename)
+	return errorMsg
+}
 
-		cmd = exec.Command("psql", args...)
-		cmd.Env = env
-		cmd.Stderr = &stderr
+// exportObjects validates the export mode and turns the kinds of objects
+// the form leaves out into driver options
+func exportObjects(exportForm models.ExportForm) (drivers.Objects, error) {
+	switch exportForm.Mode {
+	case "", drivers.ModeFull, drivers.ModeSchema, drivers.ModeData:
 	default:
-		log.Printf("Unsupported database type: %s", importForm.Type)
-		return c.Status(fiber.StatusBadRequest).Render("import", fiber.Map{
-			"Title":  "Import Database",
-			"Error":  "Unsupported database type: " + importForm.Type,
-			"Import": importForm,
-		})
+		return drivers.Objects{}, fmt.Errorf("unsupported export mode: %s", exportForm.Mode)
 	}
 
-	// Execute the command
-	log.Println("Executing import command...")
-	if err := cmd.Run(); err != nil {
-		errorMsg := fmt.Sprintf("Failed to import database: %v", err)
-		stderrOutput := stderr.String()
-
-		if stderrOutput != "" {
-			errorMsg += "\nDetails: " + stderrOutput
-		}
-
-		// Add helpful suggestions based on the error
-		if strings.Contains(stderrOutput, "Access denied") {
-			errorMsg += "\n\nSuggestions:\n- Check your username and password\n- Ensure the user has permission to access the database"
-		} else if strings.Contains(stderrOutput, "Unknown database") {
-			errorMsg += "\n\nSuggestions:\n- Check if the database name is correct\n- Ensure the database exists on the server"
-		} else if strings.Contains(stderrOutput, "Connection refused") {
-			errorMsg += "\n\nSuggestions:\n- Check if the host and port are correct\n- Ensure the database server is running and accessible"
+	var skip drivers.Objects
+	for _, kind := range exportForm.Skip {
+		switch kind {
+		case "routines":
+			skip.Routines = true
+		case "triggers":
+			skip.Triggers = true
+		case "events":
+			skip.Events = true
+		case "views":
+			skip.Views = true
+		case "sequences":
+			skip.Sequences = true
+		default:
+			return drivers.Objects{}, fmt.Errorf("unknown kind of object: %s", kind)
 		}
+	}
+	return skip, nil
+}
 
-		log.Printf("Import error: %s", errorMsg)
+// exportConnection extracts the driver connection from an export form
+func exportConnection(form models.ExportForm) drivers.Connection {
+	return drivers.Connection{
+		Host:     form.Host,
+		Port:     form.Port,
+		Username: form.Username,
+		Password: form.Password,
+	}
+}
 
-		return c.Status(fiber.StatusInternalServerError).Render("import", fiber.Map{
-			"Title":  "Import Database",
-			"Error":  errorMsg,
-			"Import": importForm,
-		})
+// exportEncryption returns how an export is encrypted: as the form asks,
+// or to the server's default recipients when it does not ask
+func exportEncryption(form models.ExportForm) encryption.Options {
+	options := encryption.Options{
+		Recipients: encryption.SplitRecipients(form.Recipients),
+		Passphrase: form.Passphrase,
 	}
+	if !options.Enabled() {
+		options.Recipients = encryption.SplitRecipients(cfg.ExportRecipients)
+	}
+	return options
+}
 
-	// Return success
-	log.Printf("Database imported successfully from %s", file.Filename)
-	return c.Render("import", fiber.Map{
-		"Title":   "Import Database",
-		"Success": "Database imported successfully from " + file.Filename,
-		"Import":  importForm,
-	})
+// importKeys returns the keys that decrypt the upload of an import
+func importKeys(form models.ImportForm) encryption.Keys {
+	return encryption.Keys{Identities: form.Identity, Passphrase: form.Passphrase}
+}
+
+// importConnection extracts the driver connection from an import form
+func importConnection(form models.ImportForm) drivers.Connection {
+	return drivers.Connection{
+		Host:     form.Host,
+		Port:     form.Port,
+		Username: form.Username,
+		Password: form.Password,
+	}
 }
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sqlclient-export-import/internal/audit"
+	"sqlclient-export-import/internal/jobs"
+	"sqlclient-export-import/internal/models"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The functions below run the shared operations without an HTTP request,
+// for the command line client. Open must have been called first. Mistakes
+// in a request are reported as errors for which InvalidRequest is true.
+
+// Export validates an export and starts a job that writes it into the
+// export directory and records it in the export catalog
+func Export(exportForm models.ExportForm, actor audit.Actor) (*jobs.Job, error) {
+	job, err := startExport(exportForm, actor)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	return job, nil
+}
+
+// Import validates an import and starts a job that imports the SQL file at
+// path, which may be compressed. The file is kept after the import.
+func Import(ctx context.Context, importForm models.ImportForm, path string, actor audit.Actor) (*jobs.Job, error) {
+	driver, err := planImport(ctx, &importForm)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(path); err != nil || info.IsDir() {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Please name an SQL file to import")
+	}
+	importForm.KeepUpload = true
+	return submitImport(driver, importForm, path, filepath.Base(path), actor), nil
+}
+
+// Restore validates a restore and starts a job that imports the catalogued
+// export named by importForm.Export straight from its storage
+func Restore(ctx context.Context, importForm models.ImportForm, actor audit.Actor) (*jobs.Job, error) {
+	return startRestore(ctx, importForm, actor)
+}
+
+// ListDatabases lists the user databases on the server of conn
+func ListDatabases(ctx context.Context, conn models.ConnectionForm, actor audit.Actor) ([]models.Database, error) {
+	return listServerDatabases(ctx, &conn, actor)
+}
+
+// CreateDatabase creates the new database of dbOp
+func CreateDatabase(ctx context.Context, dbOp models.DatabaseOperation, actor audit.Actor) error {
+	return createDatabase(ctx, &dbOp, actor)
+}
+
+// RenameDatabase starts a job that renames the database of dbOp to its new
+// name
+func RenameDatabase(ctx context.Context, dbOp models.DatabaseOperation, actor audit.Actor) (*jobs.Job, error) {
+	return startRename(ctx, &dbOp, actor)
+}
+
+// DropDatabase drops the database of dbOp
+func DropDatabase(ctx context.Context, dbOp models.DatabaseOperation, actor audit.Actor) error {
+	return dropDatabase(ctx, &dbOp, actor)
+}
+
+// Copy starts a job that copies the database of from into the database of
+// to
+func Copy(ctx context.Context, from models.ExportForm, to models.ImportForm, actor audit.Actor) (*jobs.Job, error) {
+	return startCopy(ctx, from, to, actor)
+}
+
+// ProfileID returns the ID of the connection profile with the given name
+// or ID
+func ProfileID(ctx context.Context, nameOrID string) (int64, error) {
+	list, err := profileStore.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, profile := range list {
+		if profile.Name == nameOrID || strconv.FormatInt(profile.ID, 10) == nameOrID {
+			return profile.ID, nil
+		}
+	}
+	return 0, fiber.NewError(fiber.StatusBadRequest, "The connection profile "+nameOrID+" does not exist")
+}
+
+// InvalidRequest reports whether err is a mistake in the request rather
+// than a failure of the operation
+func InvalidRequest(err error) bool {
+	var fiberErr *fiber.Error
+	return errors.As(err, &fiberErr) && fiberErr.Code < fiber.StatusInternalServerError
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sqlclient-export-import/internal/jobs"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// JobsPageHandler lists recent background jobs
+func JobsPageHandler(c *fiber.Ctx) error {
+	snapshots := jobManager.List()
+	if wantsJSON(c) {
+		return c.JSON(snapshots)
+	}
+
+	return c.Render("jobs", fiber.Map{
+		"Title": "Jobs",
+		"Jobs":  snapshots,
+	})
+}
+
+// JobHandler reports the status of a single job. Browsers get a page that
+// refreshes itself until the job finishes; API clients get JSON.
+func JobHandler(c *fiber.Ctx) error {
+	job, ok := jobManager.Get(c.Params("id"))
+	if !ok {
+		if wantsJSON(c) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "job not found"})
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Job not found")
+	}
+
+	snapshot := job.Snapshot()
+	if wantsJSON(c) {
+		return c.JSON(snapshot)
+	}
+
+	return c.Render("job", fiber.Map{
+		"Title": "Job " + snapshot.ID,
+		"Job":   snapshot,
+	})
+}
+
+// CancelJobHandler stops a queued or running job, killing the client it is
+// running. Browsers are sent back to the job page; API clients get the job.
+func CancelJobHandler(c *fiber.Ctx) error {
+	job, ok := jobManager.Get(c.Params("id"))
+	if !ok {
+		if wantsJSON(c) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "job not found"})
+		}
+		return fiber.NewError(fiber.StatusNotFound, "Job not found")
+	}
+
+	if !job.Cancel() {
+		if wantsJSON(c) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "job has already finished"})
+		}
+		return fiber.NewError(fiber.StatusConflict, "Job has already finished")
+	}
+	log.Printf("Cancelling job %s: %s", job.ID, job.Description)
+
+	if wantsJSON(c) {
+		return c.Status(fiber.StatusAccepted).JSON(job.Snapshot())
+	}
+	return c.Redirect("/jobs/"+job.ID, fiber.StatusSeeOther)
+}
+
+// JobEventsHandler streams a job's progress as Server-Sent Events. It sends
+// "progress" events with byte counts and the current table, "stderr" events
+// for every line of client output and a final "done" event with the job.
+func JobEventsHandler(c *fiber.Ctx) error {
+	job, ok := jobManager.Get(c.Params("id"))
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "job not found"})
+	}
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(fasthttp.StreamWriter(func(w *bufio.Writer) {
+		events, unsubscribe := job.Subscribe()
+		defer unsubscribe()
+
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+
+		var last progressEvent
+		for {
+			snapshot := job.Snapshot()
+			if progress := newProgressEvent(snapshot); progress != last {
+				last = progress
+				if err := writeEvent(w, "progress", progress); err != nil {
+					return
+				}
+			}
+			if snapshot.Done() {
+				// Deliver client output that arrived just before the end
+				for pending := true; pending; {
+					select {
+					case e := <-events:
+						if e.Type == jobs.EventStderr {
+							writeEvent(w, "stderr", e.Data)
+						}
+					default:
+						pending = false
+					}
+				}
+				writeEvent(w, "done", snapshot)
+				return
+			}
+
+			select {
+			case e := <-events:
+				if e.Type == jobs.EventStderr {
+					if err := writeEvent(w, "stderr", e.Data); err != nil {
+						return
+					}
+				}
+			case <-ticker.C:
+			}
+		}
+	}))
+	return nil
+}
+
+// progressInterval is how often progress is sent to event stream clients
+const progressInterval = 500 * time.Millisecond
+
+// progressEvent is the payload of the "progress" Server-Sent Event
+type progressEvent struct {
+	Status         jobs.Status `json:"status"`
+	BytesProcessed int64       `json:"bytesProcessed"`
+	TotalBytes     int64       `json:"totalBytes,omitempty"`
+	Table          string      `json:"table,omitempty"`
+	ElapsedSeconds int64       `json:"elapsedSeconds"`
+}
+
+func newProgressEvent(s jobs.Snapshot) progressEvent {
+	return progressEvent{
+		Status:         s.Status,
+		BytesProcessed: s.BytesProcessed,
+		TotalBytes:     s.TotalBytes,
+		Table:          s.Table,
+		ElapsedSeconds: int64(s.ElapsedSeconds),
+	}
+}
+
+// writeEvent writes a single Server-Sent Event with a JSON payload and
+// flushes it to the client
+func writeEvent(w *bufio.Writer, event string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+	return w.Flush()
+}
+
+// jobAccepted responds to a request that started a job, redirecting
+// browsers to the job page and returning the job to API clients
+func jobAccepted(c *fiber.Ctx, job *jobs.Job) error {
+	location := "/jobs/" + job.ID
+	if wantsJSON(c) {
+		c.Location(location)
+		return c.Status(fiber.StatusAccepted).JSON(job.Snapshot())
+	}
+	return c.Redirect(location, fiber.StatusSeeOther)
+}
+
+// wantsJSON reports whether the client asked for a JSON response
+func wantsJSON(c *fiber.Ctx) bool {
+	return c.Query("format") == "json" || c.Accepts(fiber.MIMETextHTML, fiber.MIMEApplicationJSON) == fiber.MIMEApplicationJSON
+}
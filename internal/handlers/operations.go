@@ -0,0 +1,506 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"sqlclient-export-import/internal/audit"
+	"sqlclient-export-import/internal/catalog"
+	"sqlclient-export-import/internal/compression"
+	"sqlclient-export-import/internal/dataexport"
+	"sqlclient-export-import/internal/drivers"
+	"sqlclient-export-import/internal/encryption"
+	"sqlclient-export-import/internal/jobs"
+	"sqlclient-export-import/internal/manifest"
+	"sqlclient-export-import/internal/models"
+	"sqlclient-export-import/internal/storage"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The operations below are shared by the HTML and the API handlers and the
+// command line client, which only differ in how they parse requests and
+// report the outcome. Mistakes in a request are returned as a *fiber.Error
+// with status 400; other errors are failures of the operation. Every
+// operation that runs is recorded in the audit log.
+
+// connectionDriver fills in the connection of conn's profile, checks that
+// the fields its driver needs are set and defaults the port
+func connectionDriver(ctx context.Context, conn *models.ConnectionForm) (drivers.Driver, error) {
+	if err := useProfile(ctx, conn); err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	driver, err := drivers.Get(conn.Type)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Unsupported database type: "+conn.Type)
+	}
+	if missingServerFields(driver, conn.Host, conn.Username) {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Please fill in all required fields")
+	}
+	if conn.Port == "" {
+		conn.Port = driver.DefaultPort()
+	}
+	return driver, nil
+}
+
+// listServerDatabases lists the user databases on the server of conn
+func listServerDatabases(ctx context.Context, conn *models.ConnectionForm, actor audit.Actor) ([]models.Database, error) {
+	if _, err := connectionDriver(ctx, conn); err != nil {
+		return nil, err
+	}
+
+	started := time.Now()
+	databases, err := listDatabases(ctx, *conn)
+	recordAudit(auditEntry(actor, audit.ActionList, *conn, ""), started, err)
+	return databases, err
+}
+
+// createDatabase creates the new database of dbOp
+func createDatabase(ctx context.Context, dbOp *models.DatabaseOperation, actor audit.Actor) error {
+	driver, err := connectionDriver(ctx, &dbOp.ConnectionForm)
+	if err != nil {
+		return err
+	}
+	if dbOp.NewDatabase == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Please provide a name for the new database")
+	}
+	if err := validateNames(driver, dbOp.NewDatabase); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	started := time.Now()
+	err = driver.Create(ctx, operationConnection(*dbOp), dbOp.NewDatabase)
+	recordAudit(auditEntry(actor, audit.ActionCreate, dbOp.ConnectionForm, dbOp.NewDatabase), started, err)
+	return err
+}
+
+// dropDatabase drops the database of dbOp
+func dropDatabase(ctx context.Context, dbOp *models.DatabaseOperation, actor audit.Actor) error {
+	driver, err := connectionDriver(ctx, &dbOp.ConnectionForm)
+	if err != nil {
+		return err
+	}
+	if dbOp.Database == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Please provide the database name to drop")
+	}
+	if err := validateNames(driver, dbOp.Database); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	started := time.Now()
+	err = driver.Drop(ctx, operationConnection(*dbOp), dbOp.Database)
+	recordAudit(auditEntry(actor, audit.ActionDrop, dbOp.ConnectionForm, dbOp.Database), started, err)
+	return err
+}
+
+// startRename starts a job that renames the database of dbOp to its new
+// name. Renames copy every row on MySQL, so they run in the background
+// where they can be followed and cancelled.
+func startRename(ctx context.Context, dbOp *models.DatabaseOperation, actor audit.Actor) (*jobs.Job, error) {
+	driver, err := connectionDriver(ctx, &dbOp.ConnectionForm)
+	if err != nil {
+		return nil, err
+	}
+	if dbOp.Database == "" || dbOp.NewDatabase == "" {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Please provide both source and target database names")
+	}
+	if err := validateNames(driver, dbOp.Database, dbOp.NewDatabase); err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	op := *dbOp
+	conn := operationConnection(op)
+	entry := auditEntry(actor, audit.ActionRename, op.ConnectionForm, op.Database)
+	entry.Params["newDatabase"] = op.NewDatabase
+
+	return jobManager.Submit("rename", fmt.Sprintf("Rename of %s to %s (%s)", op.Database, op.NewDatabase, op.Type), func(ctx context.Context, job *jobs.Job) (err error) {
+		defer func(started time.Time) { recordAudit(entry, started, err) }(time.Now())
+
+		if err := driver.Rename(ctx, conn, op.Database, op.NewDatabase); err != nil {
+			if ctx.Err() != nil {
+				log.Printf("Rename of %s to %s cancelled", op.Database, op.NewDatabase)
+				return ctx.Err()
+			}
+			return errors.New("Failed to rename database: " + describeError(err))
+		}
+
+		log.Printf("Database '%s' renamed to '%s' successfully", op.Database, op.NewDatabase)
+		return nil
+	}), nil
+}
+
+// planImport validates an import, filling in the connection of its profile
+// and defaults, and returns the driver that runs it
+func planImport(ctx context.Context, importForm *models.ImportForm) (drivers.Driver, error) {
+	driver, err := connectionDriver(ctx, &importForm.ConnectionForm)
+	if err != nil {
+		return nil, err
+	}
+	if importForm.Database == "" {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Please fill in all required fields")
+	}
+	if err := driver.ValidateName(importForm.Database); err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if err := importKeys(*importForm).Validate(); err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	return driver, nil
+}
+
+// saveUpload saves an uploaded file into the upload directory, returning
+// its path
+func saveUpload(c *fiber.Ctx, file *multipart.FileHeader) (string, error) {
+	filename := filepath.Join(cfg.UploadDirectory, uploadName(file.Filename))
+	log.Printf("Saving file to: %s", filename)
+
+	if err := c.SaveFile(file, filename); err != nil {
+		log.Printf("Error saving file: %v", err)
+		return "", err
+	}
+	log.Printf("File saved successfully: %s", filename)
+	return filename, nil
+}
+
+// submitImport starts a job that imports the SQL file at filename, which
+// the client named original, with driver. The file is removed after a
+// successful import unless the form keeps it.
+func submitImport(driver drivers.Driver, importForm models.ImportForm, filename, original string, actor audit.Actor) *jobs.Job {
+	entry := auditEntry(actor, audit.ActionImport, importForm.ConnectionForm, importForm.Database)
+	entry.Params["file"] = original
+	if info, err := os.Stat(filename); err == nil {
+		entry.Params["size"] = strconv.FormatInt(info.Size(), 10)
+	}
+	entry.Params["keepUpload"] = strconv.FormatBool(importForm.KeepUpload)
+
+	// Run the import in the background
+	return jobManager.Submit("import", "Import of "+original+" into "+importForm.Database+" ("+importForm.Type+")", func(ctx context.Context, job *jobs.Job) (err error) {
+		defer func(started time.Time) { recordAudit(entry, started, err) }(time.Now())
+
+		// Open the input file
+		inFile, err := os.Open(filename)
+		if err != nil {
+			log.Printf("Error opening file for import: %v", err)
+			return fmt.Errorf("failed to open import file: %w", err)
+		}
+		defer inFile.Close()
+
+		if info, err := inFile.Stat(); err == nil {
+			job.SetTotal(info.Size())
+		}
+
+		if err := importSQL(ctx, job, driver, importForm, inFile); err != nil {
+			if ctx.Err() != nil {
+				log.Printf("Import of %s into %s cancelled", original, importForm.Database)
+			}
+			return err
+		}
+
+		log.Printf("Database imported successfully from %s", original)
+		if !importForm.KeepUpload {
+			removeUpload(filename)
+		}
+		return nil
+	})
+}
+
+// importSQL streams the SQL file read from in into the database of
+// importForm, decrypting age encrypted files and decompressing gzip or zstd
+// files on the fly
+func importSQL(ctx context.Context, job *jobs.Job, driver drivers.Driver, importForm models.ImportForm, in io.Reader) error {
+	decrypted, err := encryption.NewReader(job.CountReader(in), importKeys(importForm))
+	if err != nil {
+		log.Printf("Error decrypting import file: %v", err)
+		return fmt.Errorf("failed to decrypt import file: %w", err)
+	}
+	reader, err := compression.NewReader(decrypted)
+	if err != nil {
+		log.Printf("Error reading import file: %v", err)
+		return fmt.Errorf("failed to read import file: %w", err)
+	}
+	defer reader.Close()
+
+	// Execute the import
+	log.Println("Executing import command...")
+	err = driver.Import(ctx, importConnection(importForm), importForm.Database, io.TeeReader(reader, job.WatchTables(io.Discard)), drivers.ImportOptions{
+		Stderr: job.Stderr(),
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		errorMsg := "Failed to import database: " + describeError(err)
+		log.Printf("Import error: %s", errorMsg)
+		return errors.New(errorMsg)
+	}
+	return nil
+}
+
+// startRestore starts a job that imports the catalogued export named by
+// importForm.Export from its storage, without the user downloading and
+// uploading it again. The target may be any server and database. The file
+// is checked against its checksum before anything is imported.
+func startRestore(ctx context.Context, importForm models.ImportForm, actor audit.Actor) (*jobs.Job, error) {
+	driver, err := planImport(ctx, &importForm)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := exportCatalog.Get(ctx, importForm.Export)
+	if errors.Is(err, catalog.ErrNotFound) {
+		return nil, fiber.NewError(fiber.StatusNotFound, "The export does not exist")
+	} else if err != nil {
+		return nil, err
+	}
+	if entry.File == "" {
+		return nil, fiber.NewError(fiber.StatusNotFound, "The export did not produce a file")
+	}
+	if entry.Format != "" && entry.Format != dataexport.SQL {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Only SQL exports can be restored; load data exports with the CSV import")
+	}
+	if strings.HasSuffix(entry.File, encryption.Extension) && importForm.Identity == "" && importForm.Passphrase == "" {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "The export is encrypted; enter its passphrase or an identity to decrypt it")
+	}
+	store, err := storage.Get(entry.Storage)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "The "+entry.Storage+" storage of the export is not configured")
+	}
+	info, err := store.Stat(ctx, entry.File)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fiber.NewError(fiber.StatusNotFound, "The export file no longer exists")
+	} else if err != nil {
+		return nil, err
+	}
+
+	record := auditEntry(actor, audit.ActionImport, importForm.ConnectionForm, importForm.Database)
+	record.Params["restoreFrom"] = strconv.FormatInt(entry.ID, 10)
+	record.Params["file"] = entry.File
+	record.Params["storage"] = entry.Storage
+	record.Params["size"] = strconv.FormatInt(info.Size, 10)
+
+	description := fmt.Sprintf("Restore of export %d of %s into %s (%s)", entry.ID, entry.Database, importForm.Database, importForm.Type)
+	return jobManager.Submit("import", description, func(ctx context.Context, job *jobs.Job) (err error) {
+		defer func(started time.Time) { recordAudit(record, started, err) }(time.Now())
+
+		// The file is checked against its checksum before the import
+		// touches the database. Remote files are downloaded first so that
+		// the checked copy is the one imported.
+		var in io.ReadCloser
+		if store.Name() == storage.Local {
+			job.SetTotal(info.Size)
+			if entry.SHA256 != "" {
+				verification, err := manifest.Check(ctx, store, entry.File, entry.SHA256, entry.Size)
+				if err != nil {
+					return fmt.Errorf("failed to verify the export: %w", err)
+				}
+				if !verification.OK {
+					return errors.New("The export was not imported: " + verification.Problem)
+				}
+			}
+			in, err = store.Open(ctx, entry.File)
+		} else {
+			job.SetTotal(2 * info.Size) // downloaded, then imported
+			in, err = downloadExport(ctx, job, store, entry)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("Error reading export %d for restore: %v", entry.ID, err)
+			return err
+		}
+		defer in.Close()
+
+		if err := importSQL(ctx, job, driver, importForm, in); err != nil {
+			if ctx.Err() != nil {
+				log.Printf("Restore of export %d into %s cancelled", entry.ID, importForm.Database)
+			}
+			return err
+		}
+
+		log.Printf("Export %d restored into %s successfully", entry.ID, importForm.Database)
+		job.SetResult("database", importForm.Database)
+		return nil
+	}), nil
+}
+
+// downloadExport copies the file of a remote export into the upload
+// directory and checks it against the checksum recorded in the catalog.
+// The returned file is removed when closed.
+func downloadExport(ctx context.Context, job *jobs.Job, store storage.Storage, entry *catalog.Entry) (io.ReadCloser, error) {
+	in, err := store.Open(ctx, entry.File)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the export: %w", err)
+	}
+	defer in.Close()
+
+	file, err := os.CreateTemp(cfg.UploadDirectory, "restore-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the download of the export: %w", err)
+	}
+	downloaded := &tempFile{file}
+
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(file, hash), job.CountReader(in))
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		downloaded.Close()
+		return nil, fmt.Errorf("failed to download the export: %w", err)
+	}
+
+	if entry.SHA256 != "" {
+		problem := ""
+		switch {
+		case n != entry.Size:
+			problem = "the file size differs from the recorded size; the file is incomplete or was modified"
+		case hex.EncodeToString(hash.Sum(nil)) != entry.SHA256:
+			problem = "the checksum differs from the recorded checksum; the file is corrupted or was modified"
+		}
+		if problem != "" {
+			downloaded.Close()
+			return nil, errors.New("The export was not imported: " + problem)
+		}
+	}
+	return downloaded, nil
+}
+
+// tempFile is a temporary file that is removed when closed
+type tempFile struct {
+	*os.File
+}
+
+func (f *tempFile) Close() error {
+	err := f.File.Close()
+	removeUpload(f.Name())
+	return err
+}
+
+// startCopy starts a job that copies the database of from into the
+// database of to, streaming an SQL export straight into an import without
+// a file in between. The copy is recorded in the audit log as an export
+// and an import, but not in the export catalog.
+func startCopy(ctx context.Context, from models.ExportForm, to models.ImportForm, actor audit.Actor) (*jobs.Job, error) {
+	if from.Format != "" && from.Format != dataexport.SQL {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Copies need the SQL dump format")
+	}
+	plan, err := planExport(from)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	toDriver, err := planImport(ctx, &to)
+	if err != nil {
+		return nil, err
+	}
+	from = plan.form
+
+	exportRecord := auditEntry(actor, audit.ActionExport, from.ConnectionForm, from.Database)
+	for name, value := range exportOptions(from) {
+		exportRecord.Params[name] = value
+	}
+	exportRecord.Params["format"] = dataexport.SQL
+	exportRecord.Params["copyTo"] = to.Database
+	importRecord := auditEntry(actor, audit.ActionImport, to.ConnectionForm, to.Database)
+	importRecord.Params["copyFrom"] = from.Database
+
+	description := fmt.Sprintf("Copy of %s (%s) to %s (%s)", from.Database, from.Type, to.Database, to.Type)
+	return jobManager.Submit("copy", description, func(ctx context.Context, job *jobs.Job) error {
+		started := time.Now()
+
+		// The export writes into the pipe that the import reads. Closing
+		// either end with an error stops the other side.
+		pr, pw := io.Pipe()
+		exported := make(chan error, 1)
+		go func() {
+			err := plan.write(ctx, job, pw)
+			pw.CloseWithError(err)
+			exported <- err
+		}()
+		importErr := toDriver.Import(ctx, importConnection(to), to.Database, job.CountReader(pr), drivers.ImportOptions{
+			Stderr: job.Stderr(),
+		})
+		pr.CloseWithError(importErr)
+		exportErr := <-exported
+
+		recordAudit(exportRecord, started, exportErr)
+		recordAudit(importRecord, started, importErr)
+		switch {
+		case ctx.Err() != nil:
+			log.Printf("Copy of %s to %s cancelled", from.Database, to.Database)
+			return ctx.Err()
+		case exportErr != nil && !errors.Is(exportErr, io.ErrClosedPipe) && (importErr == nil || !errors.Is(exportErr, importErr)):
+			// The export failed first; the import only saw the pipe close
+			return errors.New("Failed to export database: " + describeError(exportErr))
+		case importErr != nil:
+			return errors.New("Failed to import database: " + describeError(importErr))
+		}
+
+		log.Printf("Database '%s' copied to '%s' successfully", from.Database, to.Database)
+		job.SetResult("database", to.Database)
+		return nil
+	}), nil
+}
+
+// verifyExport re-hashes the file of a catalogued export and compares it
+// with its manifest, or with the catalog for exports written before
+// manifests were kept
+func verifyExport(entry *catalog.Entry) (*manifest.Verification, error) {
+	if entry.File == "" {
+		return nil, fiber.NewError(fiber.StatusNotFound, "The export did not produce a file")
+	}
+	store, err := storage.Get(entry.Storage)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	if _, err := store.Stat(ctx, entry.File); errors.Is(err, fs.ErrNotExist) {
+		return nil, fiber.NewError(fiber.StatusNotFound, "The export file no longer exists")
+	}
+
+	verification, err := manifest.Verify(ctx, store, entry.File)
+	if errors.Is(err, manifest.ErrNotFound) && entry.SHA256 != "" {
+		return manifest.Check(ctx, store, entry.File, entry.SHA256, entry.Size)
+	}
+	return verification, err
+}
+
+// sendExport sends the file of the catalogued export named by the :id
+// route parameter. Downloads are recorded in the audit log, including those
+// of missing exports.
+func sendExport(c *fiber.Ctx, actor audit.Actor) error {
+	started := time.Now()
+	record := audit.Entry{Actor: actor, Action: audit.ActionDownload, Params: map[string]string{"export": c.Params("id")}}
+
+	entry, err := catalogEntry(c)
+	if err == nil {
+		record.Type, record.Host, record.Port, record.Database = entry.Type, entry.Host, entry.Port, entry.Database
+		if entry.File == "" {
+			err = fiber.NewError(fiber.StatusNotFound, "The export did not produce a file")
+		}
+	}
+	var store storage.Storage
+	if err == nil {
+		record.Params["file"] = entry.File
+		record.Params["storage"] = entry.Storage
+		store, err = storage.Get(entry.Storage)
+	}
+	if err == nil {
+		err = sendStoredFile(c, store, entry.File, entry.SHA256)
+		if errors.Is(err, fs.ErrNotExist) {
+			err = fiber.NewError(fiber.StatusNotFound, "The export file no longer exists")
+		}
+	}
+	recordAudit(record, started, err)
+	return err
+}
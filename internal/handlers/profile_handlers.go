@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sqlclient-export-import/internal/drivers"
+	"sqlclient-export-import/internal/models"
+	"sqlclient-export-import/internal/profiles"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ProfilesPageHandler lists the connection profiles. API clients get JSON.
+func ProfilesPageHandler(c *fiber.Ctx) error {
+	list, err := profileStore.List(c.UserContext())
+	if err != nil {
+		return profileError(c, err)
+	}
+	if wantsJSON(c) {
+		if list == nil {
+			list = []profiles.Profile{}
+		}
+		return c.JSON(list)
+	}
+
+	return c.Render("profiles", fiber.Map{
+		"Title":    "Connection Profiles",
+		"Profiles": list,
+	})
+}
+
+// NewProfileHandler renders the form for a new connection profile
+func NewProfileHandler(c *fiber.Ctx) error {
+	return c.Render("profile", fiber.Map{
+		"Title": "New Connection Profile",
+	})
+}
+
+// ProfileHandler renders the form to edit a connection profile. API clients
+// get the profile as JSON. Passwords are never sent back.
+func ProfileHandler(c *fiber.Ctx) error {
+	profile, err := profileParam(c)
+	if err != nil {
+		return profileError(c, err)
+	}
+	if wantsJSON(c) {
+		return c.JSON(profile)
+	}
+
+	return c.Render("profile", fiber.Map{
+		"Title": "Connection Profile " + profile.Name,
+		"ID":    profile.ID,
+		"Profile": models.ProfileForm{
+			Name:     profile.Name,
+			Type:     profile.Type,
+			Host:     profile.Host,
+			Port:     profile.Port,
+			Username: profile.Username,
+		},
+	})
+}
+
+// SaveProfileHandler creates a connection profile, or updates the one named
+// by the :id route parameter. An empty password keeps the stored one.
+func SaveProfileHandler(c *fiber.Ctx) error {
+	var form models.ProfileForm
+	if err := c.BodyParser(&form); err != nil {
+		return formError(c, fiber.StatusBadRequest, "profile", fiber.Map{
+			"Title": "Connection Profile",
+			"Error": "Invalid form data: " + err.Error(),
+		})
+	}
+
+	profile := &profiles.Profile{}
+	if c.Params("id") != "" {
+		existing, err := profileParam(c)
+		if err != nil {
+			return profileError(c, err)
+		}
+		profile.ID = existing.ID
+		profile.CreatedAt = existing.CreatedAt
+		if form.Password == "" {
+			form.Password = existing.Password
+		}
+	}
+
+	profile.Name = strings.TrimSpace(form.Name)
+	profile.Type = form.Type
+	profile.Host = form.Host
+	profile.Port = form.Port
+	profile.Username = form.Username
+	profile.Password = form.Password
+
+	err := validateProfile(profile)
+	if err == nil {
+		err = profileStore.Save(c.UserContext(), profile)
+	}
+	if err != nil {
+		if errors.Is(err, profiles.ErrNotFound) {
+			return profileError(c, err)
+		}
+		form.Password = ""
+		return formError(c, fiber.StatusBadRequest, "profile", fiber.Map{
+			"Title":   "Connection Profile",
+			"Error":   err.Error(),
+			"ID":      profile.ID,
+			"Profile": form,
+		})
+	}
+
+	if wantsJSON(c) {
+		return c.JSON(profile)
+	}
+	return c.Redirect("/profiles", fiber.StatusSeeOther)
+}
+
+// DeleteProfileHandler removes a connection profile. Schedules that use it
+// fail until they are given another.
+func DeleteProfileHandler(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return profileError(c, profiles.ErrNotFound)
+	}
+	if err := profileStore.Delete(c.UserContext(), id); err != nil {
+		return profileError(c, err)
+	}
+
+	if wantsJSON(c) {
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+	return c.Redirect("/profiles", fiber.StatusSeeOther)
+}
+
+// ProfileOptions lists the connection profiles for the profile pickers of
+// the forms. It is registered as a template function.
+func ProfileOptions() ([]profiles.Profile, error) {
+	return profileStore.List(context.Background())
+}
+
+// validateProfile checks that a profile names a supported engine and has
+// what the engine needs to connect, filling in the default port
+func validateProfile(profile *profiles.Profile) error {
+	if profile.Name == "" {
+		return errors.New("The profile needs a name")
+	}
+	driver, err := drivers.Get(profile.Type)
+	if err != nil {
+		return errors.New("Unsupported database type")
+	}
+	if missingServerFields(driver, profile.Host, profile.Username) {
+		return errors.New("Please fill in all required fields")
+	}
+	if profile.Port == "" {
+		profile.Port = driver.DefaultPort()
+	}
+	return nil
+}
+
+// useProfile fills in the connection fields of conn from the profile it
+// names, if any
+func useProfile(ctx context.Context, conn *models.ConnectionForm) error {
+	if conn.Profile == 0 {
+		return nil
+	}
+	profile, err := profileStore.Get(ctx, conn.Profile)
+	if err != nil {
+		if errors.Is(err, profiles.ErrNotFound) {
+			return errors.New("The connection profile does not exist")
+		}
+		return err
+	}
+
+	conn.Type = profile.Type
+	conn.Host = profile.Host
+	conn.Port = profile.Port
+	conn.Username = profile.Username
+	conn.Password = profile.Password
+	return nil
+}
+
+// profileParam looks up the profile named by the :id route parameter. A
+// profile whose password no longer decrypts is returned without it, so
+// that a new password can be entered.
+func profileParam(c *fiber.Ctx) (*profiles.Profile, error) {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return nil, profiles.ErrNotFound
+	}
+	profile, err := profileStore.Get(c.UserContext(), id)
+	if errors.Is(err, profiles.ErrDecrypt) {
+		log.Printf("Connection profile %d: %v", id, err)
+		return profile, nil
+	}
+	return profile, err
+}
+
+// profileError reports a failed profile request as JSON to API clients and
+// through the error page to browsers
+func profileError(c *fiber.Ctx, err error) error {
+	fiberErr := fiber.NewError(fiber.StatusInternalServerError, "Failed to read the connection profiles: "+err.Error())
+	if errors.Is(err, profiles.ErrNotFound) {
+		fiberErr = fiber.NewError(fiber.StatusNotFound, "Connection profile not found")
+	}
+
+	if wantsJSON(c) {
+		return c.Status(fiberErr.Code).JSON(fiber.Map{"error": fiberErr.Message})
+	}
+	return fiberErr
+}
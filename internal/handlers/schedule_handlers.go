@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"sqlclient-export-import/internal/audit"
+	"sqlclient-export-import/internal/catalog"
+	"sqlclient-export-import/internal/jobs"
+	"sqlclient-export-import/internal/models"
+	"sqlclient-export-import/internal/retention"
+	"sqlclient-export-import/internal/scheduler"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// SchedulesPageHandler lists the backup schedules with their last and next
+// runs. API clients get JSON.
+func SchedulesPageHandler(c *fiber.Ctx) error {
+	schedules, err := backupScheduler.List(c.UserContext())
+	if err != nil {
+		return scheduleError(c, err)
+	}
+	for i := range schedules {
+		schedules[i].Export.Password = ""
+		schedules[i].Export.Passphrase = ""
+	}
+	if wantsJSON(c) {
+		if schedules == nil {
+			schedules = []scheduler.Schedule{}
+		}
+		return c.JSON(schedules)
+	}
+
+	return c.Render("schedules", fiber.Map{
+		"Title":     "Schedules",
+		"Schedules": schedules,
+	})
+}
+
+// NewScheduleHandler renders the form for a new schedule
+func NewScheduleHandler(c *fiber.Ctx) error {
+	form := models.ScheduleForm{Enabled: true, CatchUp: scheduler.CatchUpSkip}
+	return c.Render("schedule", fiber.Map{
+		"Title":  "New Schedule",
+		"Form":   form,
+		"Export": form.ExportForm,
+	})
+}
+
+// ScheduleHandler renders the form to edit a schedule. API clients get the
+// schedule as JSON. Passwords and passphrases are never sent back.
+func ScheduleHandler(c *fiber.Ctx) error {
+	schedule, err := scheduleParam(c)
+	if err != nil {
+		return scheduleError(c, err)
+	}
+	schedule.Export.Password = ""
+	schedule.Export.Passphrase = ""
+	if wantsJSON(c) {
+		return c.JSON(schedule)
+	}
+
+	form := models.ScheduleForm{
+		ExportForm:  schedule.Export,
+		Name:        schedule.Name,
+		Cron:        schedule.Cron,
+		Enabled:     schedule.Enabled,
+		CatchUp:     schedule.CatchUp,
+		Databases:   strings.Join(schedule.Databases, ", "),
+		KeepLast:    schedule.Retention.KeepLast,
+		KeepDaily:   schedule.Retention.Daily,
+		KeepWeekly:  schedule.Retention.Weekly,
+		KeepMonthly: schedule.Retention.Monthly,
+		MaxAgeDays:  int(schedule.Retention.MaxAge / (24 * time.Hour)),
+	}
+	return c.Render("schedule", fiber.Map{
+		"Title":    "Schedule " + schedule.Name,
+		"Schedule": schedule,
+		"Form":     form,
+		"Export":   form.ExportForm,
+	})
+}
+
+// SaveScheduleHandler creates a schedule, or updates the one named by the
+// :id route parameter. An empty password keeps the stored one.
+func SaveScheduleHandler(c *fiber.Ctx) error {
+	var form models.ScheduleForm
+	if err := c.BodyParser(&form); err != nil {
+		return formError(c, fiber.StatusBadRequest, "schedule", fiber.Map{
+			"Title":  "Schedule",
+			"Error":  "Invalid form data: " + err.Error(),
+			"Form":   form,
+			"Export": form.ExportForm,
+		})
+	}
+
+	schedule := &scheduler.Schedule{}
+	if c.Params("id") != "" {
+		existing, err := scheduleParam(c)
+		if err != nil {
+			return scheduleError(c, err)
+		}
+		schedule.ID = existing.ID
+		if form.Password == "" && form.Profile == 0 {
+			form.Password = existing.Export.Password
+		}
+		// The passphrase is kept until recipients replace it
+		if form.Passphrase == "" && strings.TrimSpace(form.Recipients) == "" {
+			form.Passphrase = existing.Export.Passphrase
+		}
+	}
+
+	schedule.Name = strings.TrimSpace(form.Name)
+	schedule.Cron = strings.TrimSpace(form.Cron)
+	schedule.Enabled = form.Enabled
+	schedule.CatchUp = form.CatchUp
+	if schedule.CatchUp == "" {
+		schedule.CatchUp = scheduler.CatchUpSkip
+	}
+	schedule.Databases = splitList(form.Databases)
+	schedule.Export = form.ExportForm
+	schedule.Export.Database = ""
+	if form.Profile != 0 {
+		// The connection is read from the profile at every run
+		schedule.Export.ConnectionForm = models.ConnectionForm{Profile: form.Profile}
+	}
+	schedule.Retention = retention.Policy{
+		MaxAge:   time.Duration(form.MaxAgeDays) * 24 * time.Hour,
+		KeepLast: form.KeepLast,
+		Daily:    form.KeepDaily,
+		Weekly:   form.KeepWeekly,
+		Monthly:  form.KeepMonthly,
+	}
+
+	err := schedule.Validate()
+	for _, database := range schedule.Databases {
+		if err != nil {
+			break
+		}
+		exportForm := schedule.Export
+		exportForm.Database = database
+		_, err = planExport(exportForm)
+	}
+	if err == nil {
+		err = backupScheduler.Save(c.UserContext(), schedule)
+	}
+	if err != nil {
+		if errors.Is(err, scheduler.ErrNotFound) {
+			return scheduleError(c, err)
+		}
+		form.Password = ""
+		form.Passphrase = ""
+		return formError(c, fiber.StatusBadRequest, "schedule", fiber.Map{
+			"Title":    "Schedule",
+			"Error":    err.Error(),
+			"Schedule": schedule,
+			"Form":     form,
+			"Export":   form.ExportForm,
+		})
+	}
+
+	if wantsJSON(c) {
+		schedule.Export.Password = ""
+		schedule.Export.Passphrase = ""
+		return c.JSON(schedule)
+	}
+	return c.Redirect("/schedules", fiber.StatusSeeOther)
+}
+
+// RunScheduleHandler runs a schedule straight away, whether or not it is
+// enabled
+func RunScheduleHandler(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return scheduleError(c, scheduler.ErrNotFound)
+	}
+	if err := backupScheduler.RunNow(c.UserContext(), id); err != nil {
+		return scheduleError(c, err)
+	}
+
+	if wantsJSON(c) {
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"id": id})
+	}
+	return c.Redirect("/schedules", fiber.StatusSeeOther)
+}
+
+// DeleteScheduleHandler removes a schedule. The exports it wrote are kept.
+func DeleteScheduleHandler(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return scheduleError(c, scheduler.ErrNotFound)
+	}
+	if err := backupScheduler.Delete(c.UserContext(), id); err != nil {
+		return scheduleError(c, err)
+	}
+
+	if wantsJSON(c) {
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+	return c.Redirect("/schedules", fiber.StatusSeeOther)
+}
+
+// runSchedule exports every database of a schedule, waits for the exports
+// to finish and applies the schedule's retention policy to its exports
+func runSchedule(ctx context.Context, schedule scheduler.Schedule) ([]string, error) {
+	triggeredBy := schedule.TriggeredBy()
+
+	var started []*jobs.Job
+	var failures []string
+	for _, database := range schedule.Databases {
+		exportForm := schedule.Export
+		exportForm.Database = database
+		job, err := startExport(exportForm, audit.Actor{Name: triggeredBy})
+		if err != nil {
+			failures = append(failures, database+": "+err.Error())
+			continue
+		}
+		started = append(started, job)
+	}
+
+	ids := make([]string, len(started))
+	for i, job := range started {
+		ids[i] = job.ID
+		select {
+		case <-job.Done():
+		case <-ctx.Done():
+			job.Cancel()
+			<-job.Done()
+		}
+		if snapshot := job.Snapshot(); snapshot.Status != jobs.StatusSucceeded {
+			failures = append(failures, snapshot.Description+": "+snapshot.Error)
+		}
+	}
+
+	if err := retention.Apply(ctx, exportCatalog, schedule.Retention, catalog.Filter{TriggeredBy: triggeredBy}); err != nil {
+		failures = append(failures, "retention: "+err.Error())
+	}
+	if len(failures) > 0 {
+		return ids, errors.New(strings.Join(failures, "; "))
+	}
+	return ids, nil
+}
+
+// scheduleParam looks up the schedule named by the :id route parameter
+func scheduleParam(c *fiber.Ctx) (*scheduler.Schedule, error) {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return nil, scheduler.ErrNotFound
+	}
+	return backupScheduler.Get(c.UserContext(), id)
+}
+
+// scheduleError reports a failed schedule request as JSON to API clients
+// and through the error page to browsers
+func scheduleError(c *fiber.Ctx, err error) error {
+	var fiberErr *fiber.Error
+	switch {
+	case errors.Is(err, scheduler.ErrNotFound):
+		fiberErr = fiber.NewError(fiber.StatusNotFound, "Schedule not found")
+	case errors.Is(err, scheduler.ErrRunning):
+		fiberErr = fiber.NewError(fiber.StatusConflict, "The schedule is already running")
+	default:
+		fiberErr = fiber.NewError(fiber.StatusInternalServerError, "Failed to read the schedules: "+err.Error())
+	}
+
+	if wantsJSON(c) {
+		return c.Status(fiberErr.Code).JSON(fiber.Map{"error": fiberErr.Message})
+	}
+	return fiberErr
+}
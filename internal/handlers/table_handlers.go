@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"errors"
+	"sqlclient-export-import/internal/drivers"
+	"sqlclient-export-import/internal/models"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// exportTable is a row of the table picker on the export page
+type exportTable struct {
+	Name     string
+	Selected bool
+	Where    string
+}
+
+// ExportTablesHandler lists the tables of the database named on the export
+// form, re-rendering the form with a table picker. API clients get the
+// table names as JSON.
+func ExportTablesHandler(c *fiber.Ctx) error {
+	var exportForm models.ExportForm
+	if err := c.BodyParser(&exportForm); err != nil {
+		return formError(c, fiber.StatusBadRequest, "export", fiber.Map{
+			"Title": "Export Database",
+			"Error": "Invalid form data",
+		})
+	}
+
+	if err := useProfile(c.UserContext(), &exportForm.ConnectionForm); err != nil {
+		return formError(c, fiber.StatusBadRequest, "export", fiber.Map{
+			"Title":  "Export Database",
+			"Error":  err.Error(),
+			"Export": exportForm,
+		})
+	}
+
+	driver, err := drivers.Get(exportForm.Type)
+	if err != nil {
+		return formError(c, fiber.StatusBadRequest, "export", fiber.Map{
+			"Title":  "Export Database",
+			"Error":  "Unsupported database type",
+			"Export": exportForm,
+		})
+	}
+	if exportForm.Database == "" || missingServerFields(driver, exportForm.Host, exportForm.Username) {
+		return formError(c, fiber.StatusBadRequest, "export", fiber.Map{
+			"Title":  "Export Database",
+			"Error":  "Please fill in the connection and database to list its tables",
+			"Export": exportForm,
+		})
+	}
+	if err := driver.ValidateName(exportForm.Database); err != nil {
+		return formError(c, fiber.StatusBadRequest, "export", fiber.Map{
+			"Title":  "Export Database",
+			"Error":  err.Error(),
+			"Export": exportForm,
+		})
+	}
+	if exportForm.Port == "" {
+		exportForm.Port = driver.DefaultPort()
+	}
+
+	reader, ok := driver.(drivers.TableReader)
+	if !ok {
+		return formError(c, fiber.StatusBadRequest, "export", fiber.Map{
+			"Title":  "Export Database",
+			"Error":  "Listing tables is not supported for " + exportForm.Type,
+			"Export": exportForm,
+		})
+	}
+
+	db, err := reader.OpenDB(c.UserContext(), exportConnection(exportForm), exportForm.Database)
+	if err != nil {
+		return formError(c, fiber.StatusInternalServerError, "export", fiber.Map{
+			"Title":  "Export Database",
+			"Error":  "Failed to connect to the database: " + err.Error(),
+			"Export": exportForm,
+		})
+	}
+	defer db.Close()
+
+	tables, err := reader.Tables(c.UserContext(), db)
+	if err != nil {
+		return formError(c, fiber.StatusInternalServerError, "export", fiber.Map{
+			"Title":  "Export Database",
+			"Error":  "Failed to list tables: " + err.Error(),
+			"Export": exportForm,
+		})
+	}
+
+	names := make([]string, len(tables))
+	for i, table := range tables {
+		names[i] = table.Name
+	}
+	if wantsJSON(c) {
+		return c.JSON(fiber.Map{"tables": names})
+	}
+
+	// Keep the choices already made when the list is reloaded
+	selected := make(map[string]bool, len(exportForm.Tables))
+	for _, name := range exportForm.Tables {
+		selected[name] = true
+	}
+	where := make(map[string]string)
+	for i, name := range exportForm.WhereTables {
+		if i < len(exportForm.WhereConditions) {
+			where[name] = exportForm.WhereConditions[i]
+		}
+	}
+	picker := make([]exportTable, len(names))
+	for i, name := range names {
+		picker[i] = exportTable{Name: name, Selected: selected[name], Where: where[name]}
+	}
+
+	return c.Render("export", fiber.Map{
+		"Title":  "Export Database",
+		"Export": exportForm,
+		"Tables": picker,
+	})
+}
+
+// tableFilter builds the table selection of an export from the tables
+// ticked in the picker, the include and exclude patterns and the row filters
+func tableFilter(exportForm models.ExportForm) (drivers.TableFilter, error) {
+	filter := drivers.TableFilter{
+		Include: append(append([]string{}, exportForm.Tables...), splitList(exportForm.Include)...),
+		Exclude: splitList(exportForm.Exclude),
+	}
+
+	if len(exportForm.WhereTables) != len(exportForm.WhereConditions) {
+		return filter, errors.New("every row filter needs a table and a condition")
+	}
+	for i, table := range exportForm.WhereTables {
+		condition := strings.TrimSpace(exportForm.WhereConditions[i])
+		if condition == "" {
+			continue
+		}
+		if filter.Where == nil {
+			filter.Where = make(map[string]string)
+		}
+		filter.Where[table] = condition
+	}
+
+	return filter, filter.Validate()
+}
+
+// splitList splits a comma or newline separated list, dropping empty items
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"errors"
+	"sqlclient-export-import/internal/auth"
+	"sqlclient-export-import/internal/models"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TokensPageHandler lists the API tokens of the logged in user. API
+// clients get JSON.
+func TokensPageHandler(c *fiber.Ctx) error {
+	return renderTokens(c, fiber.StatusOK, fiber.Map{})
+}
+
+// CreateTokenHandler creates an API token for the logged in user. The
+// secret is shown once, on the page that follows or in the JSON response.
+func CreateTokenHandler(c *fiber.Ctx) error {
+	var form models.TokenForm
+	if err := c.BodyParser(&form); err != nil {
+		return renderTokens(c, fiber.StatusBadRequest, fiber.Map{"Error": "Invalid form data: " + err.Error()})
+	}
+	form.Name = strings.TrimSpace(form.Name)
+	if form.Name == "" {
+		return renderTokens(c, fiber.StatusBadRequest, fiber.Map{"Error": "The token needs a name"})
+	}
+
+	secret, token, err := userStore.CreateToken(c.UserContext(), currentUser(c).ID, form.Name)
+	if err != nil {
+		return tokenError(c, err)
+	}
+
+	if wantsJSON(c) {
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{"token": secret, "id": token.ID, "name": token.Name})
+	}
+	return renderTokens(c, fiber.StatusCreated, fiber.Map{"Secret": secret, "SecretName": token.Name})
+}
+
+// DeleteTokenHandler revokes an API token of the logged in user
+func DeleteTokenHandler(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return tokenError(c, auth.ErrNotFound)
+	}
+	if err := userStore.DeleteToken(c.UserContext(), currentUser(c).ID, id); err != nil {
+		return tokenError(c, err)
+	}
+
+	if wantsJSON(c) {
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+	return c.Redirect("/tokens", fiber.StatusSeeOther)
+}
+
+// renderTokens renders the tokens page with data, or sends the tokens as
+// JSON to API clients, along with the error in data if any
+func renderTokens(c *fiber.Ctx, status int, data fiber.Map) error {
+	if wantsJSON(c) && data["Error"] != nil {
+		return c.Status(status).JSON(fiber.Map{"error": data["Error"]})
+	}
+	tokens, err := userStore.Tokens(c.UserContext(), currentUser(c).ID)
+	if err != nil {
+		return tokenError(c, err)
+	}
+	if wantsJSON(c) {
+		if tokens == nil {
+			tokens = []auth.Token{}
+		}
+		return c.JSON(tokens)
+	}
+
+	data["Title"] = "API Tokens"
+	data["Tokens"] = tokens
+	return c.Status(status).Render("tokens", data)
+}
+
+// tokenError reports a failed token request as JSON to API clients and
+// through the error page to browsers
+func tokenError(c *fiber.Ctx, err error) error {
+	var fiberErr *fiber.Error
+	if errors.Is(err, auth.ErrNotFound) {
+		fiberErr = fiber.NewError(fiber.StatusNotFound, "Token not found")
+	} else {
+		fiberErr = fiber.NewError(fiber.StatusInternalServerError, "Failed to read the API tokens: "+err.Error())
+	}
+
+	if wantsJSON(c) {
+		return c.Status(fiberErr.Code).JSON(fiber.Map{"error": fiberErr.Message})
+	}
+	return fiberErr
+}
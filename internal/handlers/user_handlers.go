@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"errors"
+	"sqlclient-export-import/internal/auth"
+	"sqlclient-export-import/internal/models"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// minPasswordLength is the shortest password accepted for users
+const minPasswordLength = 8
+
+// UsersPageHandler lists the users of the web UI. API clients get JSON.
+func UsersPageHandler(c *fiber.Ctx) error {
+	list, err := userStore.List(c.UserContext())
+	if err != nil {
+		return userError(c, err)
+	}
+	if wantsJSON(c) {
+		if list == nil {
+			list = []auth.User{}
+		}
+		return c.JSON(list)
+	}
+
+	return c.Render("users", fiber.Map{
+		"Title": "Users",
+		"Users": list,
+	})
+}
+
+// NewUserHandler renders the form for a new user
+func NewUserHandler(c *fiber.Ctx) error {
+	return c.Render("user", fiber.Map{
+		"Title": "New User",
+		"User":  models.UserForm{Role: string(auth.RoleViewer)},
+		"Roles": auth.Roles,
+	})
+}
+
+// UserHandler renders the form to edit a user. API clients get the user as
+// JSON.
+func UserHandler(c *fiber.Ctx) error {
+	user, err := userParam(c)
+	if err != nil {
+		return userError(c, err)
+	}
+	if wantsJSON(c) {
+		return c.JSON(user)
+	}
+
+	return c.Render("user", fiber.Map{
+		"Title": "User " + user.Username,
+		"ID":    user.ID,
+		"User":  models.UserForm{Username: user.Username, Role: string(user.Role)},
+		"Roles": auth.Roles,
+	})
+}
+
+// SaveUserHandler creates a user, or updates the one named by the :id
+// route parameter. An empty password keeps the stored one; a new password
+// logs the user out everywhere.
+func SaveUserHandler(c *fiber.Ctx) error {
+	var form models.UserForm
+	if err := c.BodyParser(&form); err != nil {
+		return formError(c, fiber.StatusBadRequest, "user", fiber.Map{
+			"Title": "User",
+			"Error": "Invalid form data: " + err.Error(),
+			"Roles": auth.Roles,
+		})
+	}
+
+	user := &auth.User{}
+	if c.Params("id") != "" {
+		existing, err := userParam(c)
+		if err != nil {
+			return userError(c, err)
+		}
+		user = existing
+	}
+	user.Username = strings.TrimSpace(form.Username)
+	user.Role = auth.Role(form.Role)
+
+	err := validateUser(user, form.Password, user.ID == 0)
+	if err == nil {
+		err = userStore.Save(c.UserContext(), user, form.Password)
+	}
+	if err != nil {
+		if errors.Is(err, auth.ErrNotFound) {
+			return userError(c, err)
+		}
+		form.Password = ""
+		return formError(c, fiber.StatusBadRequest, "user", fiber.Map{
+			"Title": "User",
+			"Error": err.Error(),
+			"ID":    user.ID,
+			"User":  form,
+			"Roles": auth.Roles,
+		})
+	}
+
+	if wantsJSON(c) {
+		return c.JSON(user)
+	}
+	return c.Redirect("/users", fiber.StatusSeeOther)
+}
+
+// DeleteUserHandler removes a user and ends their sessions. The last admin
+// cannot be removed.
+func DeleteUserHandler(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return userError(c, auth.ErrNotFound)
+	}
+	if err := userStore.Delete(c.UserContext(), id); err != nil {
+		return userError(c, err)
+	}
+
+	if wantsJSON(c) {
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+	return c.Redirect("/users", fiber.StatusSeeOther)
+}
+
+// validateUser checks the name and role of a user and the password it is
+// saved with, which new users need
+func validateUser(user *auth.User, password string, isNew bool) error {
+	if user.Username == "" {
+		return errors.New("The user needs a name")
+	}
+	if strings.IndexFunc(user.Username, unicode.IsSpace) >= 0 {
+		return errors.New("Usernames cannot contain spaces")
+	}
+	if !user.Role.Valid() {
+		return errors.New("Unknown role: " + string(user.Role))
+	}
+	if isNew && password == "" {
+		return errors.New("New users need a password")
+	}
+	if password != "" && len(password) < minPasswordLength {
+		return errors.New("Passwords need at least " + strconv.Itoa(minPasswordLength) + " characters")
+	}
+	return nil
+}
+
+// userParam looks up the user named by the :id route parameter
+func userParam(c *fiber.Ctx) (*auth.User, error) {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return nil, auth.ErrNotFound
+	}
+	return userStore.Get(c.UserContext(), id)
+}
+
+// userError reports a failed user request as JSON to API clients and
+// through the error page to browsers
+func userError(c *fiber.Ctx, err error) error {
+	var fiberErr *fiber.Error
+	switch {
+	case errors.Is(err, auth.ErrNotFound):
+		fiberErr = fiber.NewError(fiber.StatusNotFound, "User not found")
+	case errors.Is(err, auth.ErrLastAdmin):
+		fiberErr = fiber.NewError(fiber.StatusConflict, "The last admin cannot be removed")
+	default:
+		fiberErr = fiber.NewError(fiber.StatusInternalServerError, "Failed to read the users: "+err.Error())
+	}
+
+	if wantsJSON(c) {
+		return c.Status(fiberErr.Code).JSON(fiber.Map{"error": fiberErr.Message})
+	}
+	return fiberErr
+}
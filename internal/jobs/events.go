@@ -0,0 +1,118 @@
+package jobs
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Event types streamed to subscribers
+const (
+	EventStatus = "status"
+	EventStderr = "stderr"
+	EventTable  = "table"
+)
+
+// subscriberBuffer is how many events a slow subscriber may fall behind
+// before further events are dropped for it
+const subscriberBuffer = 256
+
+// maxLinePrefix is how much of each dump line is kept when looking for
+// table names; INSERT lines can be megabytes long
+const maxLinePrefix = 512
+
+// tableLine matches the lines of mysqldump, pg_dump, sqlite3 and native
+// dumps that start a new table
+var tableLine = regexp.MustCompile("^(?:--\\s+(?:Table structure for table|Dumping data for table)\\s+|CREATE TABLE\\s+(?:IF NOT EXISTS\\s+)?|INSERT INTO\\s+|COPY\\s+)([^\\s(]+)")
+
+// Event is a notification about a running job
+type Event struct {
+	Type string
+	Data string
+}
+
+// Subscribe returns a channel receiving the job's events and a function
+// that must be called to stop the subscription
+func (j *Job) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+
+	j.mu.Lock()
+	if j.subscribers == nil {
+		j.subscribers = make(map[chan Event]struct{})
+	}
+	j.subscribers[ch] = struct{}{}
+	j.mu.Unlock()
+
+	return ch, func() {
+		j.mu.Lock()
+		delete(j.subscribers, ch)
+		j.mu.Unlock()
+	}
+}
+
+// publish delivers e to every subscriber without blocking the job
+func (j *Job) publish(e Event) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for ch := range j.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// SetTable records the table currently being processed
+func (j *Job) SetTable(name string) {
+	j.mu.Lock()
+	changed := j.table != name
+	j.table = name
+	j.mu.Unlock()
+
+	if changed {
+		j.publish(Event{Type: EventTable, Data: name})
+	}
+}
+
+// WatchTables wraps w so that the SQL dump written through it updates the
+// job's current table
+func (j *Job) WatchTables(w io.Writer) io.Writer {
+	return &tableWatcher{w: w, job: j}
+}
+
+type tableWatcher struct {
+	w    io.Writer
+	job  *Job
+	line []byte
+}
+
+func (t *tableWatcher) Write(p []byte) (int, error) {
+	n, err := t.w.Write(p)
+	t.scan(p[:n])
+	return n, err
+}
+
+// scan collects the start of every line in p and inspects complete lines
+func (t *tableWatcher) scan(p []byte) {
+	for len(p) > 0 {
+		chunk := p
+		i := bytes.IndexByte(p, '\n')
+		if i >= 0 {
+			chunk = p[:i]
+		}
+		if room := maxLinePrefix - len(t.line); room > 0 {
+			t.line = append(t.line, chunk[:min(room, len(chunk))]...)
+		}
+		if i < 0 {
+			return
+		}
+
+		if m := tableLine.FindSubmatch(t.line); m != nil {
+			t.job.SetTable(strings.NewReplacer("`", "", `"`, "", "[", "", "]", "").Replace(string(m[1])))
+		}
+		t.line = t.line[:0]
+		p = p[i+1:]
+	}
+}
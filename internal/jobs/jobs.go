@@ -0,0 +1,385 @@
+// Package jobs runs long export and import operations in the background and
+// tracks their progress so the UI and API can poll for it or cancel it.
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Status is the lifecycle state of a job
+type Status string
+
+// Job statuses
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+// maxStderr caps how much client output is kept per job
+const maxStderr = 64 * 1024
+
+// retention is how long finished jobs stay available for polling
+const retention = 24 * time.Hour
+
+// Func is the work performed by a job. It must stop once ctx is done.
+type Func func(ctx context.Context, job *Job) error
+
+// Job is a single background operation
+type Job struct {
+	ID          string
+	Kind        string
+	Description string
+
+	bytes atomic.Int64
+	total atomic.Int64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu         sync.Mutex
+	status     Status
+	createdAt  time.Time
+	startedAt  time.Time
+	finishedAt time.Time
+	stderr     []byte
+	partial    []byte
+	table      string
+	err        string
+	result     map[string]string
+
+	subscribers map[chan Event]struct{}
+}
+
+// Snapshot is a point-in-time copy of a job's state, safe to render or
+// encode as JSON
+type Snapshot struct {
+	ID             string            `json:"id"`
+	Kind           string            `json:"kind"`
+	Description    string            `json:"description"`
+	Status         Status            `json:"status"`
+	BytesProcessed int64             `json:"bytesProcessed"`
+	TotalBytes     int64             `json:"totalBytes,omitempty"`
+	CreatedAt      time.Time         `json:"createdAt"`
+	StartedAt      *time.Time        `json:"startedAt,omitempty"`
+	FinishedAt     *time.Time        `json:"finishedAt,omitempty"`
+	ElapsedSeconds float64           `json:"elapsedSeconds"`
+	Table          string            `json:"table,omitempty"`
+	Stderr         string            `json:"stderr"`
+	Error          string            `json:"error,omitempty"`
+	Result         map[string]string `json:"result,omitempty"`
+}
+
+// Done reports whether the job has reached a final status
+func (s Snapshot) Done() bool {
+	return s.Status == StatusSucceeded || s.Status == StatusFailed || s.Status == StatusCancelled
+}
+
+// Elapsed returns the job's running time rounded for display
+func (s Snapshot) Elapsed() time.Duration {
+	return time.Duration(s.ElapsedSeconds * float64(time.Second)).Round(time.Second)
+}
+
+// Snapshot returns a copy of the job's current state
+func (j *Job) Snapshot() Snapshot {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	s := Snapshot{
+		ID:             j.ID,
+		Kind:           j.Kind,
+		Description:    j.Description,
+		Status:         j.status,
+		BytesProcessed: j.bytes.Load(),
+		TotalBytes:     j.total.Load(),
+		CreatedAt:      j.createdAt,
+		Table:          j.table,
+		Stderr:         string(j.stderr),
+		Error:          j.err,
+	}
+	if !j.startedAt.IsZero() {
+		started := j.startedAt
+		s.StartedAt = &started
+
+		end := time.Now()
+		if !j.finishedAt.IsZero() {
+			finished := j.finishedAt
+			s.FinishedAt = &finished
+			end = finished
+		}
+		s.ElapsedSeconds = end.Sub(started).Seconds()
+	}
+	if len(j.result) > 0 {
+		s.Result = make(map[string]string, len(j.result))
+		for k, v := range j.result {
+			s.Result[k] = v
+		}
+	}
+	return s
+}
+
+// Done returns a channel that is closed once the job reaches a final status
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// Cancel stops the job, killing any client it has started. It returns false
+// when the job has already finished.
+func (j *Job) Cancel() bool {
+	if j.Snapshot().Done() {
+		return false
+	}
+	j.cancel()
+	return true
+}
+
+// AddBytes records n more bytes processed
+func (j *Job) AddBytes(n int64) {
+	j.bytes.Add(n)
+}
+
+// SetTotal records the expected number of bytes, when known
+func (j *Job) SetTotal(n int64) {
+	j.total.Store(n)
+}
+
+// SetResult attaches a key/value pair to the job's result, such as the
+// name of the file an export produced
+func (j *Job) SetResult(key, value string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.result == nil {
+		j.result = make(map[string]string)
+	}
+	j.result[key] = value
+}
+
+// Stderr returns a writer that captures client diagnostic output
+func (j *Job) Stderr() io.Writer {
+	return stderrWriter{j}
+}
+
+// CountWriter wraps w so that every byte written is added to the job's progress
+func (j *Job) CountWriter(w io.Writer) io.Writer {
+	return &countingWriter{w: w, job: j}
+}
+
+// CountReader wraps r so that every byte read is added to the job's progress
+func (j *Job) CountReader(r io.Reader) io.Reader {
+	return &countingReader{r: r, job: j}
+}
+
+func (j *Job) setStatus(status Status) {
+	j.mu.Lock()
+	j.status = status
+	switch status {
+	case StatusRunning:
+		j.startedAt = time.Now()
+	case StatusSucceeded, StatusFailed, StatusCancelled:
+		j.finishedAt = time.Now()
+	}
+	j.mu.Unlock()
+
+	j.publish(Event{Type: EventStatus, Data: string(status)})
+}
+
+func (j *Job) fail(err error) {
+	j.mu.Lock()
+	j.err = err.Error()
+	j.mu.Unlock()
+
+	j.setStatus(StatusFailed)
+}
+
+func (j *Job) cancelled() {
+	j.mu.Lock()
+	j.err = "Cancelled before it finished"
+	j.mu.Unlock()
+
+	j.setStatus(StatusCancelled)
+}
+
+type stderrWriter struct {
+	job *Job
+}
+
+func (w stderrWriter) Write(p []byte) (int, error) {
+	j := w.job
+	j.mu.Lock()
+	j.stderr = append(j.stderr, p...)
+	if len(j.stderr) > maxStderr {
+		j.stderr = j.stderr[len(j.stderr)-maxStderr:]
+	}
+
+	// Complete lines are streamed to subscribers as they arrive
+	j.partial = append(j.partial, p...)
+	var lines []string
+	for {
+		i := bytes.IndexByte(j.partial, '\n')
+		if i < 0 {
+			break
+		}
+		lines = append(lines, string(j.partial[:i]))
+		j.partial = j.partial[i+1:]
+	}
+	if len(j.partial) > maxStderr {
+		j.partial = j.partial[len(j.partial)-maxStderr:]
+	}
+	j.mu.Unlock()
+
+	for _, line := range lines {
+		j.publish(Event{Type: EventStderr, Data: line})
+	}
+	return len(p), nil
+}
+
+type countingWriter struct {
+	w   io.Writer
+	job *Job
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.job.AddBytes(int64(n))
+	return n, err
+}
+
+type countingReader struct {
+	r   io.Reader
+	job *Job
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.job.AddBytes(int64(n))
+	return n, err
+}
+
+// Manager runs jobs on a bounded number of workers
+type Manager struct {
+	mu    sync.RWMutex
+	jobs  map[string]*Job
+	slots chan struct{}
+}
+
+// NewManager returns a manager that runs at most workers jobs at a time
+func NewManager(workers int) *Manager {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Manager{
+		jobs:  make(map[string]*Job),
+		slots: make(chan struct{}, workers),
+	}
+}
+
+// Submit queues run as a new job and returns immediately
+func (m *Manager) Submit(kind, description string, run Func) *Job {
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		ID:          newID(),
+		Kind:        kind,
+		Description: description,
+		ctx:         ctx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+		status:      StatusQueued,
+		createdAt:   time.Now(),
+	}
+
+	m.mu.Lock()
+	m.prune()
+	m.jobs[job.ID] = job
+	m.mu.Unlock()
+
+	go m.run(job, run)
+	return job
+}
+
+// Get returns the job with the given ID
+func (m *Manager) Get(id string) (*Job, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	job, ok := m.jobs[id]
+	return job, ok
+}
+
+// List returns snapshots of all known jobs, newest first
+func (m *Manager) List() []Snapshot {
+	m.mu.RLock()
+	snapshots := make([]Snapshot, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		snapshots = append(snapshots, job.Snapshot())
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(snapshots, func(i, k int) bool {
+		return snapshots[i].CreatedAt.After(snapshots[k].CreatedAt)
+	})
+	return snapshots
+}
+
+func (m *Manager) run(job *Job, run Func) {
+	defer close(job.done)
+	defer job.cancel()
+
+	// A job cancelled while queued never takes a worker
+	select {
+	case m.slots <- struct{}{}:
+	case <-job.ctx.Done():
+		job.cancelled()
+		return
+	}
+	defer func() { <-m.slots }()
+
+	defer func() {
+		if r := recover(); r != nil {
+			job.fail(fmt.Errorf("job panicked: %v", r))
+		}
+	}()
+
+	job.setStatus(StatusRunning)
+	if err := run(job.ctx, job); err != nil {
+		if job.ctx.Err() != nil {
+			job.cancelled()
+			return
+		}
+		job.fail(err)
+		return
+	}
+	job.setStatus(StatusSucceeded)
+}
+
+// prune forgets finished jobs older than the retention period. The caller
+// must hold m.mu.
+func (m *Manager) prune() {
+	cutoff := time.Now().Add(-retention)
+	for id, job := range m.jobs {
+		s := job.Snapshot()
+		if s.Done() && s.FinishedAt != nil && s.FinishedAt.Before(cutoff) {
+			delete(m.jobs, id)
+		}
+	}
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,135 @@
+// Package manifest writes the JSON manifests kept next to export files, in
+// the same storage, which record where a dump came from and its SHA-256
+// checksum, and checks files against them.
+package manifest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"sqlclient-export-import/internal/storage"
+	"time"
+)
+
+// Suffix is appended to the name of an export file to name its manifest
+const Suffix = ".manifest.json"
+
+// version is the version of the manifest format
+const version = 1
+
+// ErrNotFound is returned when a file has no manifest
+var ErrNotFound = errors.New("the export has no manifest")
+
+// Manifest describes an export file
+type Manifest struct {
+	Version       int               `json:"manifestVersion"`
+	File          string            `json:"file"`
+	ExportID      int64             `json:"exportId,omitempty"`
+	Type          string            `json:"type"` // source engine
+	ServerVersion string            `json:"serverVersion,omitempty"`
+	Host          string            `json:"host,omitempty"`
+	Port          string            `json:"port,omitempty"`
+	Database      string            `json:"database"`
+	Format        string            `json:"format"`
+	Options       map[string]string `json:"options,omitempty"`
+	ToolVersion   string            `json:"toolVersion,omitempty"`
+	Size          int64             `json:"size"`
+	SHA256        string            `json:"sha256"`
+	StartedAt     time.Time         `json:"startedAt"`
+	FinishedAt    time.Time         `json:"finishedAt"`
+	TriggeredBy   string            `json:"triggeredBy,omitempty"`
+}
+
+// Verification is the outcome of checking a file against its checksum
+type Verification struct {
+	File           string    `json:"file"`
+	OK             bool      `json:"ok"`
+	Problem        string    `json:"problem,omitempty"` // why the file does not match
+	Size           int64     `json:"size"`
+	ExpectedSize   int64     `json:"expectedSize"`
+	SHA256         string    `json:"sha256"`
+	ExpectedSHA256 string    `json:"expectedSha256"`
+	VerifiedAt     time.Time `json:"verifiedAt"`
+}
+
+// Path returns the name of the manifest of the export file name
+func Path(name string) string {
+	return name + Suffix
+}
+
+// Write writes m as the manifest of the export file name in store
+func Write(ctx context.Context, store storage.Storage, name string, m *Manifest) error {
+	m.Version = version
+	m.File = name
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return storage.WriteFile(ctx, store, Path(name), append(data, '\n'))
+}
+
+// Read reads the manifest of the export file name in store, returning
+// ErrNotFound when there is none
+func Read(ctx context.Context, store storage.Storage, name string) (*Manifest, error) {
+	data, err := storage.ReadAll(ctx, store, Path(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, errors.New("invalid manifest: " + err.Error())
+	}
+	return &m, nil
+}
+
+// Verify re-hashes the export file name in store and compares it with its
+// manifest
+func Verify(ctx context.Context, store storage.Storage, name string) (*Verification, error) {
+	m, err := Read(ctx, store, name)
+	if err != nil {
+		return nil, err
+	}
+	return Check(ctx, store, name, m.SHA256, m.Size)
+}
+
+// Check re-hashes the file name in store and compares it with the expected
+// checksum and size. Mismatches are reported in the verification; errors
+// are returned when the file cannot be read.
+func Check(ctx context.Context, store storage.Storage, name, sha256Hex string, size int64) (*Verification, error) {
+	file, err := store.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	n, err := io.Copy(hash, file)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &Verification{
+		File:           name,
+		Size:           n,
+		ExpectedSize:   size,
+		SHA256:         hex.EncodeToString(hash.Sum(nil)),
+		ExpectedSHA256: sha256Hex,
+		VerifiedAt:     time.Now(),
+	}
+	switch {
+	case n != size:
+		v.Problem = "the file size differs from the recorded size; the file is incomplete or was modified"
+	case v.SHA256 != sha256Hex:
+		v.Problem = "the checksum differs from the recorded checksum; the file is corrupted or was modified"
+	default:
+		v.OK = true
+	}
+	return v, nil
+}
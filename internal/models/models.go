@@ -2,26 +2,96 @@ package models
 
 // ExportForm represents the form data for exporting a database
 type ExportForm struct {
-	Type     string `form:"type"`
-	Host     string `form:"host"`
-	Port     string `form:"port"`
-	Database string `form:"database"`
-	Username string `form:"username"`
-	Password string `form:"password"`
+	ConnectionForm
+
+	Database    string   `form:"database"`
+	Engine      string   `form:"engine"`      // "external" (mysqldump/pg_dump/sqlite3) or "native"
+	Compression string   `form:"compression"` // "none", "gzip" or "zstd"
+	Format      string   `form:"format"`      // "sql", or "csv", "ndjson" or "json" for one file per table
+	Archive     string   `form:"archive"`     // "zip" or "tar", bundling the per-table files
+	Header      bool     `form:"header"`      // CSV header row with the column names
+	Delimiter   string   `form:"delimiter"`   // CSV field delimiter, "tab" for a tab
+	Null        string   `form:"null"`        // CSV text written for NULL values
+	DateFormat  string   `form:"dateFormat"`  // "rfc3339", "datetime" or "unix"
+	Mode        string   `form:"mode"`        // "full", "schema" for the DDL only or "data" for the rows only
+	Skip        []string `form:"skip"`        // kinds of objects to leave out: "routines", "triggers", "events", "views" or "sequences"
+	Storage     string   `form:"storage"`     // "local", "s3" or "sftp"; empty for the configured default
+
+	// Table selection. Without any, every table is exported.
+	Tables          []string `form:"table"`          // tables ticked in the table picker
+	Include         string   `form:"include"`        // more table names or glob patterns, comma or newline separated
+	Exclude         string   `form:"exclude"`        // table names or glob patterns to leave out
+	WhereTables     []string `form:"whereTable"`     // table of the row filter at the same index
+	WhereConditions []string `form:"whereCondition"` // row filters, SQL conditions without WHERE
+
+	// Encryption with age. Without recipients or a passphrase the exports
+	// are encrypted to the server's default recipients, if any.
+	Recipients string `form:"recipients"` // age or SSH public keys, comma or newline separated
+	Passphrase string `form:"passphrase"` // never logged or shown again
+}
+
+// ScheduleForm represents the form data for creating or editing a backup
+// schedule. The connection and export options are those of the export
+// form; its database field is unused.
+type ScheduleForm struct {
+	ExportForm
+
+	Name      string `form:"name"`
+	Cron      string `form:"cron"`      // five field cron expression or a descriptor such as @daily
+	Enabled   bool   `form:"enabled"`   // run at the scheduled times
+	CatchUp   string `form:"catchUp"`   // "skip" or "once", for runs missed while the server was down
+	Databases string `form:"databases"` // comma or newline separated
+
+	// Retention of the schedule's exports, zero to disable a rule
+	KeepLast    int `form:"keepLast"`
+	KeepDaily   int `form:"keepDaily"`
+	KeepWeekly  int `form:"keepWeekly"`
+	KeepMonthly int `form:"keepMonthly"`
+	MaxAgeDays  int `form:"maxAgeDays"`
 }
 
 // ImportForm represents the form data for importing a database
 type ImportForm struct {
-	Type     string `form:"type"`
-	Host     string `form:"host"`
-	Port     string `form:"port"`
+	ConnectionForm
+
 	Database string `form:"database"`
-	Username string `form:"username"`
-	Password string `form:"password"`
+
+	// KeepUpload keeps the uploaded file after a successful import
+	KeepUpload bool `form:"keepUpload"`
+
+	// Export is the ID of the catalogued export to restore instead of an
+	// uploaded file
+	Export int64 `form:"export"`
+
+	// Keys that decrypt an encrypted upload; never logged
+	Identity   string `form:"identity"` // age identities or an SSH private key
+	Passphrase string `form:"passphrase"`
 }
 
-// ConnectionForm represents the form data for database connection
+// CSVImportForm represents the form data for importing a CSV file into a
+// table. The file is uploaded first to preview it, then the column mapping
+// is submitted along with the name of the saved upload.
+type CSVImportForm struct {
+	ConnectionForm
+
+	Database    string   `form:"database"`
+	Upload      string   `form:"upload"`      // saved upload in the upload directory
+	Delimiter   string   `form:"delimiter"`   // field delimiter, "tab" for a tab
+	Header      bool     `form:"header"`      // first record holds the column names
+	Null        string   `form:"null"`        // field text loaded as NULL
+	PreviewRows int      `form:"previewRows"` // records shown in the preview
+	Mode        string   `form:"mode"`        // "create" a new table or load into an "existing" one
+	Table       string   `form:"table"`       // existing table to load into
+	NewTable    string   `form:"newTable"`    // name of the table to create
+	Targets     []string `form:"target"`      // target column of every CSV column, empty to skip it
+	Types       []string `form:"columnType"`  // column types of a new table
+	KeepUpload  bool     `form:"keepUpload"`  // keep the uploaded file after a successful import
+}
+
+// ConnectionForm represents the form data for database connection. The
+// other forms embed it.
 type ConnectionForm struct {
+	Profile  int64  `form:"profile"` // saved connection profile that fills in the other fields
 	Type     string `form:"type"`
 	Host     string `form:"host"`
 	Port     string `form:"port"`
@@ -31,16 +101,45 @@ type ConnectionForm struct {
 
 // DatabaseOperation represents the form data for database operations
 type DatabaseOperation struct {
-	Type        string `form:"type"`
-	Host        string `form:"host"`
-	Port        string `form:"port"`
-	Username    string `form:"username"`
-	Password    string `form:"password"`
+	ConnectionForm
+
 	Database    string `form:"database"`
 	NewDatabase string `form:"newDatabase"`
 	Operation   string `form:"operation"`
 }
 
+// ProfileForm represents the form data for creating or editing a
+// connection profile. An empty password keeps the stored one.
+type ProfileForm struct {
+	Name     string `form:"name"`
+	Type     string `form:"type"`
+	Host     string `form:"host"`
+	Port     string `form:"port"`
+	Username string `form:"username"`
+	Password string `form:"password"`
+}
+
+// LoginForm represents the form data of the login page. Next is the page
+// to return to afterwards.
+type LoginForm struct {
+	Username string `form:"username"`
+	Password string `form:"password"`
+	Next     string `form:"next"`
+}
+
+// UserForm represents the form data for creating or editing a user. An
+// empty password keeps the stored one.
+type UserForm struct {
+	Username string `form:"username"`
+	Role     string `form:"role"`
+	Password string `form:"password"`
+}
+
+// TokenForm represents the form data for creating an API token
+type TokenForm struct {
+	Name string `form:"name"` // what the token is for, such as the CI job using it
+}
+
 // Database represents a database in the list
 type Database struct {
 	Name string
@@ -0,0 +1,91 @@
+package profiles
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+)
+
+// ErrDecrypt is returned for passwords that do not decrypt with the master
+// key, usually because the key changed
+var ErrDecrypt = errors.New("the password cannot be decrypted with the master key")
+
+// newCipher returns AES-256-GCM keyed by the SHA-256 of the master key, so
+// that keys of any length can be configured
+func newCipher(masterKey string) (cipher.AEAD, error) {
+	if masterKey == "" {
+		return nil, errors.New("the master key is empty")
+	}
+	key := sha256.Sum256([]byte(masterKey))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts a password, returning the base64 of a random nonce followed
+// by the ciphertext. Empty passwords stay empty.
+func seal(aead cipher.AEAD, password string) (string, error) {
+	if password == "" {
+		return "", nil
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(password), nil)), nil
+}
+
+// open decrypts a password encrypted by seal
+func open(aead cipher.AEAD, sealed string) (string, error) {
+	if sealed == "" {
+		return "", nil
+	}
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < aead.NonceSize() {
+		return "", ErrDecrypt
+	}
+	password, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return "", ErrDecrypt
+	}
+	return string(password), nil
+}
+
+// KeyFile returns the master key stored in the file at path, generating a
+// random key into a new file readable by the owner only when there is none
+func KeyFile(path string) (key string, created bool, err error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		key = strings.TrimSpace(string(data))
+		if key == "" {
+			return "", false, errors.New("the master key file " + path + " is empty")
+		}
+		return key, false, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", false, err
+	}
+
+	var random [32]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		return "", false, err
+	}
+	key = hex.EncodeToString(random[:])
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return "", false, err
+	}
+	if _, err := file.WriteString(key + "\n"); err != nil {
+		file.Close()
+		return "", false, err
+	}
+	return key, true, file.Close()
+}
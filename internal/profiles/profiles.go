@@ -0,0 +1,202 @@
+// Package profiles stores named database connections in a SQLite database,
+// with their passwords encrypted by a master key.
+package profiles
+
+import (
+	"context"
+	"crypto/cipher"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// ErrNotFound is returned for profiles that do not exist
+var ErrNotFound = errors.New("connection profile not found")
+
+// ErrDuplicateName is returned when a profile is saved under the name of
+// another one
+var ErrDuplicateName = errors.New("a connection profile with this name already exists")
+
+const schema = `CREATE TABLE IF NOT EXISTS profiles (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	name       TEXT NOT NULL UNIQUE,
+	type       TEXT NOT NULL,
+	host       TEXT NOT NULL,
+	port       TEXT NOT NULL,
+	username   TEXT NOT NULL,
+	password   TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL
+)`
+
+// columns lists the columns of the profiles table in the order scan reads
+// them
+const columns = "id, name, type, host, port, username, password, created_at, updated_at"
+
+// Profile is a saved database connection
+type Profile struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Host     string `json:"host,omitempty"`
+	Port     string `json:"port,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"-"` // decrypted; never sent to clients
+
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Store persists profiles in a SQLite database
+type Store struct {
+	db   *sql.DB
+	aead cipher.AEAD
+}
+
+// Open opens the profiles stored in the SQLite file at path, creating it if
+// needed. Passwords are encrypted with masterKey.
+func Open(path, masterKey string) (*Store, error) {
+	aead, err := newCipher(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db, aead: aead}, nil
+}
+
+// Close closes the store
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Seal encrypts a secret with the master key, for the other stores that
+// keep passwords
+func (s *Store) Seal(secret string) (string, error) {
+	return seal(s.aead, secret)
+}
+
+// Unseal decrypts a secret encrypted by Seal
+func (s *Store) Unseal(sealed string) (string, error) {
+	return open(s.aead, sealed)
+}
+
+// List returns every profile, by name, without their passwords
+func (s *Store) List(ctx context.Context) ([]Profile, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+columns+" FROM profiles ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	profiles, err := scan(rows)
+	for i := range profiles {
+		profiles[i].Password = ""
+	}
+	return profiles, err
+}
+
+// Get returns the profile with the given ID with its password decrypted.
+// When the password does not decrypt the profile is returned without it,
+// along with an error wrapping ErrDecrypt, so that it can be fixed.
+func (s *Store) Get(ctx context.Context, id int64) (*Profile, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+columns+" FROM profiles WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	profiles, err := scan(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(profiles) == 0 {
+		return nil, ErrNotFound
+	}
+	p := &profiles[0]
+	if p.Password, err = open(s.aead, p.Password); err != nil {
+		return p, fmt.Errorf("profile %q: %w", p.Name, err)
+	}
+	return p, nil
+}
+
+// Save inserts a new profile, setting its ID, or updates an existing one
+func (s *Store) Save(ctx context.Context, p *Profile) error {
+	password, err := seal(s.aead, p.Password)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	p.UpdatedAt = now
+	if p.ID == 0 {
+		p.CreatedAt = now
+		result, err := s.db.ExecContext(ctx, `INSERT INTO profiles (name, type, host, port, username, password, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			p.Name, p.Type, p.Host, p.Port, p.Username, password, p.CreatedAt.UnixMilli(), p.UpdatedAt.UnixMilli())
+		if err != nil {
+			return uniqueName(err)
+		}
+		p.ID, err = result.LastInsertId()
+		return err
+	}
+
+	result, err := s.db.ExecContext(ctx, `UPDATE profiles SET name = ?, type = ?, host = ?, port = ?, username = ?, password = ?,
+			updated_at = ?
+		WHERE id = ?`,
+		p.Name, p.Type, p.Host, p.Port, p.Username, password, p.UpdatedAt.UnixMilli(), p.ID)
+	if err != nil {
+		return uniqueName(err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Delete removes the profile with the given ID
+func (s *Store) Delete(ctx context.Context, id int64) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM profiles WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+// uniqueName reports a violation of the unique profile names as
+// ErrDuplicateName
+func uniqueName(err error) error {
+	if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+		return ErrDuplicateName
+	}
+	return err
+}
+
+// scan reads every row of rows into profiles, leaving their passwords
+// encrypted, and closes rows
+func scan(rows *sql.Rows) ([]Profile, error) {
+	defer rows.Close()
+
+	var profiles []Profile
+	for rows.Next() {
+		var p Profile
+		var created, updated int64
+		if err := rows.Scan(&p.ID, &p.Name, &p.Type, &p.Host, &p.Port, &p.Username, &p.Password, &created, &updated); err != nil {
+			return nil, err
+		}
+		p.CreatedAt = time.UnixMilli(created)
+		p.UpdatedAt = time.UnixMilli(updated)
+		profiles = append(profiles, p)
+	}
+	return profiles, rows.Err()
+}
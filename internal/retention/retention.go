@@ -0,0 +1,151 @@
+// Package retention decides which exports to delete under a retention
+// policy and sweeps the export and upload directories in the background.
+package retention
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Policy limits how many exports are kept. The rules are applied in order:
+//
+//  1. When KeepLast or any tier is set, only the exports they select are
+//     kept and every other export is deleted.
+//  2. Exports older than MaxAge are deleted, even if a count rule selected
+//     them.
+//  3. While the exports take more than MaxTotalBytes, the oldest are
+//     deleted, except for the newest export of each database.
+//
+// Zero values disable a rule; the zero Policy keeps everything.
+type Policy struct {
+	MaxAge        time.Duration
+	MaxTotalBytes int64
+
+	// KeepLast keeps the newest exports of each database
+	KeepLast int
+
+	// Daily, Weekly and Monthly keep the newest export of each of that many
+	// most recent days, ISO weeks and months per database, the
+	// grandfather-father-son scheme
+	Daily   int
+	Weekly  int
+	Monthly int
+}
+
+// IsZero reports whether the policy keeps every export
+func (p Policy) IsZero() bool {
+	return p == Policy{}
+}
+
+// countRules reports whether any rule keeps exports by count
+func (p Policy) countRules() bool {
+	return p.KeepLast > 0 || p.Daily > 0 || p.Weekly > 0 || p.Monthly > 0
+}
+
+// Export is an export file the policy applies to
+type Export struct {
+	ID       int64
+	Database string
+	Time     time.Time
+	Size     int64
+}
+
+// Expired returns the exports p deletes at now, oldest first
+func (p Policy) Expired(exports []Export, now time.Time) []Export {
+	if p.IsZero() {
+		return nil
+	}
+
+	// Newest first, per database
+	sorted := append([]Export{}, exports...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time.After(sorted[j].Time) })
+	byDatabase := make(map[string][]Export)
+	for _, export := range sorted {
+		byDatabase[export.Database] = append(byDatabase[export.Database], export)
+	}
+
+	expired := make(map[int64]bool)
+	if p.countRules() {
+		for _, group := range byDatabase {
+			kept := p.keep(group)
+			for _, export := range group {
+				if !kept[export.ID] {
+					expired[export.ID] = true
+				}
+			}
+		}
+	}
+
+	if p.MaxAge > 0 {
+		cutoff := now.Add(-p.MaxAge)
+		for _, export := range sorted {
+			if export.Time.Before(cutoff) {
+				expired[export.ID] = true
+			}
+		}
+	}
+
+	if p.MaxTotalBytes > 0 {
+		newest := make(map[int64]bool)
+		for _, group := range byDatabase {
+			newest[group[0].ID] = true
+		}
+		var total int64
+		for _, export := range sorted {
+			if !expired[export.ID] {
+				total += export.Size
+			}
+		}
+		for i := len(sorted) - 1; i >= 0 && total > p.MaxTotalBytes; i-- {
+			export := sorted[i]
+			if !expired[export.ID] && !newest[export.ID] {
+				expired[export.ID] = true
+				total -= export.Size
+			}
+		}
+	}
+
+	var result []Export
+	for i := len(sorted) - 1; i >= 0; i-- {
+		if expired[sorted[i].ID] {
+			result = append(result, sorted[i])
+		}
+	}
+	return result
+}
+
+// keep returns the IDs of the exports of one database, newest first,
+// that KeepLast and the tiers keep
+func (p Policy) keep(group []Export) map[int64]bool {
+	kept := make(map[int64]bool)
+	for i := 0; i < p.KeepLast && i < len(group); i++ {
+		kept[group[i].ID] = true
+	}
+
+	tiers := []struct {
+		count  int
+		period func(t time.Time) string
+	}{
+		{p.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{p.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{p.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+	}
+	for _, tier := range tiers {
+		seen := make(map[string]bool)
+		for _, export := range group {
+			if len(seen) == tier.count {
+				break
+			}
+			period := tier.period(export.Time)
+			if !seen[period] {
+				seen[period] = true
+				kept[export.ID] = true
+			}
+		}
+	}
+	return kept
+}
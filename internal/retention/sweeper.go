@@ -0,0 +1,129 @@
+package retention
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"sqlclient-export-import/internal/catalog"
+	"sqlclient-export-import/internal/manifest"
+	"sqlclient-export-import/internal/storage"
+	"time"
+)
+
+// Sweeper deletes the exports a policy expires and uploads left behind by
+// failed or abandoned imports. Exports are found through the catalog, in
+// whichever storage they are kept; files that it does not list are left
+// alone.
+type Sweeper struct {
+	Catalog *catalog.Catalog
+	Policy  Policy
+
+	UploadDir string
+	// UploadMaxAge is how long uploads are kept. Zero keeps them forever.
+	UploadMaxAge time.Duration
+}
+
+// Run sweeps straight away and then every interval until ctx is done
+func (s *Sweeper) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Sweep(ctx); err != nil {
+			log.Printf("Retention sweep failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep applies the policy once
+func (s *Sweeper) Sweep(ctx context.Context) error {
+	if err := Apply(ctx, s.Catalog, s.Policy, catalog.Filter{}); err != nil {
+		return err
+	}
+	return s.sweepUploads()
+}
+
+// Apply deletes the files of the successful exports matching filter that
+// policy expires and clears them from their catalog entries
+func Apply(ctx context.Context, c *catalog.Catalog, policy Policy, filter catalog.Filter) error {
+	if policy.IsZero() {
+		return nil
+	}
+
+	filter.Status = catalog.StatusSucceeded
+	entries, err := c.List(ctx, filter)
+	if err != nil {
+		return err
+	}
+	files := make(map[int64]catalog.Entry)
+	var exports []Export
+	for _, entry := range entries {
+		if entry.File == "" {
+			continue
+		}
+		files[entry.ID] = entry
+		exports = append(exports, Export{
+			ID:       entry.ID,
+			Database: entry.Type + "/" + entry.Host + "/" + entry.Database,
+			Time:     entry.StartedAt,
+			Size:     entry.Size,
+		})
+	}
+
+	for _, export := range policy.Expired(exports, time.Now()) {
+		entry := files[export.ID]
+		store, err := storage.Get(entry.Storage)
+		if err != nil {
+			log.Printf("Retention: failed to delete %s: %v", entry.File, err)
+			continue
+		}
+		location := store.Location(entry.File)
+		if err := store.Remove(ctx, entry.File); err != nil {
+			log.Printf("Retention: failed to delete %s: %v", location, err)
+			continue
+		}
+		if err := store.Remove(ctx, manifest.Path(entry.File)); err != nil {
+			log.Printf("Retention: failed to delete the manifest of %s: %v", location, err)
+		}
+		if err := c.ClearFile(ctx, export.ID); err != nil {
+			return err
+		}
+		log.Printf("Retention: deleted export %s", location)
+	}
+	return nil
+}
+
+// sweepUploads deletes uploads older than UploadMaxAge
+func (s *Sweeper) sweepUploads() error {
+	if s.UploadMaxAge <= 0 {
+		return nil
+	}
+
+	entries, err := os.ReadDir(s.UploadDir)
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-s.UploadMaxAge)
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		path := filepath.Join(s.UploadDir, entry.Name())
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Retention: failed to delete %s: %v", path, err)
+			continue
+		}
+		log.Printf("Retention: deleted upload %s", path)
+	}
+	return nil
+}
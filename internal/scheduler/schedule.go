@@ -0,0 +1,93 @@
+// Package scheduler runs exports on cron schedules in the background and
+// persists the schedules along with the outcome of their last run.
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"sqlclient-export-import/internal/models"
+	"sqlclient-export-import/internal/retention"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Catch-up policies for runs missed while the server was down
+const (
+	CatchUpSkip = "skip" // wait for the next scheduled time
+	CatchUpOnce = "once" // run once as soon as the server starts
+)
+
+// Run statuses, besides the final job statuses of the exports
+const (
+	StatusRunning     = "running"
+	StatusSucceeded   = "succeeded"
+	StatusFailed      = "failed"
+	StatusInterrupted = "interrupted" // the server stopped during the run
+)
+
+// parser reads standard five field cron expressions and descriptors such
+// as @daily, optionally prefixed with CRON_TZ=<zone>
+var parser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// Schedule is a recurring export of one or more databases
+type Schedule struct {
+	ID      int64  `json:"id"`
+	Name    string `json:"name"`
+	Cron    string `json:"cron"`
+	Enabled bool   `json:"enabled"`
+	CatchUp string `json:"catchUp"`
+
+	// Databases are exported one job each, with the connection and options
+	// of Export
+	Databases []string          `json:"databases"`
+	Export    models.ExportForm `json:"export"`
+
+	// Retention prunes the exports this schedule wrote after every run
+	Retention retention.Policy `json:"retention"`
+
+	CreatedAt  time.Time `json:"createdAt"`
+	LastRun    time.Time `json:"lastRun,omitempty"`
+	LastStatus string    `json:"lastStatus,omitempty"`
+	LastError  string    `json:"lastError,omitempty"`
+	LastJobs   []string  `json:"lastJobs,omitempty"`
+
+	// NextRun is when the schedule runs next, zero when it is disabled. It
+	// is computed, not stored.
+	NextRun time.Time `json:"nextRun,omitempty"`
+}
+
+// Validate checks the fields the scheduler relies on. The export options
+// are validated by the caller.
+func (s *Schedule) Validate() error {
+	if s.Name == "" {
+		return errors.New("the schedule needs a name")
+	}
+	if _, err := parser.Parse(s.Cron); err != nil {
+		return fmt.Errorf("invalid cron expression: %w", err)
+	}
+	switch s.CatchUp {
+	case CatchUpSkip, CatchUpOnce:
+	default:
+		return fmt.Errorf("unknown catch-up policy: %s", s.CatchUp)
+	}
+	if len(s.Databases) == 0 {
+		return errors.New("the schedule needs at least one database")
+	}
+	return nil
+}
+
+// TriggeredBy is how the exports of the schedule are recorded in the
+// export catalog
+func (s *Schedule) TriggeredBy() string {
+	return fmt.Sprintf("schedule:%d", s.ID)
+}
+
+// next returns the first scheduled time after t
+func (s *Schedule) next(t time.Time) time.Time {
+	schedule, err := parser.Parse(s.Cron)
+	if err != nil {
+		return time.Time{}
+	}
+	return schedule.Next(t)
+}
@@ -0,0 +1,247 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// ErrRunning is returned when a schedule is started while its previous run
+// has not finished
+var ErrRunning = errors.New("the schedule is already running")
+
+// Runner runs the exports of a schedule, blocking until they finish, and
+// returns the IDs of the jobs it started
+type Runner func(ctx context.Context, schedule Schedule) ([]string, error)
+
+// Scheduler runs the enabled schedules of a store at their times
+type Scheduler struct {
+	store *Store
+	run   Runner
+	cron  *cron.Cron
+
+	// ctx is cancelled by Stop, cancelling the exports of running schedules
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	entries map[int64]cron.EntryID
+	running map[int64]bool
+}
+
+// New returns a scheduler for the schedules in store that runs them with
+// run. Nothing runs until Start is called.
+func New(store *Store, run Runner) *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{
+		store:   store,
+		run:     run,
+		cron:    cron.New(cron.WithParser(parser)),
+		ctx:     ctx,
+		cancel:  cancel,
+		entries: make(map[int64]cron.EntryID),
+		running: make(map[int64]bool),
+	}
+}
+
+// Start schedules every enabled schedule. Schedules whose catch-up policy
+// is CatchUpOnce run straight away if a run was due while the server was
+// down or their last run was interrupted.
+func (s *Scheduler) Start() error {
+	if err := s.store.interrupt(s.ctx); err != nil {
+		return err
+	}
+	schedules, err := s.store.List(s.ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	for _, schedule := range schedules {
+		if !schedule.Enabled {
+			continue
+		}
+		if err := s.register(schedule); err != nil {
+			log.Printf("Failed to schedule %q: %v", schedule.Name, err)
+			continue
+		}
+		if schedule.CatchUp == CatchUpOnce && missed(schedule, now) {
+			log.Printf("Schedule %q missed a run while the server was down, running it now", schedule.Name)
+			go s.execute(schedule.ID)
+		}
+	}
+	s.mu.Unlock()
+
+	s.cron.Start()
+	return nil
+}
+
+// Stop stops scheduling runs and cancels the running ones
+func (s *Scheduler) Stop() {
+	s.cron.Stop()
+	s.cancel()
+}
+
+// List returns every schedule with its next run filled in
+func (s *Scheduler) List(ctx context.Context) ([]Schedule, error) {
+	schedules, err := s.store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range schedules {
+		setNextRun(&schedules[i])
+	}
+	return schedules, nil
+}
+
+// Get returns the schedule with the given ID with its next run filled in
+func (s *Scheduler) Get(ctx context.Context, id int64) (*Schedule, error) {
+	schedule, err := s.store.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	setNextRun(schedule)
+	return schedule, nil
+}
+
+// Save validates and stores a new or changed schedule and reschedules it
+func (s *Scheduler) Save(ctx context.Context, schedule *Schedule) error {
+	if err := schedule.Validate(); err != nil {
+		return err
+	}
+	if err := s.store.Save(ctx, schedule); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unregister(schedule.ID)
+	if schedule.Enabled {
+		if err := s.register(*schedule); err != nil {
+			return err
+		}
+	}
+	setNextRun(schedule)
+	return nil
+}
+
+// Delete removes a schedule. A run in progress is left to finish.
+func (s *Scheduler) Delete(ctx context.Context, id int64) error {
+	if err := s.store.Delete(ctx, id); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.unregister(id)
+	s.mu.Unlock()
+	return nil
+}
+
+// RunNow starts a run of the schedule in the background, whether or not it
+// is enabled
+func (s *Scheduler) RunNow(ctx context.Context, id int64) error {
+	if _, err := s.store.Get(ctx, id); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	running := s.running[id]
+	s.mu.Unlock()
+	if running {
+		return ErrRunning
+	}
+
+	go s.execute(id)
+	return nil
+}
+
+// register adds the schedule to the cron runner. The caller must hold s.mu.
+func (s *Scheduler) register(schedule Schedule) error {
+	id := schedule.ID
+	entryID, err := s.cron.AddFunc(schedule.Cron, func() { s.execute(id) })
+	if err != nil {
+		return err
+	}
+	s.entries[id] = entryID
+	return nil
+}
+
+// unregister removes the schedule from the cron runner. The caller must
+// hold s.mu.
+func (s *Scheduler) unregister(id int64) {
+	if entryID, ok := s.entries[id]; ok {
+		s.cron.Remove(entryID)
+		delete(s.entries, id)
+	}
+}
+
+// execute runs a schedule and records the outcome. Runs of the same
+// schedule never overlap; a run that comes due while the previous one is
+// still going is skipped.
+func (s *Scheduler) execute(id int64) {
+	s.mu.Lock()
+	if s.running[id] {
+		s.mu.Unlock()
+		log.Printf("Schedule %d is still running, skipping this run", id)
+		return
+	}
+	s.running[id] = true
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.running, id)
+		s.mu.Unlock()
+	}()
+
+	schedule, err := s.store.Get(s.ctx, id)
+	if err != nil {
+		log.Printf("Failed to load schedule %d: %v", id, err)
+		return
+	}
+
+	started := time.Now()
+	if err := s.store.recordRun(s.ctx, id, started, StatusRunning, "", nil); err != nil {
+		log.Printf("Failed to record the run of schedule %q: %v", schedule.Name, err)
+	}
+	log.Printf("Running schedule %q", schedule.Name)
+
+	jobs, err := s.run(s.ctx, *schedule)
+	status, errorMsg := StatusSucceeded, ""
+	if err != nil {
+		status, errorMsg = StatusFailed, err.Error()
+		log.Printf("Schedule %q failed: %v", schedule.Name, err)
+	}
+	// After Stop the run stays marked as running, to be reported as
+	// interrupted on the next start
+	if err := s.store.recordRun(s.ctx, id, started, status, errorMsg, jobs); err != nil {
+		log.Printf("Failed to record the run of schedule %q: %v", schedule.Name, err)
+	}
+}
+
+// missed reports whether a run of schedule came due before now without
+// happening, or its last run was interrupted
+func missed(schedule Schedule, now time.Time) bool {
+	if schedule.LastStatus == StatusInterrupted {
+		return true
+	}
+	since := schedule.LastRun
+	if since.IsZero() {
+		since = schedule.CreatedAt
+	}
+	next := schedule.next(since)
+	return !next.IsZero() && next.Before(now)
+}
+
+// setNextRun fills in when an enabled schedule runs next
+func setNextRun(schedule *Schedule) {
+	schedule.NextRun = time.Time{}
+	if schedule.Enabled {
+		schedule.NextRun = schedule.next(time.Now())
+	}
+}
@@ -0,0 +1,316 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sqlclient-export-import/internal/models"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// ErrNotFound is returned for schedules that do not exist
+var ErrNotFound = errors.New("schedule not found")
+
+const schema = `CREATE TABLE IF NOT EXISTS schedules (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	name        TEXT NOT NULL,
+	cron        TEXT NOT NULL,
+	enabled     INTEGER NOT NULL,
+	catch_up    TEXT NOT NULL,
+	databases   TEXT NOT NULL,
+	export      TEXT NOT NULL,
+	retention   TEXT NOT NULL,
+	created_at  INTEGER NOT NULL,
+	last_run    INTEGER NOT NULL DEFAULT 0,
+	last_status TEXT NOT NULL DEFAULT '',
+	last_error  TEXT NOT NULL DEFAULT '',
+	last_jobs   TEXT NOT NULL DEFAULT '[]'
+)`
+
+// columns lists the columns of the schedules table in the order scan reads
+// them
+const columns = `id, name, cron, enabled, catch_up, databases, export, retention, created_at,
+	last_run, last_status, last_error, last_jobs`
+
+// sealedVersion is the user_version of stores whose passwords and
+// passphrases are sealed; earlier versions kept them in plain text
+const sealedVersion = 1
+
+// Secrets encrypts the passwords and passphrases of the schedules
+type Secrets interface {
+	Seal(secret string) (string, error)
+	Unseal(sealed string) (string, error)
+}
+
+// Store persists schedules in a SQLite database
+type Store struct {
+	db      *sql.DB
+	secrets Secrets
+}
+
+// OpenStore opens the schedules stored in the SQLite file at path, creating
+// it if needed. The database password and the age passphrase of the
+// exports are sealed with secrets.
+func OpenStore(path string, secrets Secrets) (*Store, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	s := &Store{db: db, secrets: secrets}
+	if err := s.sealPlainText(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// sealPlainText seals the secrets of a store written by an earlier version,
+// then vacuums it so that the plain text does not linger in free pages
+func (s *Store) sealPlainText() error {
+	var version int
+	if err := s.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version >= sealedVersion {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT id, export FROM schedules")
+	if err != nil {
+		return err
+	}
+	exports := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var export string
+		if err := rows.Scan(&id, &export); err != nil {
+			rows.Close()
+			return err
+		}
+		exports[id] = export
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, data := range exports {
+		var export models.ExportForm
+		if err := json.Unmarshal([]byte(data), &export); err != nil {
+			return err
+		}
+		sealed, err := s.marshalExport(export)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE schedules SET export = ? WHERE id = ?", sealed, id); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", sealedVersion)); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if len(exports) > 0 {
+		if _, err := s.db.Exec("VACUUM"); err != nil {
+			return err
+		}
+		if _, err := s.db.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// marshalExport returns the JSON of export with its password and
+// passphrase sealed
+func (s *Store) marshalExport(export models.ExportForm) (string, error) {
+	var err error
+	if export.Password, err = s.secrets.Seal(export.Password); err != nil {
+		return "", err
+	}
+	if export.Passphrase, err = s.secrets.Seal(export.Passphrase); err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(export)
+	return string(data), err
+}
+
+// Close closes the store
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// List returns every schedule, by name
+func (s *Store) List(ctx context.Context) ([]Schedule, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+columns+" FROM schedules ORDER BY name, id")
+	if err != nil {
+		return nil, err
+	}
+	return s.scan(rows)
+}
+
+// Get returns the schedule with the given ID
+func (s *Store) Get(ctx context.Context, id int64) (*Schedule, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+columns+" FROM schedules WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	schedules, err := s.scan(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(schedules) == 0 {
+		return nil, ErrNotFound
+	}
+	return &schedules[0], nil
+}
+
+// Save inserts a new schedule, setting its ID and creation time, or
+// updates the definition of an existing one. The outcome of the last run
+// is left alone.
+func (s *Store) Save(ctx context.Context, schedule *Schedule) error {
+	databases, err := json.Marshal(schedule.Databases)
+	if err != nil {
+		return err
+	}
+	export, err := s.marshalExport(schedule.Export)
+	if err != nil {
+		return err
+	}
+	policy, err := json.Marshal(schedule.Retention)
+	if err != nil {
+		return err
+	}
+
+	if schedule.ID == 0 {
+		schedule.CreatedAt = time.Now()
+		result, err := s.db.ExecContext(ctx, `INSERT INTO schedules (name, cron, enabled, catch_up, databases, export, retention, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			schedule.Name, schedule.Cron, schedule.Enabled, schedule.CatchUp, string(databases), export, string(policy),
+			schedule.CreatedAt.UnixMilli())
+		if err != nil {
+			return err
+		}
+		schedule.ID, err = result.LastInsertId()
+		return err
+	}
+
+	result, err := s.db.ExecContext(ctx, `UPDATE schedules SET name = ?, cron = ?, enabled = ?, catch_up = ?, databases = ?,
+			export = ?, retention = ?
+		WHERE id = ?`,
+		schedule.Name, schedule.Cron, schedule.Enabled, schedule.CatchUp, string(databases), export, string(policy),
+		schedule.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Delete removes the schedule with the given ID
+func (s *Store) Delete(ctx context.Context, id int64) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM schedules WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+// recordRun stores the outcome of the schedule's last run
+func (s *Store) recordRun(ctx context.Context, id int64, started time.Time, status, errorMsg string, jobs []string) error {
+	if jobs == nil {
+		jobs = []string{}
+	}
+	jobIDs, err := json.Marshal(jobs)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, "UPDATE schedules SET last_run = ?, last_status = ?, last_error = ?, last_jobs = ? WHERE id = ?",
+		started.UnixMilli(), status, errorMsg, string(jobIDs), id)
+	return err
+}
+
+// interrupt marks runs that were still going when the server stopped
+func (s *Store) interrupt(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE schedules SET last_status = ? WHERE last_status = ?", StatusInterrupted, StatusRunning)
+	return err
+}
+
+// unseal decrypts the password and passphrase of export, clearing both if
+// either does not decrypt
+func (s *Store) unseal(export *models.ExportForm) error {
+	password, err := s.secrets.Unseal(export.Password)
+	if err == nil {
+		export.Password = password
+		export.Passphrase, err = s.secrets.Unseal(export.Passphrase)
+	}
+	if err != nil {
+		export.Password, export.Passphrase = "", ""
+	}
+	return err
+}
+
+// scan reads every row of rows into schedules, unsealing their secrets,
+// and closes rows. Secrets that do not unseal, usually because the master
+// key changed, are left empty and have to be entered again.
+func (st *Store) scan(rows *sql.Rows) ([]Schedule, error) {
+	defer rows.Close()
+
+	var schedules []Schedule
+	for rows.Next() {
+		var s Schedule
+		var databases, export, policy, jobs string
+		var created, lastRun int64
+		if err := rows.Scan(&s.ID, &s.Name, &s.Cron, &s.Enabled, &s.CatchUp, &databases, &export, &policy, &created,
+			&lastRun, &s.LastStatus, &s.LastError, &jobs); err != nil {
+			return nil, err
+		}
+		for _, field := range []struct {
+			data string
+			v    any
+		}{
+			{databases, &s.Databases},
+			{export, &s.Export},
+			{policy, &s.Retention},
+			{jobs, &s.LastJobs},
+		} {
+			if err := json.Unmarshal([]byte(field.data), field.v); err != nil {
+				return nil, err
+			}
+		}
+		s.CreatedAt = time.UnixMilli(created)
+		if lastRun != 0 {
+			s.LastRun = time.UnixMilli(lastRun)
+		}
+		if err := st.unseal(&s.Export); err != nil {
+			log.Printf("Schedule %q: %v; its password and passphrase need to be entered again", s.Name, err)
+		}
+		schedules = append(schedules, s)
+	}
+	return schedules, rows.Err()
+}
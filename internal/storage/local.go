@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// LocalStorage keeps files in a directory of the server
+type LocalStorage struct {
+	dir string
+}
+
+// NewLocal returns the storage of the directory dir
+func NewLocal(dir string) *LocalStorage {
+	return &LocalStorage{dir: dir}
+}
+
+// Name returns "local"
+func (s *LocalStorage) Name() string {
+	return Local
+}
+
+// Path returns the path of the file name
+func (s *LocalStorage) Path(name string) string {
+	return filepath.Join(s.dir, cleanName(name))
+}
+
+// Location returns the path of the file name
+func (s *LocalStorage) Location(name string) string {
+	return s.Path(name)
+}
+
+// Create reserves the name with an empty file and writes into a hidden
+// temporary file, which replaces it on Close, so that a file is never seen
+// half written
+func (s *LocalStorage) Create(ctx context.Context, name string) (Writer, error) {
+	path := s.Path(name)
+	placeholder, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	placeholder.Close()
+
+	tmp, err := os.CreateTemp(s.dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		os.Remove(path)
+		return nil, err
+	}
+	return &localWriter{File: tmp, path: path}, nil
+}
+
+type localWriter struct {
+	*os.File
+	path string
+}
+
+func (w *localWriter) Close() error {
+	if err := w.File.Close(); err != nil {
+		w.Abort()
+		return err
+	}
+	if err := os.Rename(w.Name(), w.path); err != nil {
+		w.Abort()
+		return err
+	}
+	return nil
+}
+
+func (w *localWriter) Abort() error {
+	w.File.Close()
+	os.Remove(w.Name())
+	if err := os.Remove(w.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Open opens the file name
+func (s *LocalStorage) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	return os.Open(s.Path(name))
+}
+
+// Stat describes the file name
+func (s *LocalStorage) Stat(ctx context.Context, name string) (Info, error) {
+	info, err := os.Stat(s.Path(name))
+	if err != nil {
+		return Info{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return Info{}, fmt.Errorf("%s is not a file", s.Path(name))
+	}
+	return Info{Name: info.Name(), Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// Remove deletes the file name
+func (s *LocalStorage) Remove(ctx context.Context, name string) error {
+	if err := os.Remove(s.Path(name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// List describes the files of the directory, leaving out hidden files such
+// as those being written
+func (s *LocalStorage) List(ctx context.Context) ([]Info, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []Info
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, Info{Name: entry.Name(), Size: info.Size(), ModTime: info.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, nil
+}
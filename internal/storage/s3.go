@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// s3PartSize is the size of the parts of multipart uploads. Exports are
+// streamed without knowing their size, so each upload buffers one part.
+const s3PartSize = 16 << 20
+
+// S3Config names a bucket of Amazon S3 or of a compatible service such as
+// MinIO
+type S3Config struct {
+	Endpoint  string // host[:port], without a scheme
+	Region    string
+	Bucket    string
+	Prefix    string // prepended to the file names, such as "backups/"
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+}
+
+// S3Storage keeps files as objects of a bucket
+type S3Storage struct {
+	client *minio.Client
+	config S3Config
+}
+
+// NewS3 returns the storage of the bucket in config. It does not connect
+// until the storage is used.
+func NewS3(config S3Config) (*S3Storage, error) {
+	if config.Bucket == "" {
+		return nil, errors.New("no S3 bucket configured")
+	}
+	client, err := minio.New(config.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(config.AccessKey, config.SecretKey, ""),
+		Secure: config.UseSSL,
+		Region: config.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 configuration: %w", err)
+	}
+	return &S3Storage{client: client, config: config}, nil
+}
+
+// Name returns "s3"
+func (s *S3Storage) Name() string {
+	return S3
+}
+
+// Location returns the s3:// URL of the file name
+func (s *S3Storage) Location(name string) string {
+	return "s3://" + s.config.Bucket + "/" + s.key(name)
+}
+
+func (s *S3Storage) key(name string) string {
+	return s.config.Prefix + cleanName(name)
+}
+
+// Create starts uploading the file name. The object only appears once
+// Close completes the upload.
+func (s *S3Storage) Create(ctx context.Context, name string) (Writer, error) {
+	if _, err := s.Stat(ctx, name); err == nil {
+		return nil, &fs.PathError{Op: "create", Path: s.Location(name), Err: fs.ErrExist}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	w := &s3Writer{pw: pw, done: make(chan error, 1)}
+	go func() {
+		// Parts are checked with Content-MD5 rather than chunk signatures,
+		// which not every S3-compatible service supports over plain HTTP
+		_, err := s.client.PutObject(ctx, s.config.Bucket, s.key(name), pr, -1, minio.PutObjectOptions{
+			PartSize:             s3PartSize,
+			ContentType:          "application/octet-stream",
+			SendContentMd5:       true,
+			DisableContentSha256: true,
+		})
+		// Unblock the writer if the upload fails early
+		pr.CloseWithError(err)
+		w.done <- err
+	}()
+	return w, nil
+}
+
+// errAborted stops an upload that is discarded
+var errAborted = errors.New("upload aborted")
+
+type s3Writer struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+func (w *s3Writer) Close() error {
+	w.pw.Close()
+	if err := <-w.done; err != nil {
+		return fmt.Errorf("S3 upload failed: %w", err)
+	}
+	return nil
+}
+
+// Abort fails the upload, which then discards the parts already sent
+func (w *s3Writer) Abort() error {
+	w.pw.CloseWithError(errAborted)
+	<-w.done
+	return nil
+}
+
+// Open downloads the file name
+func (s *S3Storage) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	// GetObject only reports missing objects on the first read
+	if _, err := s.Stat(ctx, name); err != nil {
+		return nil, err
+	}
+	return s.client.GetObject(ctx, s.config.Bucket, s.key(name), minio.GetObjectOptions{})
+}
+
+// Stat describes the file name
+func (s *S3Storage) Stat(ctx context.Context, name string) (Info, error) {
+	object, err := s.client.StatObject(ctx, s.config.Bucket, s.key(name), minio.StatObjectOptions{})
+	if err != nil {
+		return Info{}, s.error("stat", name, err)
+	}
+	return Info{Name: cleanName(name), Size: object.Size, ModTime: object.LastModified}, nil
+}
+
+// Remove deletes the file name
+func (s *S3Storage) Remove(ctx context.Context, name string) error {
+	if err := s.client.RemoveObject(ctx, s.config.Bucket, s.key(name), minio.RemoveObjectOptions{}); err != nil {
+		return s.error("remove", name, err)
+	}
+	return nil
+}
+
+// List describes the objects under the prefix, leaving out those in
+// deeper "directories"
+func (s *S3Storage) List(ctx context.Context) ([]Info, error) {
+	var files []Info
+	for object := range s.client.ListObjects(ctx, s.config.Bucket, minio.ListObjectsOptions{Prefix: s.config.Prefix}) {
+		if object.Err != nil {
+			return nil, s.error("list", "", object.Err)
+		}
+		name := strings.TrimPrefix(object.Key, s.config.Prefix)
+		if name == "" || strings.Contains(name, "/") {
+			continue
+		}
+		files = append(files, Info{Name: name, Size: object.Size, ModTime: object.LastModified})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, nil
+}
+
+// error maps the S3 error codes of missing objects to fs.ErrNotExist
+func (s *S3Storage) error(op, name string, err error) error {
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NotFound":
+		err = fs.ErrNotExist
+	}
+	return &fs.PathError{Op: op, Path: s.Location(name), Err: err}
+}
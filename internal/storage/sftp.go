@@ -0,0 +1,283 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SFTPConfig names a directory on an SFTP server. The server's host key
+// must be listed in the known_hosts file.
+type SFTPConfig struct {
+	Host       string
+	Port       string
+	Username   string
+	Password   string
+	KeyFile    string // private key, tried before the password
+	Directory  string // relative to the login directory unless absolute
+	KnownHosts string // default ~/.ssh/known_hosts
+}
+
+// SFTPStorage keeps files in a directory of an SFTP server. Every use of
+// the storage opens its own connection.
+type SFTPStorage struct {
+	config SFTPConfig
+	ssh    *ssh.ClientConfig
+}
+
+// NewSFTP returns the storage of the directory in config. It reads the key
+// and known_hosts files but does not connect until the storage is used.
+func NewSFTP(config SFTPConfig) (*SFTPStorage, error) {
+	if config.Host == "" {
+		return nil, errors.New("no SFTP host configured")
+	}
+	if config.Port == "" {
+		config.Port = "22"
+	}
+
+	var auth []ssh.AuthMethod
+	if config.KeyFile != "" {
+		key, err := os.ReadFile(config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the SFTP key: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, errors.New("the SFTP key is not an unencrypted SSH private key")
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if config.Password != "" {
+		auth = append(auth, ssh.Password(config.Password))
+	}
+	if len(auth) == 0 {
+		return nil, errors.New("configure an SFTP key file or password")
+	}
+
+	knownHosts := config.KnownHosts
+	if knownHosts == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		knownHosts = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(knownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the SFTP known hosts: %w", err)
+	}
+
+	return &SFTPStorage{config: config, ssh: &ssh.ClientConfig{
+		User:            config.Username,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         30 * time.Second,
+	}}, nil
+}
+
+// Name returns "sftp"
+func (s *SFTPStorage) Name() string {
+	return SFTP
+}
+
+// Location returns the sftp:// URL of the file name
+func (s *SFTPStorage) Location(name string) string {
+	location := "sftp://" + s.config.Username + "@" + net.JoinHostPort(s.config.Host, s.config.Port) + "/"
+	if !strings.HasPrefix(s.config.Directory, "/") {
+		location += "~/"
+	}
+	return location + strings.TrimPrefix(s.path(name), "/")
+}
+
+func (s *SFTPStorage) path(name string) string {
+	if s.config.Directory == "" {
+		return cleanName(name)
+	}
+	return path.Join(s.config.Directory, cleanName(name))
+}
+
+// sftpConn is a connection to the server
+type sftpConn struct {
+	*sftp.Client
+	ssh  *ssh.Client
+	stop func() bool
+}
+
+func (c *sftpConn) Close() error {
+	c.stop()
+	err := c.Client.Close()
+	if sshErr := c.ssh.Close(); err == nil {
+		err = sshErr
+	}
+	return err
+}
+
+// connect opens a connection, which is closed when ctx is done
+func (s *SFTPStorage) connect(ctx context.Context) (*sftpConn, error) {
+	var dialer net.Dialer
+	netConn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(s.config.Host, s.config.Port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the SFTP server: %w", err)
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(netConn, netConn.RemoteAddr().String(), s.ssh)
+	if err != nil {
+		netConn.Close()
+		return nil, fmt.Errorf("failed to connect to the SFTP server: %w", err)
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+	sftpClient, err := sftp.NewClient(client)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to start SFTP: %w", err)
+	}
+
+	// Cancelling ctx interrupts a transfer
+	stop := context.AfterFunc(ctx, func() { client.Close() })
+	return &sftpConn{Client: sftpClient, ssh: client, stop: stop}, nil
+}
+
+// Create creates the file name, failing if it exists. A file that is
+// aborted is removed.
+func (s *SFTPStorage) Create(ctx context.Context, name string) (Writer, error) {
+	conn, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Servers report existing files with a generic failure, so look first
+	if _, err := conn.Stat(s.path(name)); err == nil {
+		conn.Close()
+		return nil, s.error("create", name, fs.ErrExist)
+	}
+	file, err := conn.OpenFile(s.path(name), os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		conn.Close()
+		return nil, s.error("create", name, err)
+	}
+	return &sftpWriter{File: file, conn: conn, storage: s, name: name}, nil
+}
+
+type sftpWriter struct {
+	*sftp.File
+	conn    *sftpConn
+	storage *SFTPStorage
+	name    string
+}
+
+func (w *sftpWriter) Close() error {
+	err := w.File.Close()
+	if closeErr := w.conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Abort removes the partial file over a new connection, as a cancelled
+// transfer has closed its own
+func (w *sftpWriter) Abort() error {
+	w.File.Close()
+	w.conn.Close()
+	return w.storage.Remove(context.Background(), w.name)
+}
+
+// Open opens the file name. Closing it closes the connection too.
+func (s *SFTPStorage) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	conn, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	file, err := conn.Open(s.path(name))
+	if err != nil {
+		conn.Close()
+		return nil, s.error("open", name, err)
+	}
+	return &sftpReader{File: file, conn: conn}, nil
+}
+
+type sftpReader struct {
+	*sftp.File
+	conn *sftpConn
+}
+
+func (r *sftpReader) Close() error {
+	r.File.Close()
+	return r.conn.Close()
+}
+
+// Stat describes the file name
+func (s *SFTPStorage) Stat(ctx context.Context, name string) (Info, error) {
+	conn, err := s.connect(ctx)
+	if err != nil {
+		return Info{}, err
+	}
+	defer conn.Close()
+
+	info, err := conn.Stat(s.path(name))
+	if err != nil {
+		return Info{}, s.error("stat", name, err)
+	}
+	return Info{Name: info.Name(), Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// Remove deletes the file name
+func (s *SFTPStorage) Remove(ctx context.Context, name string) error {
+	conn, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.Remove(s.path(name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return s.error("remove", name, err)
+	}
+	return nil
+}
+
+// List describes the files of the directory, leaving out hidden files
+func (s *SFTPStorage) List(ctx context.Context) ([]Info, error) {
+	conn, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	dir := s.config.Directory
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := conn.ReadDir(dir)
+	if err != nil {
+		return nil, s.error("list", "", err)
+	}
+	var files []Info
+	for _, entry := range entries {
+		if !entry.Mode().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		files = append(files, Info{Name: entry.Name(), Size: entry.Size(), ModTime: entry.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, nil
+}
+
+// error names the remote file in err, which matches fs.ErrNotExist for
+// missing files
+func (s *SFTPStorage) error(op, name string, err error) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+	return &fs.PathError{Op: op, Path: s.Location(name), Err: err}
+}
@@ -0,0 +1,145 @@
+// Package storage keeps export files in the export directory, an
+// S3-compatible bucket or a directory on an SFTP server, behind one
+// interface, and holds the registry of the storages that are configured.
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Names of the storages
+const (
+	Local = "local"
+	S3    = "s3"
+	SFTP  = "sftp"
+)
+
+// Info describes a stored file
+type Info struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// Storage stores files by name in a flat namespace. Missing files are
+// reported with errors that match fs.ErrNotExist.
+type Storage interface {
+	// Name is the name the storage is registered under
+	Name() string
+
+	// Location describes where the file name is stored, as a path or URL,
+	// for logs and pages
+	Location(name string) string
+
+	// Create starts writing a new file. It fails with an error matching
+	// fs.ErrExist if the file exists.
+	Create(ctx context.Context, name string) (Writer, error)
+
+	// Open opens a file for reading
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+
+	// Stat describes a file
+	Stat(ctx context.Context, name string) (Info, error)
+
+	// Remove deletes a file. Removing a missing file is not an error.
+	Remove(ctx context.Context, name string) error
+
+	// List describes the stored files, sorted by name
+	List(ctx context.Context) ([]Info, error)
+}
+
+// Writer writes a new file. The file is complete once Close succeeds;
+// Abort discards what was written instead.
+type Writer interface {
+	io.WriteCloser
+	Abort() error
+}
+
+// ReadAll reads the whole file name, for small files such as manifests
+func ReadAll(ctx context.Context, s Storage, name string) ([]byte, error) {
+	r, err := s.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// WriteFile replaces the file name with data
+func WriteFile(ctx context.Context, s Storage, name string, data []byte) error {
+	if err := s.Remove(ctx, name); err != nil {
+		return err
+	}
+	w, err := s.Create(ctx, name)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Abort()
+		return err
+	}
+	return w.Close()
+}
+
+// cleanName keeps a file name from leaving the storage's directory
+func cleanName(name string) string {
+	return path.Base("/" + strings.ReplaceAll(name, `\`, "/"))
+}
+
+var (
+	mu       sync.RWMutex
+	registry = make(map[string]Storage)
+)
+
+// Register makes a storage available under its name. It panics if the name
+// is already taken.
+func Register(s Storage) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if s == nil {
+		panic("storage: Register storage is nil")
+	}
+	if _, dup := registry[s.Name()]; dup {
+		panic("storage: Register called twice for storage " + s.Name())
+	}
+	registry[s.Name()] = s
+}
+
+// Get returns the storage registered under name. An empty name is the
+// local export directory, where exports were kept before there were
+// storages.
+func Get(name string) (Storage, error) {
+	if name == "" {
+		name = Local
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	s, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown storage: %s", name)
+	}
+	return s, nil
+}
+
+// Names returns the sorted list of registered storage names
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -17,7 +17,7 @@ document.addEventListener('DOMContentLoaded', function() {
     const forms = document.querySelectorAll('form');
     forms.forEach(form => {
         form.addEventListener('submit', function(e) {
-            const requiredFields = form.querySelectorAll('[required]');
+            const requiredFields = form.querySelectorAll('[required]:not(:disabled)');
             let isValid = true;
 
             requiredFields.forEach(field => {
@@ -48,10 +48,38 @@ document.addEventListener('DOMContentLoaded', function() {
         });
     });
 
+    // Hide server connection fields for file based engines such as SQLite,
+    // and every connection field when a connection profile is chosen
+    const toggleServerFields = select => {
+        const form = select.closest('form');
+        const profile = form.querySelector('select[data-profile-select]');
+        const useProfile = profile !== null && profile.value !== '0';
+        const serverless = select.value === 'sqlite';
+        const toggle = (wrapper, hidden) => {
+            wrapper.classList.toggle('hidden', hidden);
+            wrapper.querySelectorAll('input, select').forEach(input => {
+                if (input.dataset.required === undefined) {
+                    input.dataset.required = input.required;
+                }
+                input.required = !hidden && input.dataset.required === 'true';
+            });
+        };
+        form.querySelectorAll('[data-server-field]').forEach(wrapper => toggle(wrapper, serverless || useProfile));
+        form.querySelectorAll('[data-connection-field]').forEach(wrapper => toggle(wrapper, useProfile));
+    };
+
+    document.querySelectorAll('select[data-profile-select]').forEach(profile => {
+        profile.addEventListener('change', () => {
+            toggleServerFields(profile.closest('form').querySelector('select[name="type"]'));
+        });
+    });
+
     // Auto-populate port based on database type
     const dbTypeSelects = document.querySelectorAll('select[name="type"]');
     dbTypeSelects.forEach(select => {
+        toggleServerFields(select);
         select.addEventListener('change', function() {
+            toggleServerFields(this);
             const portInput = this.closest('form').querySelector('input[name="port"]');
             if (portInput) {
                 switch (this.value) {
@@ -62,13 +90,151 @@ document.addEventListener('DOMContentLoaded', function() {
                     case 'postgres':
                         portInput.value = '5432';
                         break;
+                    case 'sqlite':
+                        portInput.value = '';
+                        break;
                 }
             }
         });
     });
 
+    // Show the options that apply to the chosen export format
+    const formatSelect = document.querySelector('select[name="format"]');
+    if (formatSelect) {
+        const toggleFormatOptions = () => {
+            const form = formatSelect.closest('form');
+            const format = formatSelect.value;
+            form.querySelectorAll('[data-sql-option]').forEach(el => {
+                el.classList.toggle('hidden', format !== 'sql');
+                // Leave options such as a schema only dump out of data exports
+                el.querySelectorAll('input').forEach(input => input.disabled = format !== 'sql');
+            });
+            form.querySelectorAll('[data-data-option]').forEach(el => el.classList.toggle('hidden', format === 'sql'));
+            form.querySelectorAll('[data-csv-option]').forEach(el => el.classList.toggle('hidden', format !== 'csv'));
+        };
+        toggleFormatOptions();
+        formatSelect.addEventListener('change', toggleFormatOptions);
+    }
+
+    // Offer the columns of the chosen table when loading a CSV file into an existing table
+    const csvTable = document.querySelector('select[data-csv-table]');
+    if (csvTable) {
+        const form = csvTable.closest('form');
+        const toggleCSVMode = () => {
+            const existing = form.querySelector('input[data-csv-mode]:checked').value === 'existing';
+            const option = csvTable.selectedOptions[0];
+            form.querySelector('input[name="newTable"]').disabled = existing;
+            csvTable.disabled = !existing;
+            form.querySelectorAll('input[data-csv-target]').forEach(input => {
+                if (existing && option) {
+                    input.setAttribute('list', option.dataset.columns);
+                } else {
+                    input.removeAttribute('list');
+                }
+            });
+            // Existing tables keep the types of their columns
+            form.querySelectorAll('select[data-csv-type]').forEach(select => select.disabled = existing);
+        };
+        toggleCSVMode();
+        form.querySelectorAll('input[data-csv-mode]').forEach(radio => radio.addEventListener('change', toggleCSVMode));
+        csvTable.addEventListener('change', toggleCSVMode);
+    }
+
+    // Switch the import form between uploading a file and restoring an export
+    const importSources = document.querySelectorAll('input[data-import-source]');
+    if (importSources.length > 0) {
+        const form = importSources[0].closest('form');
+        const toggleImportSource = () => {
+            const restore = form.querySelector('input[data-import-source]:checked').value === 'export';
+            const toggle = (wrapper, hidden) => {
+                wrapper.classList.toggle('hidden', hidden);
+                wrapper.querySelectorAll('input, select').forEach(input => input.disabled = hidden);
+            };
+            form.querySelectorAll('[data-upload-source]').forEach(wrapper => toggle(wrapper, restore));
+            form.querySelectorAll('[data-export-source]').forEach(wrapper => toggle(wrapper, !restore));
+        };
+        toggleImportSource();
+        importSources.forEach(radio => radio.addEventListener('change', toggleImportSource));
+    }
+
+    // Submit export/import forms in the background and follow the job's progress
+    document.querySelectorAll('form[data-job-form]').forEach(form => {
+        form.addEventListener('submit', function(e) {
+            // Buttons such as "Load tables" submit the form to another page
+            if (e.defaultPrevented || !window.EventSource || (e.submitter && e.submitter.hasAttribute('formaction'))) {
+                return;
+            }
+            e.preventDefault();
+
+            const panel = document.querySelector('[data-job-progress]');
+            const button = form.querySelector('button[type="submit"]');
+            button.disabled = true;
+            resetProgress(panel);
+            panel.classList.remove('hidden');
+
+            const xhr = new XMLHttpRequest();
+            xhr.open('POST', form.action);
+            xhr.setRequestHeader('Accept', 'application/json');
+            xhr.upload.addEventListener('progress', ev => {
+                if (ev.lengthComputable) {
+                    showProgress(panel, 'Uploading...', ev.loaded, ev.total);
+                }
+            });
+            xhr.addEventListener('load', () => {
+                button.disabled = false;
+                let body = {};
+                try {
+                    body = JSON.parse(xhr.responseText);
+                } catch (err) {
+                    // Not JSON, fall through to the generic error
+                }
+                if (xhr.status !== 202) {
+                    showResult(panel, false, body.error || 'Request failed with status ' + xhr.status);
+                    return;
+                }
+                followJob(panel, body.id);
+            });
+            xhr.addEventListener('error', () => {
+                button.disabled = false;
+                showResult(panel, false, 'The request could not be sent');
+            });
+            xhr.send(new FormData(form));
+        });
+    });
+
+    // Cancel the job a progress panel is following
+    document.querySelectorAll('form[data-job-cancel]').forEach(form => {
+        form.addEventListener('submit', function(e) {
+            e.preventDefault();
+            const panel = form.closest('[data-job-progress]');
+            form.querySelector('button').disabled = true;
+            fetch('/jobs/' + panel.dataset.jobId + '/cancel', {
+                method: 'POST',
+                headers: { 'Accept': 'application/json' }
+            }).then(response => {
+                if (!response.ok) {
+                    return response.json().then(body => showResult(panel, false, body.error));
+                }
+            }).catch(() => showResult(panel, false, 'The cancel request could not be sent'));
+        });
+    });
+
+    // Pages rendered for a running job connect straight away
+    document.querySelectorAll('[data-job-progress][data-job-id]').forEach(panel => {
+        followJob(panel, panel.dataset.jobId);
+    });
+
+    // Ask before submitting destructive forms such as deleting an export
+    document.querySelectorAll('form[data-confirm]').forEach(form => {
+        form.addEventListener('submit', function(e) {
+            if (!confirm(form.dataset.confirm)) {
+                e.preventDefault();
+            }
+        });
+    });
+
     // Success message animation
-    const successMessages = document.querySelectorAll('.bg-green-100');
+    const successMessages = document.querySelectorAll('.bg-green-100:not([data-persist])');
     successMessages.forEach(message => {
         message.classList.add('success-message');
         
@@ -81,4 +247,114 @@ document.addEventListener('DOMContentLoaded', function() {
             }, 500);
         }, 5000);
     });
-}); 
\ No newline at end of file
+}); 
+
+// Follow a background job over Server-Sent Events, updating its progress panel
+function followJob(panel, id) {
+    panel.dataset.jobId = id;
+    const cancel = panel.querySelector('[data-job-cancel]');
+    cancel.classList.remove('hidden');
+    cancel.querySelector('button').disabled = false;
+
+    const source = new EventSource('/jobs/' + id + '/events');
+
+    source.addEventListener('progress', ev => {
+        const progress = JSON.parse(ev.data);
+        const phase = progress.status === 'queued'
+            ? 'Waiting for a free worker...'
+            : 'Running for ' + formatDuration(progress.elapsedSeconds);
+        showProgress(panel, phase, progress.bytesProcessed, progress.totalBytes);
+        panel.querySelector('[data-job-table]').textContent = progress.table || '-';
+    });
+
+    source.addEventListener('stderr', ev => {
+        const log = panel.querySelector('[data-job-log]');
+        log.classList.remove('hidden');
+        log.textContent += JSON.parse(ev.data) + '\n';
+        log.scrollTop = log.scrollHeight;
+    });
+
+    source.addEventListener('done', ev => {
+        source.close();
+        panel.querySelector('[data-job-cancel]').classList.add('hidden');
+        const job = JSON.parse(ev.data);
+        if (job.status === 'succeeded') {
+            showProgress(panel, 'Finished in ' + formatDuration(job.elapsedSeconds), job.bytesProcessed, job.totalBytes || job.bytesProcessed);
+            const result = job.result || {};
+            let message = job.description + ' finished successfully.';
+            if (result.loaded !== undefined) {
+                message += ' Loaded ' + result.loaded + ' rows, rejected ' + (result.rejected || 0) + '.';
+            }
+            showResult(panel, true, message, result.downloadLink, result.file);
+        } else {
+            showResult(panel, false, job.error || 'The job was ' + job.status);
+        }
+    });
+}
+
+function resetProgress(panel) {
+    showProgress(panel, 'Starting...', 0, 0);
+    panel.querySelector('[data-job-table]').textContent = '-';
+    panel.querySelector('[data-job-result]').replaceChildren();
+    const log = panel.querySelector('[data-job-log]');
+    log.textContent = '';
+    log.classList.add('hidden');
+    // Nothing can be cancelled until the server has accepted the job
+    panel.querySelector('[data-job-cancel]').classList.add('hidden');
+}
+
+function showProgress(panel, phase, bytes, total) {
+    const bar = panel.querySelector('[data-job-bar]');
+    panel.querySelector('[data-job-phase]').textContent = phase;
+    if (total > 0) {
+        const percent = Math.min(100, Math.round(bytes / total * 100));
+        bar.style.width = percent + '%';
+        bar.classList.remove('animate-pulse');
+        panel.querySelector('[data-job-bytes]').textContent = formatBytes(bytes) + ' of ' + formatBytes(total) + ' (' + percent + '%)';
+    } else {
+        // The size of an export is not known until it finishes
+        bar.style.width = bytes > 0 ? '100%' : '0%';
+        bar.classList.add('animate-pulse');
+        panel.querySelector('[data-job-bytes]').textContent = bytes > 0 ? formatBytes(bytes) : '';
+    }
+}
+
+function showResult(panel, success, message, link, linkText) {
+    const box = document.createElement('div');
+    box.className = success
+        ? 'bg-green-100 border-l-4 border-green-500 text-green-700 p-4'
+        : 'bg-red-100 border-l-4 border-red-500 text-red-700 p-4 whitespace-pre-line';
+    box.setAttribute('role', 'alert');
+
+    const text = document.createElement('p');
+    text.textContent = message;
+    box.appendChild(text);
+
+    if (link) {
+        const anchor = document.createElement('a');
+        anchor.href = link;
+        anchor.textContent = 'Download ' + (linkText || 'file');
+        anchor.className = 'inline-block mt-4 bg-blue-600 hover:bg-blue-700 text-white font-medium py-2 px-4 rounded transition-colors';
+        box.appendChild(anchor);
+    }
+
+    if (!success) {
+        panel.querySelector('[data-job-bar]').classList.remove('animate-pulse');
+    }
+    panel.querySelector('[data-job-result]').replaceChildren(box);
+}
+
+function formatBytes(bytes) {
+    const units = ['B', 'KB', 'MB', 'GB', 'TB'];
+    let i = 0;
+    while (bytes >= 1024 && i < units.length - 1) {
+        bytes /= 1024;
+        i++;
+    }
+    return (i === 0 ? bytes : bytes.toFixed(1)) + ' ' + units[i];
+}
+
+function formatDuration(seconds) {
+    const minutes = Math.floor(seconds / 60);
+    return minutes > 0 ? minutes + 'm ' + (seconds % 60) + 's' : seconds + 's';
+}